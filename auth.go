@@ -0,0 +1,368 @@
+package p9p
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Authenticator verifies the identity of clients on the server side through
+// the auth file protocol from attach(5). A client sends Tauth to establish an
+// auth fid (afid), then reads and writes the afid to hold a conversation with
+// the server. Once the conversation completes, the client passes the afid in
+// Tattach, which only succeeds if the conversation verified the user.
+type Authenticator interface {
+	// Start begins a conversation to authenticate uname for attaching to
+	// aname.
+	Start(ctx context.Context, uname, aname string) (AuthConversation, error)
+}
+
+// AuthConversation is the server side of a conversation over an afid. Each
+// Tread and Twrite on the afid is passed to the conversation in order. The
+// offset of the requests are ignored, since the conversation is a stream.
+type AuthConversation interface {
+	// Read fills p with the next message to the client.
+	Read(ctx context.Context, p []byte) (int, error)
+
+	// Write handles a message from the client.
+	Write(ctx context.Context, p []byte) (int, error)
+
+	// Identity returns the verified identity once the conversation is
+	// complete. If the conversation is incomplete or has failed, an error
+	// is returned.
+	Identity() (AuthInfo, error)
+}
+
+// AuthInfo describes an identity verified by an Authenticator.
+type AuthInfo struct {
+	Uname  string // user verified by the conversation
+	Method string // name of the mechanism, for logging
+}
+
+// NewAuthSession returns a session that authenticates attaches to session
+// with auth. Every Tattach must carry an afid that has completed a
+// conversation for the same uname and aname. The verified identity is
+// available to session.Attach through GetAuthInfo.
+//
+// The afids are handled entirely by the returned session, so session never
+// sees them. Afids share the fid space of the connection, so the returned
+// session rejects requests on any other fid that collides with an afid.
+//
+// If session implements SessionL, so does the returned session.
+func NewAuthSession(session Session, auth Authenticator) Session {
+	as := &authSession{
+		Session: session,
+		auth:    auth,
+		afids:   make(map[Fid]*authFid),
+	}
+
+	if sessionl, ok := session.(SessionL); ok {
+		return &authSessionL{authSession: as, sessionl: sessionl}
+	}
+
+	return as
+}
+
+type authSession struct {
+	Session
+	auth Authenticator
+
+	mu    sync.Mutex
+	afids map[Fid]*authFid
+	path  uint64 // qid path for the next afid
+}
+
+type authFid struct {
+	mu    sync.Mutex // serializes the conversation
+	conv  AuthConversation
+	uname string
+	aname string
+	qid   Qid
+}
+
+func (as *authSession) getAfid(fid Fid) (*authFid, bool) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	af, ok := as.afids[fid]
+	return af, ok
+}
+
+func (as *authSession) Auth(ctx context.Context, afid Fid, uname, aname string) (Qid, error) {
+	if afid == NOFID {
+		return Qid{}, ErrUnknownfid
+	}
+
+	if _, ok := as.getAfid(afid); ok {
+		return Qid{}, ErrDupfid
+	}
+
+	conv, err := as.auth.Start(ctx, uname, aname)
+	if err != nil {
+		return Qid{}, err
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	if _, ok := as.afids[afid]; ok {
+		return Qid{}, ErrDupfid
+	}
+
+	as.path++
+	af := &authFid{
+		conv:  conv,
+		uname: uname,
+		aname: aname,
+		qid:   Qid{Type: QTAUTH, Path: as.path},
+	}
+	as.afids[afid] = af
+
+	return af.qid, nil
+}
+
+func (as *authSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	if afid == NOFID {
+		return Qid{}, ErrAuthRequired
+	}
+
+	if _, ok := as.getAfid(fid); ok {
+		return Qid{}, ErrDupfid
+	}
+
+	af, ok := as.getAfid(afid)
+	if !ok {
+		return Qid{}, ErrUnknownfid
+	}
+
+	af.mu.Lock()
+	info, err := af.conv.Identity()
+	af.mu.Unlock()
+	if err != nil {
+		return Qid{}, err
+	}
+
+	if af.uname != uname || af.aname != aname || info.Uname != uname {
+		return Qid{}, ErrAuthFailed
+	}
+
+	return as.Session.Attach(withAuthInfo(ctx, info), fid, NOFID, uname, aname)
+}
+
+func (as *authSession) Clunk(ctx context.Context, fid Fid) error {
+	as.mu.Lock()
+	_, ok := as.afids[fid]
+	delete(as.afids, fid)
+	as.mu.Unlock()
+
+	if ok {
+		return nil
+	}
+
+	return as.Session.Clunk(ctx, fid)
+}
+
+func (as *authSession) Remove(ctx context.Context, fid Fid) error {
+	if _, ok := as.getAfid(fid); ok {
+		as.Clunk(ctx, fid)
+		return ErrNoremove
+	}
+
+	return as.Session.Remove(ctx, fid)
+}
+
+func (as *authSession) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return nil, ErrWalknodir
+	}
+
+	if _, ok := as.getAfid(newfid); ok {
+		return nil, ErrDupfid
+	}
+
+	return as.Session.Walk(ctx, fid, newfid, names...)
+}
+
+func (as *authSession) Read(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	af, ok := as.getAfid(fid)
+	if !ok {
+		return as.Session.Read(ctx, fid, p, offset)
+	}
+
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	n, err := af.conv.Read(ctx, p)
+	if err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (as *authSession) Write(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	af, ok := as.getAfid(fid)
+	if !ok {
+		return as.Session.Write(ctx, fid, p, offset)
+	}
+
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	return af.conv.Write(ctx, p)
+}
+
+func (as *authSession) Open(ctx context.Context, fid Fid, mode Flag) (Qid, uint32, error) {
+	if af, ok := as.getAfid(fid); ok {
+		// afids are ready for i/o once created, but some clients open
+		// them anyway.
+		return af.qid, 0, nil
+	}
+
+	return as.Session.Open(ctx, fid, mode)
+}
+
+func (as *authSession) Create(ctx context.Context, parent Fid, name string, perm uint32, mode Flag) (Qid, uint32, error) {
+	if _, ok := as.getAfid(parent); ok {
+		return Qid{}, 0, ErrCreatenondir
+	}
+
+	return as.Session.Create(ctx, parent, name, perm, mode)
+}
+
+func (as *authSession) Stat(ctx context.Context, fid Fid) (Dir, error) {
+	if af, ok := as.getAfid(fid); ok {
+		return Dir{
+			Qid:  af.qid,
+			Mode: DMAUTH | 0600,
+			Name: "afid",
+			UID:  af.uname,
+			GID:  af.uname,
+			MUID: af.uname,
+		}, nil
+	}
+
+	return as.Session.Stat(ctx, fid)
+}
+
+func (as *authSession) WStat(ctx context.Context, fid Fid, dir Dir) error {
+	if _, ok := as.getAfid(fid); ok {
+		return ErrNowstat
+	}
+
+	return as.Session.WStat(ctx, fid, dir)
+}
+
+// authSessionL passes the 9P2000.L extension messages through to a session
+// that supports them. None of them apply to afids.
+type authSessionL struct {
+	*authSession
+	sessionl SessionL
+}
+
+func (as *authSessionL) Statfs(ctx context.Context, fid Fid) (StatFS, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return StatFS{}, ErrUnknownfid
+	}
+
+	return as.sessionl.Statfs(ctx, fid)
+}
+
+func (as *authSessionL) Getattr(ctx context.Context, fid Fid, mask uint64) (Attr, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return Attr{}, ErrUnknownfid
+	}
+
+	return as.sessionl.Getattr(ctx, fid, mask)
+}
+
+func (as *authSessionL) XattrWalk(ctx context.Context, fid, newfid Fid, name string) (uint64, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return 0, ErrUnknownfid
+	}
+
+	if _, ok := as.getAfid(newfid); ok {
+		return 0, ErrDupfid
+	}
+
+	return as.sessionl.XattrWalk(ctx, fid, newfid, name)
+}
+
+func (as *authSessionL) XattrCreate(ctx context.Context, fid Fid, name string, size uint64, flags uint32) error {
+	if _, ok := as.getAfid(fid); ok {
+		return ErrUnknownfid
+	}
+
+	return as.sessionl.XattrCreate(ctx, fid, name, size, flags)
+}
+
+func (as *authSessionL) Fsync(ctx context.Context, fid Fid, datasync bool) error {
+	if _, ok := as.getAfid(fid); ok {
+		return nil
+	}
+
+	return as.sessionl.Fsync(ctx, fid, datasync)
+}
+
+func (as *authSessionL) Lock(ctx context.Context, fid Fid, lock Lock) (uint8, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return LockError, ErrUnknownfid
+	}
+
+	return as.sessionl.Lock(ctx, fid, lock)
+}
+
+func (as *authSessionL) GetLock(ctx context.Context, fid Fid, lock Lock) (Lock, error) {
+	if _, ok := as.getAfid(fid); ok {
+		return Lock{}, ErrUnknownfid
+	}
+
+	return as.sessionl.GetLock(ctx, fid, lock)
+}
+
+// AuthClient is the client side of an auth conversation, matching an
+// Authenticator on the server.
+type AuthClient interface {
+	// Authenticate holds the conversation for uname and aname over rw,
+	// which reads and writes the afid.
+	Authenticate(ctx context.Context, rw io.ReadWriter, uname, aname string) error
+}
+
+// Authenticate establishes afid on the session with Tauth and drives the
+// conversation with client. On success, afid may be passed to Attach with
+// the same uname and aname. On failure, afid is clunked.
+func Authenticate(ctx context.Context, session Session, afid Fid, uname, aname string, client AuthClient) error {
+	if _, err := session.Auth(ctx, afid, uname, aname); err != nil {
+		return err
+	}
+
+	rw := &afidReadWriter{ctx: ctx, session: session, fid: afid}
+	if err := client.Authenticate(ctx, rw, uname, aname); err != nil {
+		session.Clunk(ctx, afid)
+		return err
+	}
+
+	return nil
+}
+
+// afidReadWriter provides an io.ReadWriter over an afid for the client side
+// of the conversation.
+type afidReadWriter struct {
+	ctx     context.Context
+	session Session
+	fid     Fid
+	offset  int64
+}
+
+func (rw *afidReadWriter) Read(p []byte) (int, error) {
+	n, err := rw.session.Read(rw.ctx, rw.fid, p, rw.offset)
+	rw.offset += int64(n)
+	if err == nil && n == 0 && len(p) > 0 {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (rw *afidReadWriter) Write(p []byte) (int, error) {
+	n, err := rw.session.Write(rw.ctx, rw.fid, p, rw.offset)
+	rw.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,166 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// secretAuth accepts any user that writes the secret to the afid.
+type secretAuth struct {
+	secret string
+}
+
+func (a secretAuth) Start(ctx context.Context, uname, aname string) (AuthConversation, error) {
+	return &secretConversation{secret: a.secret, uname: uname}, nil
+}
+
+type secretConversation struct {
+	secret, uname string
+	verified      bool
+}
+
+func (c *secretConversation) Read(ctx context.Context, p []byte) (int, error) {
+	return copy(p, "secret?"), nil
+}
+
+func (c *secretConversation) Write(ctx context.Context, p []byte) (int, error) {
+	c.verified = string(p) == c.secret
+	return len(p), nil
+}
+
+func (c *secretConversation) Identity() (AuthInfo, error) {
+	if !c.verified {
+		return AuthInfo{}, ErrAuthFailed
+	}
+
+	return AuthInfo{Uname: c.uname, Method: "secret"}, nil
+}
+
+type secretClient string
+
+func (s secretClient) Authenticate(ctx context.Context, rw io.ReadWriter, uname, aname string) error {
+	p := make([]byte, 64)
+	n, err := rw.Read(p)
+	if err != nil {
+		return err
+	}
+
+	if string(p[:n]) != "secret?" {
+		return errors.New("unexpected challenge")
+	}
+
+	_, err = rw.Write([]byte(s))
+	return err
+}
+
+// attachSession records the identity passed to Attach.
+type attachSession struct {
+	Session
+	info AuthInfo
+}
+
+func (s *attachSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	info, ok := GetAuthInfo(ctx)
+	if !ok {
+		return Qid{}, errors.New("no auth info")
+	}
+
+	s.info = info
+	return Qid{Type: QTDIR}, nil
+}
+
+func TestAuthSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner := &attachSession{}
+	session, closer, err := Pipe(ctx, Dispatch(NewAuthSession(inner, secretAuth{secret: "open sesame"})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	if _, err := session.Attach(ctx, 1, NOFID, "user", ""); !errors.Is(err, ErrAuthRequired) {
+		t.Fatalf("expected %v attaching without afid, got %v", ErrAuthRequired, err)
+	}
+
+	if err := Authenticate(ctx, session, 10, "user", "", secretClient("wrong")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, 10, "user", ""); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected %v with wrong secret, got %v", ErrAuthFailed, err)
+	}
+
+	if err := session.Clunk(ctx, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Authenticate(ctx, session, 10, "user", "", secretClient("open sesame")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, 10, "other", ""); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected %v attaching as another user, got %v", ErrAuthFailed, err)
+	}
+
+	if _, err := session.Attach(ctx, 1, 10, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if inner.info.Uname != "user" || inner.info.Method != "secret" {
+		t.Fatalf("unexpected auth info: %+v", inner.info)
+	}
+}
+
+func TestKeyAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	keys, err := ParseKeyring(strings.NewReader(`
+# users
+glenda 0102030405060708
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner := &attachSession{}
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	go ServeConn(ctx, sconn, Dispatch(NewAuthSession(inner, KeyAuth{Keyring: keys})))
+
+	session, err := NewSession(ctx, cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testcase := range []struct {
+		uname string
+		key   []byte
+	}{
+		{uname: "glenda", key: []byte{1, 2, 3, 4, 5, 6, 7, 9}},
+		{uname: "nobody", key: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	} {
+		if err := Authenticate(ctx, session, 10, testcase.uname, "", KeyAuthClient{Key: testcase.key}); !errors.Is(err, ErrAuthFailed) {
+			t.Fatalf("%v: expected %v, got %v", testcase.uname, ErrAuthFailed, err)
+		}
+	}
+
+	if err := Authenticate(ctx, session, 10, "glenda", "", KeyAuthClient{Key: keys["glenda"]}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, 10, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if inner.info.Uname != "glenda" || inner.info.Method != KeyAuthMethod {
+		t.Fatalf("unexpected auth info: %+v", inner.info)
+	}
+}
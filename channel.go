@@ -2,8 +2,10 @@ package p9p
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -30,7 +32,8 @@ type Channel interface {
 	ReadFcall(ctx context.Context, fcall *Fcall) error
 
 	// WriteFcall writes the provided fcall to the channel. WriteFcall cannot
-	// be called concurrently with other calls to WriteFcall.
+	// be called concurrently with other calls to WriteFcall. The fcall must
+	// not be retained after the call returns, as its buffers may be reused.
 	WriteFcall(ctx context.Context, fcall *Fcall) error
 
 	// MSize returns the current msize for the channel.
@@ -79,10 +82,8 @@ type channel struct {
 	conn   net.Conn
 	codec  Codec
 	brd    *bufio.Reader
-	bwr    *bufio.Writer
 	closed chan struct{}
 	msize  int
-	rdbuf  []byte
 }
 
 func newChannel(conn net.Conn, codec Codec, msize int) *channel {
@@ -90,10 +91,8 @@ func newChannel(conn net.Conn, codec Codec, msize int) *channel {
 		conn:   conn,
 		codec:  codec,
 		brd:    bufio.NewReaderSize(conn, msize), // msize may not be optimal buffer size
-		bwr:    bufio.NewWriterSize(conn, msize),
 		closed: make(chan struct{}),
 		msize:  msize,
-		rdbuf:  make([]byte, msize),
 	}
 }
 
@@ -101,26 +100,34 @@ func (ch *channel) MSize() int {
 	return ch.msize
 }
 
-// setmsize resizes the buffers for use with a separate msize. This call must
-// be protected by a mutex or made before passing to other goroutines.
+// SetMSize resizes the read buffer for use with a separate msize. This call
+// must be protected by a mutex or made before passing to other goroutines.
 func (ch *channel) SetMSize(msize int) {
-	// NOTE(stevvooe): We cannot safely resize the buffered reader and writer.
-	// Proceed assuming that original size is sufficient.
-
 	ch.msize = msize
-	if msize < len(ch.rdbuf) {
-		// just change the cap
-		ch.rdbuf = ch.rdbuf[:msize]
-		return
-	}
 
-	ch.rdbuf = make([]byte, msize)
+	if ch.brd.Size() != msize {
+		// The reader may hold messages that followed the negotiation, which
+		// are carried over to the new reader.
+		rd := io.Reader(ch.conn)
+		if n := ch.brd.Buffered(); n > 0 {
+			p, _ := ch.brd.Peek(n)
+			rd = io.MultiReader(bytes.NewReader(append([]byte(nil), p...)), ch.conn)
+		}
+
+		ch.brd = bufio.NewReaderSize(rd, msize)
+	}
 }
 
 // ReadFcall reads the next message from the channel into fcall.
 //
 // If the incoming message overflows the msize, Overflow(err) will return
 // nonzero with the number of bytes overflowed.
+//
+// Each message is read into a pooled buffer, released once it is decoded.
+// The data of Rread and Twrite messages aliases the buffer, which is then
+// kept with the buffers of the request on ctx, as set up by ServeConn, and
+// released after the response is written. Without them, the buffer is left
+// to the garbage collector, so that fcall may be retained after the call.
 func (ch *channel) ReadFcall(ctx context.Context, fcall *Fcall) error {
 	select {
 	case <-ctx.Done():
@@ -139,22 +146,20 @@ func (ch *channel) ReadFcall(ctx context.Context, fcall *Fcall) error {
 		log.Printf("p9p: transport: error setting read deadline on %v: %v", ch.conn.RemoteAddr(), err)
 	}
 
-	n, err := readmsg(ch.brd, ch.rdbuf)
+	p, err := readframe(ch.brd, ch.msize)
 	if err != nil {
-		// TODO(stevvooe): There may be more we can do here to detect partial
-		// reads. For now, we just propagate the error untouched.
+		// errors after part of the frame was read are not temporary, so
+		// callers only retry reads that consumed nothing.
 		return err
 	}
 
-	if n > len(ch.rdbuf) {
-		return overflowErr{size: n - len(ch.rdbuf)}
-	}
-
 	// clear out the fcall
 	*fcall = Fcall{}
-	if err := ch.codec.Unmarshal(ch.rdbuf[:n], fcall); err != nil {
+	if err := ch.codec.Unmarshal(p, fcall); err != nil {
+		putbuf(p)
 		return err
 	}
+	keep(ctx, p, fcall)
 
 	if err := ch.maybeTruncate(fcall); err != nil {
 		return err
@@ -191,16 +196,43 @@ func (ch *channel) WriteFcall(ctx context.Context, fcall *Fcall) error {
 		return err
 	}
 
+	if bc, ok := ch.codec.(BufferCodec); ok {
+		// marshal after the size into a pooled buffer, written to the
+		// connection as is.
+		p := getbuf(max(ch.msize, channelMessageHeaderSize))
+		defer putbuf(p)
+
+		n, err := bc.MarshalTo(p[channelMessageHeaderSize:], fcall)
+		if err == nil {
+			n += channelMessageHeaderSize
+			binary.LittleEndian.PutUint32(p, uint32(n))
+			_, err := ch.conn.Write(p[:n])
+			return err
+		} else if err != io.ErrShortBuffer {
+			return err
+		}
+	}
+
 	p, err := ch.codec.Marshal(fcall)
 	if err != nil {
 		return err
 	}
 
-	if err := sendmsg(ch.bwr, p); err != nil {
-		return err
+	return sendmsg(ch.conn, p)
+}
+
+// keep releases the frame p that fcall was decoded from, unless the data of
+// fcall aliases it. Such frames are kept with the buffers on ctx, if any.
+func keep(ctx context.Context, p []byte, fcall *Fcall) {
+	switch fcall.Message.(type) {
+	case MessageRread, MessageTwrite:
+		if b, ok := ctx.Value(buffersKey).(*buffers); ok {
+			b.keep(p)
+		}
+		return
 	}
 
-	return ch.bwr.Flush()
+	putbuf(p)
 }
 
 // maybeTruncate will truncate the message to fit into msize on the wire, if
@@ -283,44 +315,56 @@ func (ch *channel) msgmsize(fcall *Fcall) int {
 	return channelMessageHeaderSize + ch.codec.Size(fcall)
 }
 
-// readmsg reads a 9p message into p from rd, ensuring that all bytes are
-// consumed from the size header. If the size header indicates the message is
-// larger than p, the entire message will be discarded, leaving a truncated
-// portion in p. Any error should be treated as a framing error unless n is
-// zero. The caller must check that n is less than or equal to len(p) to
-// ensure that a valid message has been read.
-func readmsg(rd io.Reader, p []byte) (n int, err error) {
-	var msize uint32
-
-	if err := binary.Read(rd, binary.LittleEndian, &msize); err != nil {
-		return 0, err
+// readframe reads the next 9p message from rd into a buffer from getbuf,
+// returning the message without the size header. The caller returns the
+// buffer with putbuf. Messages larger than msize are discarded, returning an
+// overflow error.
+func readframe(rd io.Reader, msize int) ([]byte, error) {
+	var hdr [channelMessageHeaderSize]byte
+	if n, err := io.ReadFull(rd, hdr[:]); err != nil {
+		if n > 0 {
+			return nil, partialFrameErr{err: err}
+		}
+		return nil, err
 	}
 
-	n += binary.Size(msize)
-	mbody := int(msize) - 4
-
-	if mbody < len(p) {
-		p = p[:mbody]
+	size := int(binary.LittleEndian.Uint32(hdr[:]))
+	if size < channelMessageHeaderSize {
+		return nil, fmt.Errorf("p9p: invalid message size %v", size)
 	}
 
-	np, err := io.ReadFull(rd, p)
-	if err != nil {
-		return np + n, err
-	}
-	n += np
-
-	if mbody > len(p) {
-		// message has been read up to len(p) but we must consume the entire
-		// message. This is an error condition but is non-fatal if we can
-		// consume msize bytes.
-		nn, err := io.CopyN(ioutil.Discard, rd, int64(mbody-len(p)))
-		n += int(nn)
-		if err != nil {
-			return n, err
+	if size > msize {
+		// consume the message, so the error is not fatal to the channel.
+		if _, err := io.CopyN(ioutil.Discard, rd, int64(size-channelMessageHeaderSize)); err != nil {
+			return nil, partialFrameErr{err: err}
 		}
+
+		return nil, overflowErr{size: size - msize}
 	}
 
-	return n, nil
+	p := getbuf(size - channelMessageHeaderSize)
+	if _, err := io.ReadFull(rd, p); err != nil {
+		putbuf(p)
+		return nil, partialFrameErr{err: err}
+	}
+
+	return p, nil
+}
+
+// partialFrameErr is returned when reading a frame fails after part of it was
+// consumed. The start of the next frame is lost, so it is fatal to the
+// channel, even if err is temporary, such as a timeout. It is deliberately not
+// a net.Error.
+type partialFrameErr struct {
+	err error
+}
+
+func (e partialFrameErr) Error() string {
+	return fmt.Sprintf("p9p: partial frame: %v", e.err)
+}
+
+func (e partialFrameErr) Unwrap() error {
+	return e.err
 }
 
 // sendmsg writes a message of len(p) to wr with a 9p size header. All errors
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"net"
 	"testing"
 	"time"
@@ -201,13 +202,13 @@ func TestTreadRewrite(t *testing.T) {
 	}
 
 	// just read the message off the buffer
-	n, err := readmsg(&conn.buf, buf)
+	p, err := readframe(&conn.buf, msize)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	*fcall = Fcall{}
-	if err := ch.(*channel).codec.Unmarshal(buf[:n], fcall); err != nil {
+	if err := ch.(*channel).codec.Unmarshal(p, fcall); err != nil {
 		t.Fatal(err)
 	}
 
@@ -221,6 +222,185 @@ func TestTreadRewrite(t *testing.T) {
 	}
 }
 
+// TestSetMSize ensures that the read buffer is resized to the negotiated
+// msize without losing messages read ahead of the negotiation.
+func TestSetMSize(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		conn  = &mockConn{}
+		ch    = newChannel(conn, codec9p{}, DefaultMSize)
+		codec = ch.codec
+	)
+
+	for _, fcall := range []*Fcall{
+		newFcall(NOTAG, MessageRversion{MSize: 8192, Version: DefaultVersion}),
+		newFcall(1, MessageRread{Data: []byte("hello")}),
+	} {
+		p, err := codec.Marshal(fcall)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sendmsg(&conn.buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var fcall Fcall
+	if err := ch.ReadFcall(ctx, &fcall); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.SetMSize(8192)
+	if ch.MSize() != 8192 || ch.brd.Size() != 8192 {
+		t.Fatalf("read buffer not resized: %v", ch.brd.Size())
+	}
+
+	if err := ch.ReadFcall(ctx, &fcall); err != nil {
+		t.Fatal(err)
+	}
+
+	if data := fcall.Message.(MessageRread).Data; string(data) != "hello" {
+		t.Fatalf("unexpected data after resize: %q", data)
+	}
+}
+
+// TestReadFcallPartial ensures that reads failing after consuming part of a
+// frame are not temporary, since the channel cannot be read further.
+func TestReadFcallPartial(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		conn = &timeoutConn{}
+		ch   = newChannel(conn, codec9p{}, DefaultMSize)
+	)
+
+	var fcall Fcall
+	err := ch.ReadFcall(ctx, &fcall)
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expected timeout without a frame, got %v", err)
+	}
+
+	for _, partial := range [][]byte{
+		{0x10, 0x00},             // part of the size
+		{0x10, 0x00, 0x00, 0x00}, // the size only
+	} {
+		conn.buf.Write(partial)
+
+		err := ch.ReadFcall(ctx, &fcall)
+		if _, ok := err.(net.Error); ok || !errors.Is(err, temporaryFault{}) {
+			t.Fatalf("expected fatal error after partial frame %x, got %v", partial, err)
+		}
+	}
+}
+
+// TestReadFcallKeep ensures that the data of a Twrite aliases its frame,
+// which is kept with the buffers on the context until they are released.
+func TestReadFcallKeep(t *testing.T) {
+	var (
+		conn = &mockConn{}
+		ch   = newChannel(conn, codec9p{}, DefaultMSize)
+		bufs = new(buffers)
+		ctx  = withBuffers(context.Background(), bufs)
+	)
+
+	for _, msg := range []Message{
+		MessageTwrite{Fid: 1, Data: []byte("hello")},
+		MessageTclunk{Fid: 1},
+	} {
+		p, err := ch.codec.Marshal(newFcall(1, msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := sendmsg(&conn.buf, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var write, clunk Fcall
+	if err := ch.ReadFcall(ctx, &write); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ch.ReadFcall(ctx, &clunk); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the frame of the write is kept.
+	if len(bufs.bufs) != 1 {
+		t.Fatalf("expected the frame of the write to be kept, got %v buffers", len(bufs.bufs))
+	}
+
+	data := write.Message.(MessageTwrite).Data
+	if string(data) != "hello" || &bufs.bufs[0][len(bufs.bufs[0])-len(data)] != &data[0] {
+		t.Fatalf("data doesn't alias the kept frame: %q", data)
+	}
+
+	bufs.release()
+}
+
+// BenchmarkReadFcall reads messages through a channel as ServeConn does,
+// releasing the buffers of each request once it is handled.
+func BenchmarkReadFcall(b *testing.B) {
+	for name, fcall := range benchmarkFcalls() {
+		b.Run(name, func(b *testing.B) {
+			var frame bytes.Buffer
+			p, err := codec9p{}.Marshal(fcall)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if err := sendmsg(&frame, p); err != nil {
+				b.Fatal(err)
+			}
+
+			var (
+				conn = &loopConn{frame: frame.Bytes()}
+				ch   = newChannel(conn, codec9p{}, DefaultMSize)
+			)
+
+			b.SetBytes(int64(frame.Len()))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var (
+					fcall Fcall
+					bufs  buffers
+				)
+
+				if err := ch.ReadFcall(withBuffers(context.Background(), &bufs), &fcall); err != nil {
+					b.Fatal(err)
+				}
+				bufs.release()
+			}
+		})
+	}
+}
+
+// loopConn reads frame over and over.
+type loopConn struct {
+	mockConn
+	frame []byte
+	off   int
+}
+
+func (m *loopConn) Read(p []byte) (int, error) {
+	n := copy(p, m.frame[m.off:])
+	m.off = (m.off + n) % len(m.frame)
+	return n, nil
+}
+
+// timeoutConn times out reads once its buffer is empty.
+type timeoutConn struct {
+	mockConn
+}
+
+func (m *timeoutConn) Read(p []byte) (int, error) {
+	if m.buf.Len() == 0 {
+		return 0, temporaryFault{}
+	}
+	return m.buf.Read(p)
+}
+
 type mockConn struct {
 	net.Conn
 	buf bytes.Buffer
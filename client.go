@@ -17,24 +17,55 @@ type client struct {
 // NewSession returns a session using the connection. The Context ctx provides
 // a context for out of bad messages, such as flushes, that may be sent by the
 // session. The session can effectively shutdown with this context.
-func NewSession(ctx context.Context, conn net.Conn) (Session, error) {
-	ch := newChannel(conn, codec9p{}, DefaultMSize) // sets msize, effectively.
+//
+// If conn is a tls.Conn, the handshake is completed before negotiating the
+// version.
+func NewSession(ctx context.Context, conn net.Conn, opts ...Option) (Session, error) {
+	o := newOptions(opts)
+
+	if _, err := handshake(ctx, conn); err != nil {
+		return nil, err
+	}
+
+	if _, ok := o.metrics.(nopMetrics); !ok {
+		conn = &meteredConn{Conn: conn, metrics: o.metrics}
+	}
+
+	base := newChannel(conn, codec9p{}, DefaultMSize)
+	ch := o.channel(base) // sets msize, effectively.
 
 	// negotiate the protocol version
-	version, err := clientnegotiate(ctx, ch, DefaultVersion)
+	requested := DefaultVersion
+	switch {
+	case o.propagator != nil:
+		requested = TraceVersion
+	case o.unix:
+		requested = UnixVersion
+	}
+
+	version, err := clientnegotiate(ctx, ch, requested)
 	if err != nil {
 		return nil, err
 	}
 
+	if version == UnixVersion {
+		base.codec = codec9p{dotu: true}
+	}
+
+	if version != TraceVersion {
+		o.propagator = nil
+	}
+	o.metrics.Negotiated(ch.MSize(), version)
+
 	return &client{
 		version:   version,
 		msize:     ch.MSize(),
 		ctx:       ctx,
-		transport: newTransport(ctx, ch),
+		transport: newTransport(ctx, ch, o),
 	}, nil
 }
 
-var _ Session = &client{}
+var _ SessionL = &client{}
 
 func (c *client) Version() (int, string) {
 	return c.msize, c.version
@@ -47,6 +78,10 @@ func (c *client) Auth(ctx context.Context, afid Fid, uname, aname string) (Qid,
 		Aname: aname,
 	}
 
+	if c.version == UnixVersion {
+		m.NUname = NONUNAME // identified by uname
+	}
+
 	resp, err := c.transport.send(ctx, m)
 	if err != nil {
 		return Qid{}, err
@@ -68,6 +103,10 @@ func (c *client) Attach(ctx context.Context, fid, afid Fid, uname, aname string)
 		Aname: aname,
 	}
 
+	if c.version == UnixVersion {
+		m.NUname = NONUNAME // identified by uname
+	}
+
 	resp, err := c.transport.send(ctx, m)
 	if err != nil {
 		return Qid{}, err
@@ -114,7 +153,7 @@ func (c *client) Remove(ctx context.Context, fid Fid) error {
 }
 
 func (c *client) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
-	if len(names) > 16 {
+	if len(names) > MAXWELEM {
 		return nil, ErrWalkLimit
 	}
 
@@ -251,3 +290,136 @@ func (c *client) WStat(ctx context.Context, fid Fid, dir Dir) error {
 
 	return nil
 }
+
+func (c *client) Statfs(ctx context.Context, fid Fid) (StatFS, error) {
+	resp, err := c.transport.send(ctx, MessageTstatfs{Fid: fid})
+	if err != nil {
+		return StatFS{}, err
+	}
+
+	rstatfs, ok := resp.(MessageRstatfs)
+	if !ok {
+		return StatFS{}, ErrUnexpectedMsg
+	}
+
+	return StatFS(rstatfs), nil
+}
+
+func (c *client) Getattr(ctx context.Context, fid Fid, mask uint64) (Attr, error) {
+	resp, err := c.transport.send(ctx, MessageTgetattr{Fid: fid, RequestMask: mask})
+	if err != nil {
+		return Attr{}, err
+	}
+
+	rgetattr, ok := resp.(MessageRgetattr)
+	if !ok {
+		return Attr{}, ErrUnexpectedMsg
+	}
+
+	return Attr(rgetattr), nil
+}
+
+func (c *client) XattrWalk(ctx context.Context, fid, newfid Fid, name string) (uint64, error) {
+	resp, err := c.transport.send(ctx, MessageTxattrwalk{
+		Fid:    fid,
+		Newfid: newfid,
+		Name:   name,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rxattrwalk, ok := resp.(MessageRxattrwalk)
+	if !ok {
+		return 0, ErrUnexpectedMsg
+	}
+
+	return rxattrwalk.Size, nil
+}
+
+func (c *client) XattrCreate(ctx context.Context, fid Fid, name string, size uint64, flags uint32) error {
+	resp, err := c.transport.send(ctx, MessageTxattrcreate{
+		Fid:   fid,
+		Name:  name,
+		Size:  size,
+		Flags: flags,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, ok := resp.(MessageRxattrcreate)
+	if !ok {
+		return ErrUnexpectedMsg
+	}
+
+	return nil
+}
+
+func (c *client) Fsync(ctx context.Context, fid Fid, datasync bool) error {
+	m := MessageTfsync{Fid: fid}
+	if datasync {
+		m.Datasync = 1
+	}
+
+	resp, err := c.transport.send(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	_, ok := resp.(MessageRfsync)
+	if !ok {
+		return ErrUnexpectedMsg
+	}
+
+	return nil
+}
+
+func (c *client) Lock(ctx context.Context, fid Fid, lock Lock) (uint8, error) {
+	resp, err := c.transport.send(ctx, MessageTlock{
+		Fid:      fid,
+		LockType: lock.Type,
+		Flags:    lock.Flags,
+		Start:    lock.Start,
+		Length:   lock.Length,
+		ProcID:   lock.ProcID,
+		ClientID: lock.ClientID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	rlock, ok := resp.(MessageRlock)
+	if !ok {
+		return 0, ErrUnexpectedMsg
+	}
+
+	return rlock.Status, nil
+}
+
+func (c *client) GetLock(ctx context.Context, fid Fid, lock Lock) (Lock, error) {
+	resp, err := c.transport.send(ctx, MessageTgetlock{
+		Fid:      fid,
+		LockType: lock.Type,
+		Start:    lock.Start,
+		Length:   lock.Length,
+		ProcID:   lock.ProcID,
+		ClientID: lock.ClientID,
+	})
+	if err != nil {
+		return Lock{}, err
+	}
+
+	rgetlock, ok := resp.(MessageRgetlock)
+	if !ok {
+		return Lock{}, ErrUnexpectedMsg
+	}
+
+	return Lock{
+		Type:     rgetlock.LockType,
+		Start:    rgetlock.Start,
+		Length:   rgetlock.Length,
+		ProcID:   rgetlock.ProcID,
+		ClientID: rgetlock.ClientID,
+	}, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
@@ -20,10 +22,25 @@ import (
 	"golang.org/x/net/context"
 )
 
-var addr string
+var (
+	addr    string
+	user    string
+	keyring string
+
+	useTLS  bool
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+)
 
 func init() {
 	flag.StringVar(&addr, "addr", ":5640", "addr of 9p service")
+	flag.StringVar(&user, "user", "anyone", "user name to attach as")
+	flag.StringVar(&keyring, "keyring", "", "file holding the key of -user, to authenticate with the server")
+	flag.BoolVar(&useTLS, "tls", false, "connect over tls")
+	flag.StringVar(&tlsCA, "tls-ca", "", "verify the server with the CAs in this file, rather than the system roots")
+	flag.StringVar(&tlsCert, "tls-cert", "", "present the client certificate in this file")
+	flag.StringVar(&tlsKey, "tls-key", "", "file with the private key for -tls-cert")
 }
 
 func main() {
@@ -47,6 +64,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if useTLS {
+		config, err := tlsConfig()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		// unix sockets and addresses without a host verify as localhost.
+		config.ServerName = "localhost"
+		if host, _, err := net.SplitHostPort(addr); err == nil && host != "" && proto == "tcp" {
+			config.ServerName = host
+		}
+		conn = tls.Client(conn, config)
+	}
+
 	csession, err := p9p.NewSession(ctx, conn)
 	if err != nil {
 		log.Fatalln(err)
@@ -84,9 +115,29 @@ func main() {
 	}
 	log.Println("9p version", version, msize)
 
-	// attach root
+	// authenticate, if we have a key
 	commander.nextfid = 1
-	if _, err := commander.session.Attach(commander.ctx, commander.nextfid, p9p.NOFID, "anyone", "/"); err != nil {
+	afid := p9p.NOFID
+	if keyring != "" {
+		keys, err := p9p.LoadKeyring(keyring)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		key, ok := keys.Key(user)
+		if !ok {
+			log.Fatalf("no key for %q in %v", user, keyring)
+		}
+
+		afid = commander.nextfid
+		commander.nextfid++
+		if err := p9p.Authenticate(commander.ctx, commander.session, afid, user, "/", p9p.KeyAuthClient{Key: key}); err != nil {
+			log.Fatalln("error authenticating:", err)
+		}
+	}
+
+	// attach root
+	if _, err := commander.session.Attach(commander.ctx, commander.nextfid, afid, user, "/"); err != nil {
 		log.Fatalln(err)
 	}
 	commander.rootfid = commander.nextfid
@@ -145,6 +196,33 @@ func main() {
 	}
 }
 
+// tlsConfig returns the client configuration from the tls flags.
+func tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if tlsCA != "" {
+		pem, err := os.ReadFile(tlsCA)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", tlsCA)
+		}
+	}
+
+	if tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
 type fsCommander struct {
 	ctx     context.Context
 	session p9p.Session
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
 	"strings"
 
 	"github.com/docker/go-p9p"
@@ -12,13 +18,39 @@ import (
 )
 
 var (
-	root string
-	addr string
+	root     string
+	addr     string
+	identity string
+	squash   bool
+	readonly bool
+	exports  string
+	keyring  string
+
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+	tlsName     string
+
+	peercred string
+	verbose  bool
+	debug    string
 )
 
 func init() {
 	flag.StringVar(&root, "root", "~/", "root of filesystem to serve over 9p")
 	flag.StringVar(&addr, "addr", ":5640", "bind addr for 9p server, prefix with unix: for unix socket")
+	flag.StringVar(&identity, "identity", "none", "apply the attaching user to operations: none, check or setfsuid")
+	flag.BoolVar(&squash, "squash", false, "map all users to nobody, requires -identity check or setfsuid")
+	flag.BoolVar(&readonly, "ro", false, "export the filesystem read-only, except where overridden by -exports")
+	flag.StringVar(&exports, "exports", "", "file with per-path export rules (rw, ro or hidden)")
+	flag.StringVar(&keyring, "keyring", "", "file with user keys, requiring clients to authenticate")
+	flag.StringVar(&tlsCert, "tls-cert", "", "serve over tls with the certificate in this file")
+	flag.StringVar(&tlsKey, "tls-key", "", "file with the private key for -tls-cert")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "require client certificates signed by the CAs in this file, taking the user from the certificate")
+	flag.StringVar(&tlsName, "tls-name", "cn", "name in client certificates used as the user: cn, dns, email or uri")
+	flag.BoolVar(&verbose, "v", false, "log every request and response")
+	flag.StringVar(&debug, "debug", "", "serve metrics at /debug/vars on this addr")
+	flag.StringVar(&peercred, "peercred", "", "on unix sockets, take the user from the peer uid: validate the attach uname or override it")
 }
 
 func main() {
@@ -26,6 +58,54 @@ func main() {
 	log.SetFlags(0)
 	flag.Parse()
 
+	id, err := ufs.ParseIdentity(identity)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	opts := []ufs.Option{ufs.WithIdentity(id)}
+	if squash {
+		opts = append(opts, ufs.WithSquash())
+	}
+
+	// sessions are created per connection, so the options are checked once
+	// here rather than failing each connection.
+	if _, err := ufs.NewSession(ctx, root, opts...); err != nil {
+		log.Fatalln(err)
+	}
+
+	var rules *ufs.ExportRules
+	if exports != "" {
+		rules, err = ufs.LoadExportRules(exports)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if readonly {
+		if rules == nil {
+			rules = &ufs.ExportRules{}
+		}
+		rules.Default = ufs.AccessReadOnly
+	}
+
+	var auth p9p.Authenticator
+	if keyring != "" {
+		keys, err := p9p.LoadKeyring(keyring)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		auth = p9p.KeyAuth{Keyring: keys}
+	}
+
+	var metrics p9p.Metrics
+	if debug != "" {
+		metrics = p9p.NewExpvarMetrics("9p")
+		go func() {
+			log.Println(http.ListenAndServe(debug, nil))
+		}()
+	}
+
 	proto := "tcp"
 	if strings.HasPrefix(addr, "unix:") {
 		proto = "unix"
@@ -38,6 +118,39 @@ func main() {
 	}
 	defer listener.Close()
 
+	var identityFn p9p.IdentityFunc
+	if tlsCert != "" {
+		config, err := tlsConfig()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if tlsClientCA != "" {
+			name, err := p9p.ParseCertName(tlsName)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			identityFn = p9p.TLSIdentity(name, nil)
+		}
+
+		listener = tls.NewListener(listener, config)
+	}
+
+	var peercredMode p9p.IdentityMode
+	switch peercred {
+	case "":
+	case "validate":
+		peercredMode = p9p.IdentityValidate
+	case "override":
+		peercredMode = p9p.IdentityOverride
+	default:
+		log.Fatalf("unknown -peercred mode %q", peercred)
+	}
+
+	if peercred != "" && proto != "unix" {
+		log.Fatalln("-peercred requires a unix socket address")
+	}
+
 	for {
 		c, err := listener.Accept()
 		if err != nil {
@@ -50,15 +163,63 @@ func main() {
 
 			ctx := context.WithValue(ctx, "conn", conn)
 			log.Println("connected", conn.RemoteAddr())
-			session, err := ufs.NewSession(ctx, root)
+			session, err := ufs.NewSession(ctx, root, opts...)
 			if err != nil {
-				log.Println("error creating session")
+				log.Println("error creating session:", err)
 				return
 			}
 
-			if err := p9p.ServeConn(ctx, conn, p9p.Dispatch(session)); err != nil {
+			if rules != nil {
+				session = ufs.NewExportSession(session, rules)
+			}
+
+			if auth != nil {
+				session = p9p.NewAuthSession(session, auth)
+			}
+
+			handler := p9p.Dispatch(session)
+			if verbose {
+				handler = p9p.NewLogHandler(slog.Default().With("remote", conn.RemoteAddr().String()), handler)
+			}
+			if identityFn != nil {
+				handler = p9p.AttachIdentity(handler, identityFn, p9p.IdentityOverride)
+			}
+
+			if peercred != "" {
+				handler = p9p.AttachIdentity(handler, p9p.PeerCredIdentity(nil), peercredMode)
+			}
+
+			if err := p9p.ServeConn(ctx, conn, handler, p9p.WithMetrics(metrics)); err != nil {
 				log.Printf("serving conn: %v", err)
 			}
 		}(c)
 	}
 }
+
+// tlsConfig returns the server configuration from the tls flags.
+func tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if tlsClientCA != "" {
+		pem, err := os.ReadFile(tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", tlsClientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
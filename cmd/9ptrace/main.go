@@ -0,0 +1,392 @@
+// Command 9ptrace is a proxy printing the 9p messages passing between clients
+// and an upstream server.
+//
+// Each connection to -addr is forwarded to -upstream, unmodified. Messages
+// are decoded and printed as they pass, one per line:
+//
+//	15:04:05.000000 #1 -> Twalk(1) fid=1 newfid=2 wnames=[tmp]
+//	15:04:05.000120 #1 <- Rwalk(1) qids=[...]
+//
+// Messages are decoded in the version negotiated on the connection, such as
+// 9P2000.u. With -record, the messages of each connection are also written
+// to a file in the format read by p9p.ReadRecords, as seen from the server.
+// Messages that could not be decoded are left out of the recording, which is
+// reported when the connection closes.
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/go-p9p"
+)
+
+var (
+	addr     string
+	upstream string
+	asJSON   bool
+	types    string
+	fid      int64
+	record   string
+)
+
+// maxFrame limits the size of the messages forwarded by the proxy.
+const maxFrame = 16 << 20
+
+func init() {
+	flag.StringVar(&addr, "addr", ":5641", "bind addr for the proxy, prefix with unix: for unix socket")
+	flag.StringVar(&upstream, "upstream", ":5640", "addr of the 9p server, prefix with unix: for unix socket")
+	flag.BoolVar(&asJSON, "json", false, "print messages as json lines")
+	flag.StringVar(&types, "type", "", "only print these comma separated message types, such as walk,Rerror")
+	flag.Int64Var(&fid, "fid", -1, "only print requests on this fid and their responses")
+	flag.StringVar(&record, "record", "", "record each connection to this path, suffixed with the connection number")
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	filter, err := parseTypes(types)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	proto, laddr := splitAddr(addr)
+	listener, err := net.Listen(proto, laddr)
+	if err != nil {
+		log.Fatalln("error listening:", err)
+	}
+	defer listener.Close()
+
+	printer := &printer{
+		wr:     os.Stdout,
+		json:   asJSON,
+		types:  filter,
+		fid:    fid,
+		active: map[int]map[p9p.Tag]bool{},
+	}
+
+	for id := 1; ; id++ {
+		c, err := listener.Accept()
+		if err != nil {
+			log.Fatalln("error accepting:", err)
+		}
+
+		go func(id int, conn net.Conn) {
+			defer conn.Close()
+			if err := proxy(id, conn, printer); err != nil {
+				log.Printf("#%d: %v", id, err)
+			}
+			log.Printf("#%d: closed", id)
+		}(id, c)
+	}
+}
+
+func splitAddr(addr string) (proto, address string) {
+	if strings.HasPrefix(addr, "unix:") {
+		return "unix", addr[5:]
+	}
+
+	return "tcp", addr
+}
+
+// parseTypes parses the -type flag into a set of message types. Names
+// without the T or R prefix match both.
+func parseTypes(s string) (map[p9p.FcallType]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	names := map[string]p9p.FcallType{}
+	for t := 0; t < 256; t++ {
+		fct := p9p.FcallType(t)
+		names[strings.ToLower(fct.String())] = fct
+	}
+
+	set := map[p9p.FcallType]bool{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for _, candidate := range []string{name, "t" + name, "r" + name} {
+			if fct, ok := names[candidate]; ok {
+				set[fct] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown message type %q", name)
+		}
+	}
+
+	return set, nil
+}
+
+// proxy forwards conn to the upstream server, printing the messages in both
+// directions until either side closes.
+func proxy(id int, conn net.Conn, p *printer) error {
+	proto, raddr := splitAddr(upstream)
+	up, err := net.Dial(proto, raddr)
+	if err != nil {
+		return err
+	}
+	defer up.Close()
+
+	log.Printf("#%d: connected %v to %v", id, conn.RemoteAddr(), raddr)
+	defer p.done(id)
+
+	tc := &traced{id: id, p: p, codec: p9p.NewCodec()}
+	if record != "" {
+		f, err := os.Create(record + "." + strconv.Itoa(id))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tc.rw = p9p.NewRecordWriter(f)
+	}
+
+	errs := make(chan error, 2)
+	go func() { errs <- tc.forward(up, conn, p9p.Received) }()
+	go func() { errs <- tc.forward(conn, up, p9p.Sent) }()
+
+	// the first side to finish closes both, unblocking the other.
+	err = <-errs
+	conn.Close()
+	up.Close()
+	<-errs
+
+	if tc.rw != nil {
+		if rerr := tc.rw.Err(); rerr != nil && err == nil {
+			err = fmt.Errorf("error recording: %v", rerr)
+		}
+
+		if tc.omitted > 0 {
+			log.Printf("#%d: %d undecoded messages left out of the recording", id, tc.omitted)
+		}
+	}
+
+	return err
+}
+
+// traced is the state of a proxied connection, shared by both directions.
+type traced struct {
+	id int
+	p  *printer
+	rw *p9p.RecordWriter
+
+	mu      sync.Mutex
+	codec   p9p.Codec // of the negotiated version
+	omitted int       // undecoded messages left out of the recording
+}
+
+// decode decodes frame in the version of the connection, switching to the
+// version of a passing Rversion, and records it. Both happen under the lock,
+// so that the recording is in the same version and order as decoded.
+func (tc *traced) decode(dir p9p.Direction, frame []byte, fcall *p9p.Fcall) error {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if err := tc.codec.Unmarshal(frame[4:], fcall); err != nil {
+		if tc.rw != nil {
+			tc.omitted++
+		}
+		return err
+	}
+
+	if rversion, ok := fcall.Message.(p9p.MessageRversion); ok {
+		tc.codec = p9p.NewVersionCodec(rversion.Version)
+	}
+
+	if tc.rw != nil {
+		tc.rw.Write(dir, fcall)
+	}
+
+	return nil
+}
+
+// forward copies messages from src to dst. Messages from the client are
+// recorded as received, since recordings are from the side of the server.
+func (tc *traced) forward(dst io.Writer, src io.Reader, dir p9p.Direction) error {
+	var size [4]byte
+	for {
+		if _, err := io.ReadFull(src, size[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		n := binary.LittleEndian.Uint32(size[:])
+		if n < 7 || n > maxFrame {
+			return fmt.Errorf("invalid message size %v", n)
+		}
+
+		frame := make([]byte, n)
+		copy(frame, size[:])
+		if _, err := io.ReadFull(src, frame[4:]); err != nil {
+			return err
+		}
+
+		// decoded before forwarding, so that an Rversion switches the
+		// version before the client can send a message in it.
+		fcall := new(p9p.Fcall)
+		decodeErr := tc.decode(dir, frame, fcall)
+
+		if _, err := dst.Write(frame); err != nil {
+			return err
+		}
+
+		if decodeErr != nil {
+			// forwarded regardless, since the proxy may not know every
+			// extension of the protocol.
+			tc.p.undecoded(tc.id, dir, frame)
+			continue
+		}
+
+		tc.p.print(tc.id, dir, fcall)
+	}
+}
+
+// printer prints the messages of all connections.
+type printer struct {
+	wr    io.Writer
+	json  bool
+	types map[p9p.FcallType]bool
+	fid   int64
+
+	mu     sync.Mutex
+	active map[int]map[p9p.Tag]bool // tags of requests on -fid, by conn
+}
+
+// entry is the json form of a message.
+type entry struct {
+	Time    time.Time   `json:"time"`
+	Conn    int         `json:"conn"`
+	Dir     string      `json:"dir"`
+	Type    string      `json:"type"`
+	Tag     p9p.Tag     `json:"tag"`
+	Size    int         `json:"size,omitempty"` // of undecoded messages
+	Message p9p.Message `json:"message,omitempty"`
+}
+
+func arrow(dir p9p.Direction) string {
+	if dir == p9p.Received {
+		return "->"
+	}
+
+	return "<-"
+}
+
+func (p *printer) print(id int, dir p9p.Direction, fcall *p9p.Fcall) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the tags are tracked for every message, so that a response is matched
+	// to its request even if the type of the request is filtered out.
+	if p.fid >= 0 && !p.matchFid(id, dir, fcall) {
+		return
+	}
+
+	if p.types != nil && !p.types[fcall.Type] {
+		return
+	}
+
+	now := time.Now()
+	if p.json {
+		p.encode(entry{
+			Time:    now,
+			Conn:    id,
+			Dir:     arrow(dir),
+			Type:    fcall.Type.String(),
+			Tag:     fcall.Tag,
+			Message: fcall.Message,
+		})
+		return
+	}
+
+	fmt.Fprintf(p.wr, "%v #%d %v %v\n", now.Format("15:04:05.000000"), id, arrow(dir), fcall)
+}
+
+// undecoded prints a message the codec could not decode, by type and size.
+func (p *printer) undecoded(id int, dir p9p.Direction, frame []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p9p.FcallType(frame[4])
+	if p.types != nil && !p.types[t] || p.fid >= 0 {
+		return
+	}
+
+	now := time.Now()
+	tag := p9p.Tag(binary.LittleEndian.Uint16(frame[5:7]))
+	if p.json {
+		p.encode(entry{Time: now, Conn: id, Dir: arrow(dir), Type: strconv.Itoa(int(t)), Tag: tag, Size: len(frame)})
+		return
+	}
+
+	fmt.Fprintf(p.wr, "%v #%d %v type %d(%v) size %d: undecoded\n", now.Format("15:04:05.000000"), id, arrow(dir), t, tag, len(frame))
+}
+
+func (p *printer) encode(e entry) {
+	enc := json.NewEncoder(p.wr)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(e); err != nil {
+		log.Println("error encoding:", err)
+	}
+}
+
+// matchFid returns true if the request references the -fid flag or the
+// response answers such a request. Called with the lock held.
+func (p *printer) matchFid(id int, dir p9p.Direction, fcall *p9p.Fcall) bool {
+	tags, ok := p.active[id]
+	if !ok {
+		tags = map[p9p.Tag]bool{}
+		p.active[id] = tags
+	}
+
+	if dir == p9p.Sent {
+		if !tags[fcall.Tag] {
+			return false
+		}
+		delete(tags, fcall.Tag)
+		return true
+	}
+
+	if tflush, ok := fcall.Message.(p9p.MessageTflush); ok && tags[tflush.Oldtag] {
+		tags[fcall.Tag] = true
+		return true
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(fcall.Message))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+
+	for _, name := range []string{"Fid", "Newfid", "Afid"} {
+		f := v.FieldByName(name)
+		if f.IsValid() && f.Kind() == reflect.Uint32 && int64(f.Uint()) == p.fid {
+			tags[fcall.Tag] = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// done forgets the state of a closed connection.
+func (p *printer) done(id int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.active, id)
+}
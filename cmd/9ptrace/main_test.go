@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"syscall"
+	"testing"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+func TestPrinterFilters(t *testing.T) {
+	var buf bytes.Buffer
+	p := &printer{
+		wr:     &buf,
+		types:  map[p9p.FcallType]bool{p9p.Rread: true},
+		fid:    3,
+		active: map[int]map[p9p.Tag]bool{},
+	}
+
+	for _, m := range []struct {
+		dir   p9p.Direction
+		fcall *p9p.Fcall
+	}{
+		{p9p.Received, &p9p.Fcall{Type: p9p.Tread, Tag: 1, Message: p9p.MessageTread{Fid: 3}}},
+		{p9p.Received, &p9p.Fcall{Type: p9p.Tread, Tag: 2, Message: p9p.MessageTread{Fid: 4}}},
+		{p9p.Sent, &p9p.Fcall{Type: p9p.Rread, Tag: 2, Message: p9p.MessageRread{Data: []byte("other")}}},
+		{p9p.Sent, &p9p.Fcall{Type: p9p.Rread, Tag: 1, Message: p9p.MessageRread{Data: []byte("match")}}},
+	} {
+		p.print(0, m.dir, m.fcall)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "Rread(1)") {
+		t.Fatalf("expected the Rread of fid 3 only, got %q", buf.String())
+	}
+}
+
+// frame encodes fcall as sent on the wire by codec.
+func frame(t *testing.T, codec p9p.Codec, fcall *p9p.Fcall) []byte {
+	t.Helper()
+
+	p, err := codec.Marshal(fcall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(4+len(p)))
+	return append(size, p...)
+}
+
+func TestTracedVersion(t *testing.T) {
+	var (
+		buf   bytes.Buffer
+		in    bytes.Buffer
+		out   bytes.Buffer
+		codec = p9p.NewVersionCodec(p9p.UnixVersion)
+		rerr  = p9p.MessageRerror{Ename: "file not found", Errno: uint32(syscall.ENOENT)}
+	)
+
+	for _, fcall := range []*p9p.Fcall{
+		{Type: p9p.Rversion, Tag: p9p.NOTAG, Message: p9p.MessageRversion{MSize: 8192, Version: p9p.UnixVersion}},
+		{Type: p9p.Rerror, Tag: 1, Message: rerr},
+	} {
+		in.Write(frame(t, codec, fcall))
+	}
+	in.Write([]byte{7, 0, 0, 0, 255, 2, 0}) // unknown type
+
+	tc := &traced{
+		id:    1,
+		p:     &printer{wr: &out, fid: -1, active: map[int]map[p9p.Tag]bool{}},
+		rw:    p9p.NewRecordWriter(&buf),
+		codec: p9p.NewCodec(),
+	}
+
+	var forwarded bytes.Buffer
+	if err := tc.forward(&forwarded, bytes.NewReader(in.Bytes()), p9p.Sent); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(forwarded.Bytes(), in.Bytes()) {
+		t.Fatal("expected the messages to be forwarded unmodified")
+	}
+
+	if tc.omitted != 1 {
+		t.Fatalf("expected the undecoded message to be counted, got %v", tc.omitted)
+	}
+
+	records, err := p9p.ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 || records[1].Fcall.Message != rerr {
+		t.Fatalf("expected the errno in the recording, got %v", records)
+	}
+}
@@ -0,0 +1,623 @@
+// Command p9pgen generates the message types and their codec from a protocol
+// description. It is run by go generate in the p9p package:
+//
+//	p9pgen -spec messages.spec -out .
+//
+// See messages.spec for the format of the description.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var (
+	spec string
+	out  string
+)
+
+func init() {
+	flag.StringVar(&spec, "spec", "messages.spec", "protocol description to generate from")
+	flag.StringVar(&out, "out", ".", "directory of the generated files")
+}
+
+// Dialect is a group of messages, declared in a block of constants. A
+// dialect may also extend the messages of others and Dir with fields.
+type Dialect struct {
+	Name     string
+	Doc      []string
+	Messages []*Message
+	Dir      []Field    // fields appended to Dir
+	Variants []*Message // messages encoded differently in the dialect
+}
+
+// Message describes the encoding of a message type.
+type Message struct {
+	Name   string
+	Number int
+	Doc    []string
+	Fields []Field
+
+	NoMessage bool // reserved type without a message
+	External  bool // struct and Type declared by hand
+	StatSize  bool // prefixed by the size of its Dir
+
+	// Ext are the fields appended by the extending dialect Extension.
+	Ext       []Field
+	Extension string
+}
+
+// Field is a field of a message.
+type Field struct {
+	Name string
+	Type string
+}
+
+// fieldType describes how the values of a field type are encoded, as Go
+// expressions with {v} replaced by the value. In the encoding of a dialect
+// extending Dir, {d} is replaced by the identifier of the dialect.
+type fieldType struct {
+	size   string // encoded size
+	put    string // encodes into p, returning the remainder
+	get    string // decodes from b
+	sample string // test value
+}
+
+var fieldTypes = map[string]fieldType{
+	"uint8":    {"1", "put8(p, {v})", "b.uint8()", "0x12"},
+	"uint16":   {"2", "put16(p, {v})", "b.uint16()", "0x1234"},
+	"uint32":   {"4", "put32(p, {v})", "b.uint32()", "0x12345678"},
+	"uint64":   {"8", "put64(p, {v})", "b.uint64()", "0x1234567890abcdef"},
+	"Fid":      {"4", "put32(p, uint32({v}))", "Fid(b.uint32())", "Fid(1)"},
+	"Tag":      {"2", "put16(p, uint16({v}))", "Tag(b.uint16())", "Tag(2)"},
+	"Flag":     {"1", "put8(p, uint8({v}))", "Flag(b.uint8())", "ORDWR | OTRUNC"},
+	"string":   {"2 + len({v})", "putstring(p, {v})", "b.string()", `"glenda"`},
+	"[]string": {"sizestrings({v})", "putstrings(p, {v})", "b.strings()", `[]string{"usr", "glenda"}`},
+	"[]byte":   {"4 + len({v})", "putdata(p, {v})", "b.data()", `[]byte("data")`},
+	"Qid":      {"qidSize", "putqid(p, {v})", "b.qid()", "Qid{Type: QTDIR, Version: 1, Path: 2}"},
+	"[]Qid":    {"2 + len({v})*qidSize", "putqids(p, {v})", "b.qids()", "[]Qid{{Type: QTDIR, Version: 1, Path: 2}, {Path: 3}}"},
+	"Timespec": {"16", "puttimespec(p, {v})", "b.timespec()", "Timespec{Sec: 1 << 40, Nsec: 999999999}"},
+	"Dir": {"sizedir{d}({v})", "putdir{d}(p, {v})", "b.dir{d}()", `Dir{Type: 1, Dev: 2, Qid: Qid{Path: 3}, Mode: DMDIR | 0755,
+		AccessTime: time.Unix(4, 0).UTC(), ModTime: time.Unix(5, 0).UTC(), Length: 6,
+		Name: "name", UID: "uid", GID: "gid", MUID: "muid"}`},
+}
+
+// expr returns the expression format of a field type for the value v in
+// the encoding of dialect d, empty for the base encoding.
+func expr(format, v, d string) string {
+	return strings.NewReplacer("{v}", v, "{d}", d).Replace(format)
+}
+
+// ident returns the identifier of a dialect in the names of its encoding,
+// such as Dotu for 9P2000.u.
+func ident(name string) string {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		return "Dot" + name[i+1:]
+	}
+	return name
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	f, err := os.Open(spec)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer f.Close()
+
+	dialects, err := parse(bufio.NewScanner(f))
+	if err != nil {
+		log.Fatalf("%v: %v", spec, err)
+	}
+
+	for name, tmpl := range map[string]*template.Template{
+		"messages.go":          messagesTemplate,
+		"encoding_gen.go":      encodingTemplate,
+		"messages_gen_test.go": testTemplate,
+	} {
+		if err := generate(filepath.Join(out, name), tmpl, dialects); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// parse reads the dialects of a protocol description.
+func parse(sc *bufio.Scanner) ([]*Dialect, error) {
+	var (
+		dialects []*Dialect
+		doc      []string
+		names    = map[string]bool{}
+		numbers  = map[int]string{}
+	)
+
+	for lineno := 1; sc.Scan(); lineno++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			doc = nil
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "//"):
+			doc = append(doc, line)
+			continue
+		case strings.HasPrefix(line, "dialect "):
+			dialects = append(dialects, &Dialect{
+				Name: strings.TrimSpace(strings.TrimPrefix(line, "dialect ")),
+				Doc:  doc,
+			})
+			doc = nil
+			continue
+		}
+
+		if len(dialects) == 0 {
+			return nil, fmt.Errorf("line %d: message before dialect", lineno)
+		}
+
+		dialect := dialects[len(dialects)-1]
+		if parts := strings.SplitN(line, " ", 3); len(parts) == 3 && parts[1] == "extends" {
+			if err := parseExtension(dialect, dialects, parts[0], parts[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineno, err)
+			}
+			doc = nil
+			continue
+		}
+
+		msg, err := parseMessage(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineno, err)
+		}
+		msg.Doc, doc = doc, nil
+
+		if names[msg.Name] {
+			return nil, fmt.Errorf("line %d: duplicate message %v", lineno, msg.Name)
+		}
+		names[msg.Name] = true
+
+		if other, ok := numbers[msg.Number]; ok {
+			return nil, fmt.Errorf("line %d: %v has the number of %v", lineno, msg.Name, other)
+		}
+		numbers[msg.Number] = msg.Name
+
+		dialect.Messages = append(dialect.Messages, msg)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	// messages with extended fields or a Dir are encoded differently by the
+	// extending dialect.
+	for _, d := range dialects {
+		for _, other := range dialects {
+			for _, msg := range other.Messages {
+				if msg.Extension == d.Name || (len(d.Dir) > 0 && hasDir(msg)) {
+					d.Variants = append(d.Variants, msg)
+				}
+			}
+		}
+	}
+
+	return dialects, nil
+}
+
+// parseExtension parses the fields appended to target, a message of another
+// dialect or Dir, by dialect.
+func parseExtension(dialect *Dialect, dialects []*Dialect, target, rest string) error {
+	fields, err := parseFields(target, rest)
+	if err != nil {
+		return err
+	}
+
+	if target == "Dir" {
+		if len(dialect.Dir) > 0 {
+			return fmt.Errorf("Dir extended twice by %v", dialect.Name)
+		}
+		dialect.Dir = fields
+		return nil
+	}
+
+	for _, d := range dialects[:len(dialects)-1] {
+		for _, msg := range d.Messages {
+			if msg.Name != target {
+				continue
+			}
+
+			if msg.NoMessage || msg.Extension != "" {
+				return fmt.Errorf("%v cannot be extended by %v", target, dialect.Name)
+			}
+
+			msg.Ext, msg.Extension = fields, dialect.Name
+			return nil
+		}
+	}
+
+	return fmt.Errorf("extension of unknown message %v", target)
+}
+
+// parseMessage parses a line describing a message.
+func parseMessage(line string) (*Message, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("expected type and number: %q", line)
+	}
+
+	number, err := strconv.Atoi(parts[1])
+	if err != nil || number < 0 || number > 255 {
+		return nil, fmt.Errorf("invalid number for %v: %q", parts[0], parts[1])
+	}
+
+	msg := &Message{Name: parts[0], Number: number}
+	if !strings.HasPrefix(msg.Name, "T") && !strings.HasPrefix(msg.Name, "R") {
+		return nil, fmt.Errorf("message %v must start with T or R", msg.Name)
+	}
+
+	if len(parts) < 3 {
+		return msg, nil
+	}
+
+	rest := parts[2]
+	for {
+		i := strings.LastIndex(rest, "!")
+		if i < 0 {
+			break
+		}
+
+		switch option := strings.TrimSpace(rest[i+1:]); option {
+		case "nomessage":
+			msg.NoMessage = true
+		case "external":
+			msg.External = true
+		case "statsize":
+			msg.StatSize = true
+		default:
+			return nil, fmt.Errorf("unknown option %q for %v", option, msg.Name)
+		}
+		rest = strings.TrimSpace(rest[:i])
+	}
+
+	if rest == "" {
+		return msg, nil
+	}
+
+	msg.Fields, err = parseFields(msg.Name, rest)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.StatSize && (len(msg.Fields) != 1 || msg.Fields[0].Type != "Dir") {
+		return nil, fmt.Errorf("!statsize requires a single Dir field in %v", msg.Name)
+	}
+
+	return msg, nil
+}
+
+// parseFields parses the comma separated fields of name.
+func parseFields(name, rest string) ([]Field, error) {
+	var fields []Field
+	for _, field := range strings.Split(rest, ",") {
+		f := strings.Fields(field)
+		if len(f) != 2 {
+			return nil, fmt.Errorf("invalid field %q of %v", field, name)
+		}
+
+		if _, ok := fieldTypes[f[1]]; !ok {
+			return nil, fmt.Errorf("unsupported type %v of %v.%v", f[1], name, f[0])
+		}
+
+		fields = append(fields, Field{Name: f[0], Type: f[1]})
+	}
+
+	return fields, nil
+}
+
+func hasDir(m *Message) bool {
+	for _, f := range m.Fields {
+		if f.Type == "Dir" {
+			return true
+		}
+	}
+	return false
+}
+
+func generate(path string, tmpl *template.Template, dialects []*Dialect) error {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, dialects); err != nil {
+		return err
+	}
+
+	p, err := format.Source(b.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting %v: %v\n%s", path, err, b.Bytes())
+	}
+
+	return os.WriteFile(path, p, 0644)
+}
+
+// fields returns the fields of m encoded in dialect d, nil for the dialect of
+// m.
+func fields(m *Message, d *Dialect) []Field {
+	if d != nil && m.Extension == d.Name {
+		return append(append([]Field(nil), m.Fields...), m.Ext...)
+	}
+	return m.Fields
+}
+
+// dirIdent returns the identifier of the encoding of Dir in dialect d.
+func dirIdent(d *Dialect) string {
+	if d == nil || len(d.Dir) == 0 {
+		return ""
+	}
+	return ident(d.Name)
+}
+
+var funcs = template.FuncMap{
+	"ident":  ident,
+	"fields": fields,
+	"lower":  strings.ToLower,
+	"size": func(m *Message, d *Dialect) string {
+		var terms []string
+		if m.StatSize {
+			terms = append(terms, "2")
+		}
+
+		for _, f := range fields(m, d) {
+			terms = append(terms, expr(fieldTypes[f.Type].size, "m."+f.Name, dirIdent(d)))
+		}
+
+		if len(terms) == 0 {
+			return "0"
+		}
+		return strings.Join(terms, " + ")
+	},
+	"dirsize": func(d *Dialect) string {
+		var terms []string
+		for _, f := range d.Dir {
+			terms = append(terms, expr(fieldTypes[f.Type].size, "d."+f.Name, ""))
+		}
+		return strings.Join(terms, " + ")
+	},
+	"put": func(f Field, recv string, d *Dialect) string {
+		return expr(fieldTypes[f.Type].put, recv+"."+f.Name, dirIdent(d))
+	},
+	"get": func(f Field, d *Dialect) string {
+		return expr(fieldTypes[f.Type].get, "", dirIdent(d))
+	},
+	"sample": func(f Field, d *Dialect) string {
+		sample := fieldTypes[f.Type].sample
+		if f.Type != "Dir" || dirIdent(d) == "" {
+			return sample
+		}
+
+		// sets the fields of the dialect, before the closing brace.
+		values := []string{strings.TrimSuffix(sample, "}")}
+		for _, f := range d.Dir {
+			values = append(values, f.Name+": "+fieldTypes[f.Type].sample)
+		}
+		return strings.Join(values, ", ") + "}"
+	},
+	"usesDir": func(dialects []*Dialect) bool {
+		for _, d := range dialects {
+			for _, m := range d.Messages {
+				if hasDir(m) {
+					return true
+				}
+			}
+		}
+		return false
+	},
+}
+
+const header = `// Code generated by p9pgen from messages.spec. DO NOT EDIT.
+
+package p9p
+`
+
+var messagesTemplate = template.Must(template.New("messages").Funcs(funcs).Parse(header + `
+import "fmt"
+{{range .}}{{if .Messages}}
+{{range .Doc}}{{.}}
+{{end}}const (
+{{- range .Messages}}
+	{{.Name}} FcallType = {{.Number}}
+{{- end}}
+)
+{{end}}{{end}}
+func (fct FcallType) String() string {
+	switch fct {
+{{- range .}}{{range .Messages}}
+	case {{.Name}}:
+		return "{{.Name}}"
+{{- end}}{{end}}
+	default:
+		return "Tunknown"
+	}
+}
+
+// newMessage returns a new instance of the message based on the Fcall type.
+func newMessage(typ FcallType) (Message, error) {
+	switch typ {
+{{- range .}}{{range .Messages}}{{if not .NoMessage}}
+	case {{.Name}}:
+		return Message{{.Name}}{}, nil
+{{- end}}{{end}}{{end}}
+	}
+
+	return nil, fmt.Errorf("unknown message type")
+}
+{{range .}}{{range .Messages}}{{if not (or .NoMessage .External)}}
+{{range .Doc}}{{.}}
+{{end}}type Message{{.Name}} struct {
+{{- range .Fields}}
+	{{.Name}} {{.Type}}
+{{- end}}
+{{- if .Ext}}
+
+	// {{.Extension}}
+{{- $ext := .Extension}}
+{{- range .Ext}}
+	{{.Name}} {{.Type}} ` + "`" + `p9p:"{{$ext}}" json:",omitempty"` + "`" + `
+{{- end}}
+{{- end}}
+}
+{{end}}{{end}}{{end}}
+{{- range .}}
+{{range .Messages}}{{if not (or .NoMessage .External)}}
+func (Message{{.Name}}) Type() FcallType { return {{.Name}} }
+{{- end}}{{end}}
+{{end}}`))
+
+var encodingTemplate = template.Must(template.New("encoding").Funcs(funcs).Parse(header + `
+{{range .}}{{range .Messages}}{{if not .NoMessage}}
+func (m Message{{.Name}}) encodedSize() int {
+	return {{size . nil}}
+}
+
+func (m Message{{.Name}}) marshal9p(p []byte) []byte {
+{{- if .StatSize}}
+	p = put16(p, uint16(sizedir(m.Stat)))
+{{- end}}
+{{- range .Fields}}
+	p = {{put . "m" nil}}
+{{- end}}
+	return p
+}
+{{end}}{{end}}{{end}}
+// unmarshalMessage decodes the body of a message of type t from b, returning
+// nil if the type has no message.
+func unmarshalMessage(t FcallType, b *rbuf) Message {
+	switch t {
+{{- range .}}{{range .Messages}}{{if not .NoMessage}}
+	case {{.Name}}:
+{{- if .StatSize}}
+		b.uint16() // size of the Dir, repeated within it
+{{- end}}
+		return Message{{.Name}}{ {{- if .Fields}}
+{{- range .Fields}}
+			{{.Name}}: {{get . nil}},
+{{- end}}
+		{{end}}}
+{{- end}}{{end}}{{end}}
+	}
+
+	return nil
+}
+{{range $d := .}}{{if $d.Variants}}{{$id := ident $d.Name}}
+// The encoding of {{$d.Name}} appends fields to messages of other
+// dialects{{if $d.Dir}} and to Dir{{end}}.
+{{if $d.Dir}}
+// sizedir{{$id}} returns the encoded size of d in {{$d.Name}}, including its
+// size.
+func sizedir{{$id}}(d Dir) int {
+	return sizedir(d) + {{dirsize $d}}
+}
+
+func putdir{{$id}}(p []byte, d Dir) []byte {
+	p = put16(p, uint16(sizedir{{$id}}(d)-2))
+	p = putdirfields(p, d)
+{{- range $d.Dir}}
+	p = {{put . "d" nil}}
+{{- end}}
+	return p
+}
+
+func (b *rbuf) dir{{$id}}() Dir {
+	return b.entry(func(b *rbuf, d *Dir) {
+{{- range $d.Dir}}
+		d.{{.Name}} = {{get . nil}}
+{{- end}}
+	})
+}
+{{end}}
+{{- range $d.Variants}}
+func (m Message{{.Name}}) encodedSize{{$id}}() int {
+	return {{size . $d}}
+}
+
+func (m Message{{.Name}}) marshal9p{{$id}}(p []byte) []byte {
+{{- if .StatSize}}
+	p = put16(p, uint16(sizedir{{if $d.Dir}}{{$id}}{{end}}(m.Stat)))
+{{- end}}
+{{- range fields . $d}}
+	p = {{put . "m" $d}}
+{{- end}}
+	return p
+}
+{{end}}
+// unmarshalMessage{{$id}} decodes the body of a message of type t from b in
+// {{$d.Name}}, returning nil if the type has no message.
+func unmarshalMessage{{$id}}(t FcallType, b *rbuf) Message {
+	switch t {
+{{- range $d.Variants}}
+	case {{.Name}}:
+{{- if .StatSize}}
+		b.uint16() // size of the Dir, repeated within it
+{{- end}}
+		return Message{{.Name}}{ {{- if fields . $d}}
+{{- range fields . $d}}
+			{{.Name}}: {{get . $d}},
+{{- end}}
+		{{end}}}
+{{- end}}
+	}
+
+	return unmarshalMessage(t, b)
+}
+{{end}}{{end}}`))
+
+var testTemplate = template.Must(template.New("test").Funcs(funcs).Parse(header + `
+import (
+	"testing"
+{{- if usesDir .}}
+	"time"
+{{- end}}
+)
+
+// TestGeneratedMessages checks the generated encoding of each message
+// against the reflection based encoder.
+func TestGeneratedMessages(t *testing.T) {
+	for _, fcall := range []*Fcall{
+{{- range .}}{{range .Messages}}{{if not .NoMessage}}
+		newFcall(1, Message{{.Name}}{ {{- if .Fields}}
+{{- range .Fields}}
+			{{.Name}}: {{sample . nil}},
+{{- end}}
+		{{end}}}),
+{{- end}}{{end}}{{end}}
+	} {
+		t.Run(fcall.Type.String(), func(t *testing.T) {
+			testMarshal(t, fcall)
+		})
+	}
+}
+{{range $d := .}}{{if $d.Variants}}{{$id := ident $d.Name}}
+// TestGeneratedMessages{{$id}} checks that the messages encoded differently in
+// {{$d.Name}} round trip through its codec.
+func TestGeneratedMessages{{$id}}(t *testing.T) {
+	codec := codec9p{ {{- lower $id}}: true}
+	for _, fcall := range []*Fcall{
+{{- range $d.Variants}}
+		newFcall(1, Message{{.Name}}{ {{- if fields . $d}}
+{{- range fields . $d}}
+			{{.Name}}: {{sample . $d}},
+{{- end}}
+		{{end}}}),
+{{- end}}
+	} {
+		t.Run(fcall.Type.String(), func(t *testing.T) {
+			testRoundTrip(t, codec, fcall)
+		})
+	}
+}
+{{end}}{{end}}`))
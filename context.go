@@ -2,12 +2,19 @@ package p9p
 
 import (
 	"context"
+	"crypto/tls"
 )
 
 type contextKey string
 
 const (
 	versionKey contextKey = "9p.version"
+	msizeKey   contextKey = "9p.msize"
+	authKey    contextKey = "9p.auth"
+	tlsKey     contextKey = "9p.tls"
+	peerKey    contextKey = "9p.peercred"
+	tagKey     contextKey = "9p.tag"
+	buffersKey contextKey = "9p.buffers"
 )
 
 func withVersion(ctx context.Context, version string) context.Context {
@@ -24,3 +31,71 @@ func GetVersion(ctx context.Context) string {
 	}
 	return v
 }
+
+func withMSize(ctx context.Context, msize int) context.Context {
+	return context.WithValue(ctx, msizeKey, msize)
+}
+
+// GetMSize returns the negotiated msize from the context. If the msize is
+// not known, zero is returned. Like the version, this is set on the context
+// passed into function calls in a server implementation.
+func GetMSize(ctx context.Context) int {
+	v, ok := ctx.Value(msizeKey).(int)
+	if !ok {
+		return 0
+	}
+	return v
+}
+
+func withAuthInfo(ctx context.Context, info AuthInfo) context.Context {
+	return context.WithValue(ctx, authKey, info)
+}
+
+// GetAuthInfo returns the identity verified by an Authenticator from the
+// context. It is set on the context passed to Attach by the session returned
+// from NewAuthSession. The second return value is false if no identity was
+// verified.
+func GetAuthInfo(ctx context.Context) (AuthInfo, bool) {
+	info, ok := ctx.Value(authKey).(AuthInfo)
+	return info, ok
+}
+
+func withTLSState(ctx context.Context, state *tls.ConnectionState) context.Context {
+	return context.WithValue(ctx, tlsKey, state)
+}
+
+// GetTLSState returns the state of the TLS connection from the context. It is
+// set on the context passed to the handler by ServeConn when serving a
+// tls.Conn. The second return value is false for other connections.
+func GetTLSState(ctx context.Context) (*tls.ConnectionState, bool) {
+	state, ok := ctx.Value(tlsKey).(*tls.ConnectionState)
+	return state, ok
+}
+
+func withPeerCred(ctx context.Context, cred PeerCred) context.Context {
+	return context.WithValue(ctx, peerKey, cred)
+}
+
+// GetPeerCred returns the credentials of the peer from the context. It is set
+// on the context passed to the handler by ServeConn when serving a unix
+// socket on platforms that support it, currently Linux. The second return
+// value is false for other connections.
+func GetPeerCred(ctx context.Context) (PeerCred, bool) {
+	cred, ok := ctx.Value(peerKey).(PeerCred)
+	return cred, ok
+}
+
+func withTag(ctx context.Context, tag Tag) context.Context {
+	return context.WithValue(ctx, tagKey, tag)
+}
+
+// GetTag returns the tag of the request being handled from the context. It
+// is set on the context passed to the handler for each request by ServeConn.
+func GetTag(ctx context.Context) (Tag, bool) {
+	tag, ok := ctx.Value(tagKey).(Tag)
+	return tag, ok
+}
+
+func withBuffers(ctx context.Context, b *buffers) context.Context {
+	return context.WithValue(ctx, buffersKey, b)
+}
@@ -25,6 +25,9 @@ func (fn HandlerFunc) Handle(ctx context.Context, msg Message) (Message, error)
 // Dispatch returns a handler that dispatches messages to the target session.
 // No concurrency is managed by the returned handler. It simply turns messages
 // into function calls on the session.
+//
+// The 9P2000.L extension messages are dispatched if the session implements
+// SessionL.
 func Dispatch(session Session) Handler {
 	return HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
 		switch msg := msg.(type) {
@@ -79,7 +82,7 @@ func Dispatch(session Session) Handler {
 				IOUnit: iounit,
 			}, nil
 		case MessageTread:
-			p := make([]byte, int(msg.Count))
+			p := borrow(ctx, int(msg.Count))
 			n, err := session.Read(ctx, msg.Fid, p, int64(msg.Offset))
 			if err != nil {
 				return nil, err
@@ -127,7 +130,86 @@ func Dispatch(session Session) Handler {
 
 			return MessageRwstat{}, nil
 		default:
+			if sessionl, ok := session.(SessionL); ok {
+				return dispatchL(ctx, sessionl, msg)
+			}
+
 			return nil, ErrUnknownMsg
 		}
 	})
 }
+
+// dispatchL turns the 9P2000.L extension messages into function calls on
+// the session.
+func dispatchL(ctx context.Context, session SessionL, msg Message) (Message, error) {
+	switch msg := msg.(type) {
+	case MessageTstatfs:
+		statfs, err := session.Statfs(ctx, msg.Fid)
+		if err != nil {
+			return nil, err
+		}
+
+		return MessageRstatfs(statfs), nil
+	case MessageTgetattr:
+		attr, err := session.Getattr(ctx, msg.Fid, msg.RequestMask)
+		if err != nil {
+			return nil, err
+		}
+
+		return MessageRgetattr(attr), nil
+	case MessageTxattrwalk:
+		size, err := session.XattrWalk(ctx, msg.Fid, msg.Newfid, msg.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		return MessageRxattrwalk{Size: size}, nil
+	case MessageTxattrcreate:
+		if err := session.XattrCreate(ctx, msg.Fid, msg.Name, msg.Size, msg.Flags); err != nil {
+			return nil, err
+		}
+
+		return MessageRxattrcreate{}, nil
+	case MessageTfsync:
+		if err := session.Fsync(ctx, msg.Fid, msg.Datasync != 0); err != nil {
+			return nil, err
+		}
+
+		return MessageRfsync{}, nil
+	case MessageTlock:
+		status, err := session.Lock(ctx, msg.Fid, Lock{
+			Type:     msg.LockType,
+			Flags:    msg.Flags,
+			Start:    msg.Start,
+			Length:   msg.Length,
+			ProcID:   msg.ProcID,
+			ClientID: msg.ClientID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return MessageRlock{Status: status}, nil
+	case MessageTgetlock:
+		lock, err := session.GetLock(ctx, msg.Fid, Lock{
+			Type:     msg.LockType,
+			Start:    msg.Start,
+			Length:   msg.Length,
+			ProcID:   msg.ProcID,
+			ClientID: msg.ClientID,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return MessageRgetlock{
+			LockType: lock.Type,
+			Start:    lock.Start,
+			Length:   lock.Length,
+			ProcID:   lock.ProcID,
+			ClientID: lock.ClientID,
+		}, nil
+	default:
+		return nil, ErrUnknownMsg
+	}
+}
@@ -28,14 +28,60 @@ func NewCodec() Codec {
 	return codec9p{}
 }
 
-type codec9p struct{}
+// NewUnixCodec returns a codec for 9P2000.u, which extends the messages of
+// 9P2000 and Dir with the fields tagged with the dialect. The codec returned
+// by NewCodec ignores these fields.
+func NewUnixCodec() Codec {
+	return codec9p{dotu: true}
+}
+
+// NewVersionCodec returns the codec of the dialect negotiated as version, as
+// returned by GetVersion. Servers use it to encode the directory entries
+// returned by Read.
+func NewVersionCodec(version string) Codec {
+	return codec9p{dotu: version == UnixVersion}
+}
+
+// codec9p encodes messages in 9P2000 or, with dotu set, in 9P2000.u.
+type codec9p struct {
+	dotu bool
+}
+
+var _ BufferCodec = codec9p{}
 
 func (c codec9p) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *Fcall:
+		if ok, err := c.unmarshalFcall(data, v); ok {
+			return err
+		}
+	case *Dir:
+		if c.dotu {
+			b := rbuf{p: data}
+			*v = b.dirDotu()
+			return b.err
+		}
+	}
+
 	dec := &decoder{bytes.NewReader(data)}
 	return dec.decode(v)
 }
 
 func (c codec9p) Marshal(v interface{}) ([]byte, error) {
+	if fcall, ok := v.(*Fcall); ok {
+		if m, ok := fcall.Message.(marshaler); ok {
+			p := make([]byte, fcallHeaderSize+c.encodedSize(m))
+			_, _, err := c.marshalFcall(p, fcall)
+			return p, err
+		}
+	}
+
+	if d, ok := dirValue(v); ok && c.dotu {
+		p := make([]byte, sizedirDotu(d))
+		putdirDotu(p, d)
+		return p, nil
+	}
+
 	var b bytes.Buffer
 	enc := &encoder{&b}
 
@@ -46,10 +92,51 @@ func (c codec9p) Marshal(v interface{}) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+func (c codec9p) MarshalTo(p []byte, v interface{}) (int, error) {
+	if fcall, ok := v.(*Fcall); ok {
+		if n, ok, err := c.marshalFcall(p, fcall); ok {
+			return n, err
+		}
+	}
+
+	b, err := c.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(p) < len(b) {
+		return 0, io.ErrShortBuffer
+	}
+
+	return copy(p, b), nil
+}
+
 func (c codec9p) Size(v interface{}) int {
+	if fcall, ok := v.(*Fcall); ok {
+		if m, ok := fcall.Message.(marshaler); ok {
+			return fcallHeaderSize + c.encodedSize(m)
+		}
+	}
+
+	if d, ok := dirValue(v); ok && c.dotu {
+		return sizedirDotu(d)
+	}
+
 	return int(size9p(v))
 }
 
+// dirValue returns the Dir of v, if it is a Dir or a pointer to one.
+func dirValue(v interface{}) (Dir, bool) {
+	switch v := v.(type) {
+	case Dir:
+		return v, true
+	case *Dir:
+		return *v, true
+	}
+
+	return Dir{}, false
+}
+
 // DecodeDir decodes a directory entry from rd using the provided codec.
 func DecodeDir(codec Codec, rd io.Reader, d *Dir) error {
 	var ll uint16
@@ -59,7 +146,7 @@ func DecodeDir(codec Codec, rd io.Reader, d *Dir) error {
 		return err
 	}
 
-	p := make([]byte, ll+2)
+	p := make([]byte, int(ll)+2)
 	binary.LittleEndian.PutUint16(p, ll) // must have size at start
 
 	// read out the rest of the record
@@ -135,13 +222,21 @@ func (e *encoder) encode(vs ...interface{}) error {
 				return err
 			}
 		case time.Time:
-			if err := e.encode(uint32(v.Unix())); err != nil {
+			if err := e.encode(unixtime32(v)); err != nil {
 				return err
 			}
 		case *time.Time:
 			if err := e.encode(*v); err != nil {
 				return err
 			}
+		case Timespec:
+			if err := e.encode(v.Sec, v.Nsec); err != nil {
+				return err
+			}
+		case *Timespec:
+			if err := e.encode(*v); err != nil {
+				return err
+			}
 		case Qid:
 			if err := e.encode(v.Type, v.Version, v.Path); err != nil {
 				return err
@@ -235,6 +330,16 @@ type decoder struct {
 	rd io.Reader
 }
 
+// available returns an error if fewer than n bytes remain in rd, when that is
+// known, so that lengths read from the wire never allocate beyond the input.
+func (d *decoder) available(n int64) error {
+	if rd, ok := d.rd.(interface{ Len() int }); ok && n > int64(rd.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return nil
+}
+
 // read9p extracts values from rd and unmarshals them to the targets of vs.
 func (d *decoder) decode(vs ...interface{}) error {
 	for _, v := range vs {
@@ -250,6 +355,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 				return err
 			}
 
+			if err := d.available(int64(ll)); err != nil {
+				return err
+			}
+
 			if ll > 0 {
 				*v = make([]byte, int(ll))
 			}
@@ -265,6 +374,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 				return err
 			}
 
+			if err := d.available(int64(ll)); err != nil {
+				return err
+			}
+
 			b := make([]byte, ll)
 
 			n, err := io.ReadFull(d.rd, b)
@@ -284,6 +397,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 				return err
 			}
 
+			if err := d.available(int64(ll) * 2); err != nil {
+				return err
+			}
+
 			elements := make([]interface{}, int(ll))
 			*v = make([]string, int(ll))
 			for i := range elements {
@@ -300,6 +417,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 			}
 
 			*v = time.Unix(int64(epoch), 0).UTC()
+		case *Timespec:
+			if err := d.decode(&v.Sec, &v.Nsec); err != nil {
+				return err
+			}
 		case *Qid:
 			if err := d.decode(&v.Type, &v.Version, &v.Path); err != nil {
 				return err
@@ -311,6 +432,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 				return err
 			}
 
+			if err := d.available(int64(ll) * qidSize); err != nil {
+				return err
+			}
+
 			elements := make([]interface{}, int(ll))
 			*v = make([]Qid, int(ll))
 			for i := range elements {
@@ -327,6 +452,10 @@ func (d *decoder) decode(vs ...interface{}) error {
 				return err
 			}
 
+			if err := d.available(int64(ll)); err != nil {
+				return err
+			}
+
 			b := make([]byte, ll)
 			// must consume entire dir entry.
 			if _, err := io.ReadFull(d.rd, b); err != nil {
@@ -435,8 +564,9 @@ func size9p(vs ...interface{}) uint32 {
 		case *[]string:
 			s += size9p(*v)
 		case time.Time, *time.Time:
-			// BUG(stevvooe): Y2038 is coming.
 			s += size9p(uint32(0))
+		case Timespec, *Timespec:
+			s += size9p(uint64(0), uint64(0))
 		case Qid:
 			s += size9p(v.Type, v.Version, v.Path)
 		case *Qid:
@@ -528,6 +658,12 @@ func fields9p(v interface{}) ([]interface{}, error) {
 			continue
 		}
 
+		if _, ok := rv.Type().Field(i).Tag.Lookup("p9p"); ok {
+			// field of a dialect, such as 9P2000.u, not encoded by
+			// reflection.
+			continue
+		}
+
 		if f.CanAddr() {
 			f = f.Addr()
 		}
@@ -554,6 +690,11 @@ func string9p(v interface{}) string {
 	for i := 0; i < rv.NumField(); i++ {
 		f := rv.Field(i)
 
+		if _, ok := rv.Type().Field(i).Tag.Lookup("p9p"); ok && f.IsZero() {
+			// unset field of a dialect.
+			continue
+		}
+
 		s += fmt.Sprintf(" %v=%v", strings.ToLower(rv.Type().Field(i).Name), f.Interface())
 	}
 
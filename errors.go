@@ -3,11 +3,16 @@ package p9p
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"syscall"
 )
 
 // MessageRerror provides both a Go error type and message type.
 type MessageRerror struct {
 	Ename string
+
+	// 9P2000.u
+	Errno uint32 `p9p:"9P2000.u" json:",omitempty"`
 }
 
 // 9p wire errors returned by Session interface methods
@@ -19,6 +24,7 @@ var (
 	ErrCreatenondir = new9pError("create in non-directory")
 	ErrDupfid       = new9pError("duplicate fid")
 	ErrDuptag       = new9pError("duplicate tag")
+	ErrExist        = new9pError("file already exists")
 	ErrIsdir        = new9pError("is a directory")
 	ErrNocreate     = new9pError("create prohibited")
 	ErrNomem        = new9pError("out of memory")
@@ -31,6 +37,7 @@ var (
 	ErrUnknownfid   = new9pError("unknown fid")
 	ErrBaddir       = new9pError("bad directory in wstat")
 	ErrWalknodir    = new9pError("walk in non-directory")
+	ErrNoauth       = new9pError("authentication not required")
 
 	// extra errors not part of the normal protocol
 
@@ -39,9 +46,53 @@ var (
 	ErrUnknownMsg    = new9pError("unknown message")    // returned when encountering unknown message type
 	ErrUnexpectedMsg = new9pError("unexpected message") // returned when an unexpected message is encountered
 	ErrWalkLimit     = new9pError("too many wnames in walk")
+	ErrAuthRequired  = new9pError("authentication required")
+	ErrAuthFailed    = new9pError("authentication failed")
 	ErrClosed        = errors.New("closed")
 )
 
+// canonicalEnames are the enames of the 9p errors above, which carry no
+// details of the server.
+var canonicalEnames = map[string]bool{}
+
+func init() {
+	for _, err := range []error{
+		ErrBadattach, ErrBadoffset, ErrBadcount, ErrBotch, ErrCreatenondir,
+		ErrDupfid, ErrDuptag, ErrExist, ErrIsdir, ErrNocreate, ErrNomem,
+		ErrNoremove, ErrNostat, ErrNotfound, ErrNowrite, ErrNowstat, ErrPerm,
+		ErrUnknownfid, ErrBaddir, ErrWalknodir, ErrNoauth, ErrTimeout,
+		ErrUnknownTag, ErrUnknownMsg, ErrUnexpectedMsg, ErrWalkLimit,
+		ErrAuthRequired, ErrAuthFailed,
+	} {
+		canonicalEnames[err.(MessageRerror).Ename] = true
+	}
+}
+
+// osErrors pairs the errors of the os package with the canonical 9p errors
+// sent in their place, and the errno sent with them in 9P2000.u. Each 9p
+// error is also the os error, under errors.Is.
+var osErrors = []struct {
+	os    error
+	rerr  MessageRerror
+	errno syscall.Errno
+}{
+	{fs.ErrNotExist, ErrNotfound.(MessageRerror), syscall.ENOENT},
+	{fs.ErrPermission, ErrPerm.(MessageRerror), syscall.EACCES},
+	{fs.ErrExist, ErrExist.(MessageRerror), syscall.EEXIST},
+	{syscall.EISDIR, ErrIsdir.(MessageRerror), syscall.EISDIR},
+}
+
+// errnos are the errnos sent as canonical 9p errors. Others are sent as
+// their description, since errors.Is matches errnos loosely, such as
+// ENOTEMPTY to fs.ErrExist.
+var errnos = map[syscall.Errno]MessageRerror{
+	syscall.ENOENT: ErrNotfound.(MessageRerror),
+	syscall.EACCES: ErrPerm.(MessageRerror),
+	syscall.EPERM:  ErrPerm.(MessageRerror),
+	syscall.EEXIST: ErrExist.(MessageRerror),
+	syscall.EISDIR: ErrIsdir.(MessageRerror),
+}
+
 // new9pError returns a new 9p error ready for the wire.
 func new9pError(s string) error {
 	return MessageRerror{Ename: s}
@@ -56,3 +107,108 @@ func (MessageRerror) Type() FcallType {
 func (e MessageRerror) Error() string {
 	return fmt.Sprintf("9p: %v", e.Ename)
 }
+
+// Is reports whether the error is a 9p error with the same Ename or the os
+// error for its Ename, such as fs.ErrNotExist for ErrNotfound.
+func (e MessageRerror) Is(target error) bool {
+	if t, ok := target.(MessageRerror); ok {
+		// the errno of 9P2000.u, if any, is left to Unwrap.
+		return e.Ename == t.Ename
+	}
+
+	for _, oe := range osErrors {
+		if e.Ename == oe.rerr.Ename && target == oe.os {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unwrap returns the errno carried by the error in 9P2000.u, if any, so that
+// it is also the os error for the errno under errors.Is. As with
+// MessageRlerror, the errno is that of the server, usually Linux.
+func (e MessageRerror) Unwrap() error {
+	if e.Errno == 0 {
+		return nil
+	}
+
+	return syscall.Errno(e.Errno)
+}
+
+// rerror translates err into an Rerror for the wire. Errors that are os
+// errors are sent as the canonical 9p error and other errnos as their
+// description, without the paths of the server.
+func rerror(err error) MessageRerror {
+	var rerr MessageRerror
+	if errors.As(err, &rerr) {
+		return rerr
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if rerr, ok := errnos[errno]; ok {
+			return rerr
+		}
+
+		return MessageRerror{Ename: errno.Error()}
+	}
+
+	for _, oe := range osErrors {
+		if errors.Is(err, oe.os) {
+			return oe.rerr
+		}
+	}
+
+	return MessageRerror{Ename: err.Error()}
+}
+
+// errnoOf returns the errno sent with rerr, the translation of err, in
+// 9P2000.u: the errno of err or of the canonical 9p error, zero if neither
+// has one.
+func errnoOf(err error, rerr MessageRerror) syscall.Errno {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+
+	for _, oe := range osErrors {
+		if rerr.Ename == oe.rerr.Ename {
+			return oe.errno
+		}
+	}
+
+	return 0
+}
+
+func (e MessageRlerror) Error() string {
+	return fmt.Sprintf("9p: %v", syscall.Errno(e.Ecode).Error())
+}
+
+// Unwrap returns the errno of the error, so that it is also the os error for
+// the errno under errors.Is. The errno is that of Linux, which may differ on
+// other platforms.
+func (e MessageRlerror) Unwrap() error {
+	return syscall.Errno(e.Ecode)
+}
+
+// OpError is returned by a client session when the server responds to a
+// request with an error. Err is the error of the response, a MessageRerror or
+// MessageRlerror, which errors.Is and errors.As see through OpError.
+type OpError struct {
+	Op  FcallType // type of the request, such as Twalk
+	Fid Fid       // fid of the request, or NOFID
+	Err error
+}
+
+func (e *OpError) Error() string {
+	if e.Fid == NOFID {
+		return fmt.Sprintf("%v: %v", e.Op, e.Err)
+	}
+
+	return fmt.Sprintf("%v fid %v: %v", e.Op, e.Fid, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,135 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	for _, testcase := range []struct {
+		err    error
+		target error
+		is     bool
+	}{
+		{ErrNotfound, fs.ErrNotExist, true},
+		{ErrPerm, fs.ErrPermission, true},
+		{ErrExist, fs.ErrExist, true},
+		{ErrIsdir, syscall.EISDIR, true},
+		{ErrNotfound, fs.ErrPermission, false},
+		{MessageRerror{Ename: "file not found"}, ErrNotfound, true},
+		{&OpError{Op: Twalk, Fid: 1, Err: ErrNotfound}, fs.ErrNotExist, true},
+		{&OpError{Op: Twalk, Fid: 1, Err: ErrNotfound}, ErrNotfound, true},
+		{MessageRlerror{Ecode: uint32(syscall.ENOENT)}, fs.ErrNotExist, true},
+		{MessageRlerror{Ecode: uint32(syscall.EACCES)}, syscall.EACCES, true},
+	} {
+		if is := errors.Is(testcase.err, testcase.target); is != testcase.is {
+			t.Fatalf("errors.Is(%v, %v) = %v, expected %v", testcase.err, testcase.target, is, testcase.is)
+		}
+	}
+}
+
+func TestErrorFcall(t *testing.T) {
+	for _, testcase := range []struct {
+		err      error
+		expected Message
+	}{
+		{ErrUnknownfid, ErrUnknownfid.(MessageRerror)},
+		{fmt.Errorf("wrapped: %w", ErrPerm), ErrPerm.(MessageRerror)},
+		{&fs.PathError{Op: "open", Path: "/srv/secret", Err: syscall.ENOENT}, ErrNotfound.(MessageRerror)},
+		{&fs.PathError{Op: "open", Path: "/srv/secret", Err: syscall.EACCES}, ErrPerm.(MessageRerror)},
+		{&fs.PathError{Op: "rmdir", Path: "/srv/dir", Err: syscall.ENOTEMPTY}, MessageRerror{Ename: syscall.ENOTEMPTY.Error()}},
+		{errors.New("something else"), MessageRerror{Ename: "something else"}},
+		{MessageRlerror{Ecode: uint32(syscall.EIO)}, MessageRlerror{Ecode: uint32(syscall.EIO)}},
+	} {
+		fcall := newErrorFcall(1, testcase.err)
+		if fcall.Message != testcase.expected || fcall.Type != testcase.expected.Type() {
+			t.Fatalf("unexpected error fcall for %v: %v", testcase.err, fcall)
+		}
+	}
+}
+
+// notfoundSession fails every walk with an os error.
+type notfoundSession struct {
+	rwSession
+}
+
+func (s *notfoundSession) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
+	_, err := os.Stat("/nonexistent/" + names[0])
+	return nil, err
+}
+
+func TestClientOpError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session, closer, err := Pipe(ctx, Dispatch(&notfoundSession{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	_, err = session.Walk(ctx, 1, 2, "file")
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, ErrNotfound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	var operr *OpError
+	if !errors.As(err, &operr) || operr.Op != Twalk || operr.Fid != 1 {
+		t.Fatalf("unexpected op error: %#v", err)
+	}
+}
+
+// errnoSession fails walks and removes with the errors of the os.
+type errnoSession struct {
+	notfoundSession
+	dir string // not empty
+}
+
+func (s *errnoSession) Remove(ctx context.Context, fid Fid) error {
+	return os.Remove(s.dir)
+}
+
+func TestUnixErrno(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+	go ServeConn(ctx, sconn, Dispatch(&errnoSession{dir: dir}), WithUnix())
+
+	session, err := NewSession(ctx, cconn, WithUnix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, version := session.Version(); version != UnixVersion {
+		t.Fatalf("expected %v, got %v", UnixVersion, version)
+	}
+
+	_, err = session.Walk(ctx, 1, 2, "file")
+	if !errors.Is(err, fs.ErrNotExist) || !errors.Is(err, syscall.ENOENT) || !errors.Is(err, ErrNotfound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	var rerr MessageRerror
+	if !errors.As(err, &rerr) || rerr.Errno != uint32(syscall.ENOENT) {
+		t.Fatalf("expected errno with the error: %#v", err)
+	}
+
+	// errnos without a canonical 9p error are only seen in 9P2000.u.
+	if err := session.Remove(ctx, 1); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("expected %v, got %v", syscall.ENOTEMPTY, err)
+	}
+}
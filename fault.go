@@ -0,0 +1,524 @@
+package p9p
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Fault is a fault injected into a frame by a FaultChannel.
+type Fault int
+
+const (
+	FaultNone      Fault = iota
+	FaultDelay           // delay the frame
+	FaultDrop            // lose the frame
+	FaultDuplicate       // pass the frame twice
+	FaultReorder         // pass the frame after the next one
+	FaultCorrupt         // flip the bits of a byte of the frame
+	FaultTruncate        // cut the frame in half and close the connection, with FaultConn
+	FaultTemporary       // fail with a temporary net.Error, passing the frame later
+	FaultMSize           // halve the msize of a version message
+)
+
+var faultNames = [...]string{
+	FaultNone:      "none",
+	FaultDelay:     "delay",
+	FaultDrop:      "drop",
+	FaultDuplicate: "duplicate",
+	FaultReorder:   "reorder",
+	FaultCorrupt:   "corrupt",
+	FaultTruncate:  "truncate",
+	FaultTemporary: "temporary",
+	FaultMSize:     "msize",
+}
+
+func (f Fault) String() string {
+	if f >= 0 && int(f) < len(faultNames) {
+		return faultNames[f]
+	}
+
+	return "unknown"
+}
+
+// FaultPlan decides the fault to inject into each frame passing through a
+// FaultChannel. The frames of each direction are passed in order, but the
+// two directions may be passed concurrently.
+type FaultPlan interface {
+	Fault(dir Direction, fcall *Fcall) Fault
+}
+
+// RandomFaults returns a plan injecting one of faults, chosen at random,
+// into each frame with probability rate. The faults of each direction are
+// determined by seed, so that a failure can be reproduced.
+func RandomFaults(seed int64, rate float64, faults ...Fault) FaultPlan {
+	return &randomFaults{
+		rngs: [2]*rand.Rand{
+			Received: rand.New(rand.NewSource(seed)),
+			Sent:     rand.New(rand.NewSource(seed + 1)),
+		},
+		rate:   rate,
+		faults: faults,
+	}
+}
+
+type randomFaults struct {
+	rngs   [2]*rand.Rand // per direction, as each is used by one goroutine
+	rate   float64
+	faults []Fault
+}
+
+func (r *randomFaults) Fault(dir Direction, fcall *Fcall) Fault {
+	rng := r.rngs[dir]
+	if len(r.faults) == 0 || rng.Float64() >= r.rate {
+		return FaultNone
+	}
+
+	return r.faults[rng.Intn(len(r.faults))]
+}
+
+// FaultStep is a step of a FaultScript, injecting a fault into the next
+// frame in a direction.
+type FaultStep struct {
+	Direction Direction
+	Type      FcallType // if set, the step waits for a frame of the type
+	Fault     Fault
+}
+
+// FaultScript returns a plan injecting the faults of steps in order. Each
+// direction follows its own steps: frames before the next step for their
+// direction, or of another type than it waits for, pass untouched, as do all
+// frames once the steps are done.
+func FaultScript(steps ...FaultStep) FaultPlan {
+	var s faultScript
+	for _, step := range steps {
+		s.steps[step.Direction] = append(s.steps[step.Direction], step)
+	}
+
+	return &s
+}
+
+type faultScript struct {
+	steps [2][]FaultStep // per direction, as each is used by one goroutine
+}
+
+func (s *faultScript) Fault(dir Direction, fcall *Fcall) Fault {
+	steps := s.steps[dir]
+	if len(steps) == 0 || (steps[0].Type != 0 && steps[0].Type != fcall.Type) {
+		return FaultNone
+	}
+
+	s.steps[dir] = steps[1:]
+	return steps[0].Fault
+}
+
+// FaultChannel is a Channel injecting faults into the frames read from and
+// written to another channel, for testing the handling of unreliable
+// connections. Use it with WithChannel:
+//
+//	p9p.WithChannel(func(ch p9p.Channel) p9p.Channel {
+//		return p9p.NewFaultChannel(ch, p9p.RandomFaults(seed, 0.01, p9p.FaultDrop))
+//	})
+//
+// Frames that are corrupted are damaged in their encoding, in the version
+// negotiated by the last Rversion passed. When read, the damaged frame is
+// decoded, usually failing. When written, it is sent if it still decodes and
+// is lost otherwise. A frame held back by FaultReorder is passed after the
+// next frame in its direction. A written frame is passed after Delay if no
+// other frame follows it by then.
+//
+// FaultTruncate damages the framing of the connection, so it is injected by
+// FaultConn instead. The channel passes such frames untouched.
+type FaultChannel struct {
+	Channel
+
+	// Delay is the delay of frames with FaultDelay, and the longest a
+	// written frame is held back by FaultReorder.
+	Delay time.Duration
+
+	plan FaultPlan
+
+	pending []Fcall // frames to read before the next from the channel
+	reads   int     // frames read, for choosing the byte to corrupt
+
+	mu       sync.Mutex // protects writes, which may be flushed by a timer
+	version  string     // negotiated, for the encoding of corrupted frames
+	held     *Fcall     // a frame to write after the next
+	flush    *time.Timer
+	flushErr error // of writing the held frame after the delay
+	writes   int
+}
+
+var _ Channel = &FaultChannel{}
+
+// NewFaultChannel returns a channel injecting the faults of plan into ch,
+// delaying frames by 10ms by default.
+func NewFaultChannel(ch Channel, plan FaultPlan) *FaultChannel {
+	return &FaultChannel{
+		Channel: ch,
+		Delay:   10 * time.Millisecond,
+		plan:    plan,
+	}
+}
+
+// ReadFcall reads the next frame from the channel, injecting the fault
+// planned for it.
+func (ch *FaultChannel) ReadFcall(ctx context.Context, fcall *Fcall) error {
+	if len(ch.pending) > 0 {
+		*fcall = ch.pending[0]
+		ch.pending = ch.pending[1:]
+		return nil
+	}
+
+	if err := ch.Channel.ReadFcall(ctx, fcall); err != nil {
+		return err
+	}
+	ch.reads++
+
+	ch.mu.Lock()
+	ch.negotiated(fcall)
+	codec := NewVersionCodec(ch.version)
+	ch.mu.Unlock()
+
+	switch fault := ch.plan.Fault(Received, fcall); fault {
+	case FaultDelay:
+		return sleep(ctx, ch.Delay)
+	case FaultDrop:
+		return ch.ReadFcall(ctx, fcall)
+	case FaultDuplicate:
+		// frames passed on later calls are copied, as they outlive the
+		// request they were read for.
+		ch.pending = append(ch.pending, detach(*fcall))
+	case FaultReorder:
+		held := detach(*fcall)
+		if err := ch.ReadFcall(ctx, fcall); err != nil {
+			// nothing follows, so the held frame goes first after all.
+			*fcall = held
+			return nil
+		}
+		ch.pending = append(ch.pending, held)
+	case FaultCorrupt:
+		corrupted, err := corrupt(codec, fcall, ch.reads)
+		if err != nil {
+			return err
+		}
+		*fcall = *corrupted
+	case FaultTemporary:
+		ch.pending = append(ch.pending, detach(*fcall))
+		return temporaryFault{}
+	case FaultMSize:
+		*fcall = *halveMSize(fcall)
+	}
+
+	return nil
+}
+
+// WriteFcall writes fcall to the channel, injecting the fault planned for
+// it. If writing a held frame after the delay failed, the error is returned
+// instead.
+func (ch *FaultChannel) WriteFcall(ctx context.Context, fcall *Fcall) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if err := ch.flushErr; err != nil {
+		ch.flushErr = nil
+		return err
+	}
+
+	ch.writes++
+	ch.negotiated(fcall)
+
+	switch fault := ch.plan.Fault(Sent, fcall); fault {
+	case FaultDelay:
+		if err := sleep(ctx, ch.Delay); err != nil {
+			return err
+		}
+	case FaultDrop:
+		return nil
+	case FaultDuplicate:
+		if err := ch.Channel.WriteFcall(ctx, fcall); err != nil {
+			return err
+		}
+	case FaultReorder:
+		// a frame already held is overtaken by this one.
+		if err := ch.writeHeld(ctx); err != nil {
+			return err
+		}
+
+		// copied, as the data may be released once the write returns.
+		held := detach(*fcall)
+		ch.held = &held
+		ch.flush = time.AfterFunc(ch.Delay, ch.flushHeld)
+		return nil
+	case FaultCorrupt:
+		corrupted, err := corrupt(NewVersionCodec(ch.version), fcall, ch.writes)
+		if err != nil {
+			return nil // lost, as the peer would fail to decode it
+		}
+		fcall = corrupted
+	case FaultTemporary:
+		return temporaryFault{}
+	case FaultMSize:
+		fcall = halveMSize(fcall)
+	}
+
+	if err := ch.Channel.WriteFcall(ctx, fcall); err != nil {
+		return err
+	}
+
+	return ch.writeHeld(ctx)
+}
+
+// writeHeld writes the frame held back by FaultReorder, if any. The caller
+// must hold ch.mu.
+func (ch *FaultChannel) writeHeld(ctx context.Context) error {
+	if ch.held == nil {
+		return nil
+	}
+	ch.flush.Stop()
+
+	held := ch.held
+	ch.held = nil
+
+	return ch.Channel.WriteFcall(ctx, held)
+}
+
+// flushHeld writes the frame held back by FaultReorder when no frame has
+// followed it within the delay, so that the last frame isn't held forever.
+func (ch *FaultChannel) flushHeld() {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	// an error here is returned by the next write.
+	if err := ch.writeHeld(context.Background()); err != nil {
+		ch.flushErr = err
+	}
+}
+
+// negotiated tracks the version of an Rversion passing in either direction.
+// The caller must hold ch.mu.
+func (ch *FaultChannel) negotiated(fcall *Fcall) {
+	if rversion, ok := fcall.Message.(MessageRversion); ok {
+		ch.version = rversion.Version
+	}
+}
+
+// corrupt returns fcall decoded after flipping the bits of a byte of its
+// encoding with codec, chosen by n.
+func corrupt(codec Codec, fcall *Fcall, n int) (*Fcall, error) {
+	p, err := codec.Marshal(fcall)
+	if err != nil {
+		return nil, err
+	}
+	p[n%len(p)] ^= 0xff
+
+	corrupted := new(Fcall)
+	if err := codec.Unmarshal(p, corrupted); err != nil {
+		return nil, err
+	}
+
+	return corrupted, nil
+}
+
+// FaultConn is a net.Conn injecting faults into the frames read from and
+// written to another connection. Only FaultTruncate is injected: the first
+// half of the frame is passed and the connection is closed, so that the peer
+// reads a partial frame. Other faults are injected with a FaultChannel.
+//
+// The frames are not decoded, so the fcall passed to the plan only has its
+// type and tag set. Frames read over maxFaultFrame are passed without
+// faults, rather than buffered.
+type FaultConn struct {
+	net.Conn
+
+	plan FaultPlan
+
+	rbuf  []byte // bytes of the frame being read
+	rskip int    // bytes of an oversized frame to pass after rbuf
+	reof  bool   // the connection is closed after rbuf
+	wbuf  []byte // bytes of the frame being written
+}
+
+// maxFaultFrame limits the size of the frames buffered by FaultConn, as the
+// size read can't be trusted.
+const maxFaultFrame = 16 << 20
+
+var _ net.Conn = &FaultConn{}
+
+// NewFaultConn returns a connection injecting the faults of plan into conn.
+func NewFaultConn(conn net.Conn, plan FaultPlan) *FaultConn {
+	return &FaultConn{Conn: conn, plan: plan}
+}
+
+// Read reads from the frame being read, reading the next frame from the
+// connection once it is consumed.
+func (c *FaultConn) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		if c.rskip > 0 {
+			if len(p) > c.rskip {
+				p = p[:c.rskip]
+			}
+			n, err := c.Conn.Read(p)
+			c.rskip -= n
+			return n, err
+		}
+
+		if c.reof {
+			return 0, io.EOF
+		}
+
+		if err := c.readframe(); err != nil {
+			n := copy(p, c.rbuf)
+			c.rbuf = c.rbuf[n:]
+			return n, err
+		}
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+// readframe reads the next frame from the connection into rbuf. If reading
+// fails, rbuf holds the bytes read before the error.
+func (c *FaultConn) readframe() error {
+	hdr := make([]byte, channelMessageHeaderSize)
+	if n, err := io.ReadFull(c.Conn, hdr); err != nil {
+		c.rbuf = hdr[:n]
+		return err
+	}
+
+	size := int(binary.LittleEndian.Uint32(hdr))
+	if size < channelMessageHeaderSize+3 {
+		// not a frame, left for the reader to reject.
+		c.rbuf = hdr
+		return nil
+	} else if size > maxFaultFrame {
+		c.rbuf = hdr
+		c.rskip = size - channelMessageHeaderSize
+		return nil
+	}
+
+	// the buffer grows as the frame arrives, rather than to size.
+	frame := bytes.NewBuffer(hdr)
+	if _, err := io.CopyN(frame, c.Conn, int64(size-channelMessageHeaderSize)); err != nil {
+		c.rbuf = frame.Bytes()
+		return err
+	}
+	c.rbuf = frame.Bytes()
+
+	if c.plan.Fault(Received, frameFcall(c.rbuf)) == FaultTruncate {
+		c.rbuf = c.rbuf[:size/2]
+		c.reof = true
+		return c.Conn.Close()
+	}
+
+	return nil
+}
+
+// Write writes p to the connection once it completes a frame. Frames may be
+// written in several calls.
+func (c *FaultConn) Write(p []byte) (int, error) {
+	c.wbuf = append(c.wbuf, p...)
+	for len(c.wbuf) >= channelMessageHeaderSize {
+		size := int(binary.LittleEndian.Uint32(c.wbuf))
+		if size < channelMessageHeaderSize+3 {
+			// not a frame, passed for the peer to reject.
+			_, err := c.Conn.Write(c.wbuf)
+			c.wbuf = nil
+			if err != nil {
+				return 0, err
+			}
+			break
+		} else if len(c.wbuf) < size {
+			break
+		}
+
+		frame := c.wbuf[:size]
+		c.wbuf = c.wbuf[size:]
+
+		if c.plan.Fault(Sent, frameFcall(frame)) == FaultTruncate {
+			c.wbuf = nil
+			if _, err := c.Conn.Write(frame[:size/2]); err != nil {
+				return 0, err
+			}
+
+			// the write succeeded as far as the writer can tell.
+			return len(p), c.Conn.Close()
+		}
+
+		if _, err := c.Conn.Write(frame); err != nil {
+			c.wbuf = nil
+			return 0, err
+		}
+	}
+
+	if len(c.wbuf) == 0 {
+		c.wbuf = nil // not retained between frames
+	}
+
+	return len(p), nil
+}
+
+// frameFcall returns an fcall with the type and tag of an encoded frame.
+func frameFcall(p []byte) *Fcall {
+	p = p[channelMessageHeaderSize:]
+	return &Fcall{
+		Type: FcallType(p[0]),
+		Tag:  Tag(binary.LittleEndian.Uint16(p[1:])),
+	}
+}
+
+// halveMSize returns a copy of fcall with the msize halved, if it is a
+// version message. Other frames are returned as is.
+func halveMSize(fcall *Fcall) *Fcall {
+	switch msg := fcall.Message.(type) {
+	case MessageTversion:
+		msg.MSize /= 2
+		return newFcall(fcall.Tag, msg)
+	case MessageRversion:
+		msg.MSize /= 2
+		return newFcall(fcall.Tag, msg)
+	}
+
+	return fcall
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// detach copies the data of fcall that aliases the frame it was read from,
+// which is released with the request it was read for.
+func detach(fcall Fcall) Fcall {
+	switch msg := fcall.Message.(type) {
+	case MessageRread:
+		msg.Data = bytes.Clone(msg.Data)
+		fcall.Message = msg
+	case MessageTwrite:
+		msg.Data = bytes.Clone(msg.Data)
+		fcall.Message = msg
+	}
+
+	return fcall
+}
+
+// temporaryFault is the error injected by FaultTemporary.
+type temporaryFault struct{}
+
+func (temporaryFault) Error() string   { return "p9p: injected temporary fault" }
+func (temporaryFault) Timeout() bool   { return true }
+func (temporaryFault) Temporary() bool { return true }
@@ -0,0 +1,403 @@
+package p9p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFaultScript(t *testing.T) {
+	plan := FaultScript(
+		FaultStep{Direction: Sent, Type: Tread, Fault: FaultDrop},
+		FaultStep{Direction: Received, Fault: FaultDuplicate},
+		FaultStep{Direction: Sent, Fault: FaultDelay},
+	)
+
+	for _, step := range []struct {
+		dir   Direction
+		fcall *Fcall
+		fault Fault
+	}{
+		{Sent, newFcall(1, MessageTstat{}), FaultNone},
+		{Received, newFcall(1, MessageRstat{}), FaultDuplicate},
+		{Sent, newFcall(2, MessageTread{}), FaultDrop},
+		{Received, newFcall(2, MessageRread{}), FaultNone},
+		{Sent, newFcall(3, MessageTclunk{}), FaultDelay},
+		{Sent, newFcall(4, MessageTread{}), FaultNone},
+	} {
+		if fault := plan.Fault(step.dir, step.fcall); fault != step.fault {
+			t.Fatalf("%v %v: expected %v, got %v", step.dir, step.fcall, step.fault, fault)
+		}
+	}
+}
+
+func TestRandomFaults(t *testing.T) {
+	faults := func(seed int64) []Fault {
+		plan := RandomFaults(seed, 0.5, FaultDrop, FaultCorrupt)
+
+		var faults []Fault
+		for i := 0; i < 64; i++ {
+			// the sent faults don't depend on those received.
+			if i%3 == 0 {
+				plan.Fault(Received, newFcall(1, MessageRstat{}))
+			}
+			faults = append(faults, plan.Fault(Sent, newFcall(1, MessageTstat{})))
+		}
+		return faults
+	}
+
+	a, b := faults(1), faults(1)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatalf("faults differ for the same seed: %v != %v", a, b)
+	}
+
+	if reflect.DeepEqual(a, faults(2)) {
+		t.Fatalf("faults are the same for different seeds: %v", a)
+	}
+}
+
+// faultPipe returns a session served by rwSession, injecting the faults of
+// plan into the client end.
+func faultPipe(t *testing.T, ctx context.Context, plan FaultPlan) Session {
+	t.Helper()
+
+	session, closer, err := Pipe(ctx, Dispatch(&rwSession{}), WithChannel(func(ch Channel) Channel {
+		return NewFaultChannel(ch, plan)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closer() })
+
+	return session
+}
+
+func TestFaultChannel(t *testing.T) {
+	const (
+		recovers = iota // the requests succeed
+		fails           // a request may fail, leaving the session usable
+		fatal           // a request fails, ending the session
+	)
+
+	for _, testcase := range []struct {
+		step    FaultStep
+		outcome int
+	}{
+		{FaultStep{Direction: Sent, Type: Tread, Fault: FaultDelay}, recovers},
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultDelay}, recovers},
+		{FaultStep{Direction: Sent, Type: Tread, Fault: FaultDuplicate}, fails}, // duplicate tag
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultDuplicate}, recovers},
+		{FaultStep{Direction: Sent, Type: Tread, Fault: FaultReorder}, recovers},
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultReorder}, recovers},
+		{FaultStep{Direction: Sent, Type: Tread, Fault: FaultTemporary}, fails},
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultTemporary}, recovers},
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultCorrupt}, fails},     // unknown tag
+		{FaultStep{Direction: Received, Type: Rread, Fault: FaultTruncate}, recovers}, // only injected by FaultConn
+	} {
+		t.Run(fmt.Sprintf("%v/%v", testcase.step.Direction, testcase.step.Fault), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			session := faultPipe(t, ctx, FaultScript(testcase.step))
+
+			// two requests in flight, so that one can overtake the other.
+			rctx, rcancel := context.WithTimeout(ctx, 500*time.Millisecond)
+			defer rcancel()
+
+			errs := make(chan error, 2)
+			for i := 0; i < 2; i++ {
+				go func() {
+					_, err := session.Read(rctx, 1, make([]byte, 64), 0)
+					errs <- err
+				}()
+			}
+
+			var failed int
+			for i := 0; i < 2; i++ {
+				if err := <-errs; err != nil {
+					if testcase.outcome == recovers {
+						t.Fatal(err)
+					}
+					failed++
+				}
+			}
+
+			switch {
+			case testcase.outcome == fatal && failed == 0:
+				t.Fatal("expected error")
+			case testcase.outcome == fatal:
+				return
+			}
+
+			// the session is still usable after the fault.
+			p := make([]byte, 64)
+			for i := 0; i < 3; i++ {
+				if n, err := session.Read(ctx, 1, p, 0); err != nil {
+					t.Fatal(err)
+				} else if string(p[:n]) != "secret data" {
+					t.Fatalf("unexpected read: %q", p[:n])
+				}
+			}
+		})
+	}
+}
+
+func TestFaultChannelDrop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session := faultPipe(t, ctx, FaultScript(FaultStep{Direction: Sent, Type: Tread, Fault: FaultDrop}))
+
+	// the request is lost, so the call times out and is flushed.
+	rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer rcancel()
+
+	p := make([]byte, 64)
+	if _, err := session.Read(rctx, 1, p, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	if _, err := session.Read(ctx, 1, p, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFaultChannelReorderLast ensures that a frame held back by FaultReorder
+// is passed after the delay when no frame follows it.
+func TestFaultChannelReorderLast(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session := faultPipe(t, ctx, FaultScript(FaultStep{Direction: Sent, Type: Tread, Fault: FaultReorder}))
+
+	rctx, rcancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer rcancel()
+
+	if _, err := session.Read(rctx, 1, make([]byte, 64), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFaultConnTruncate ensures that a truncated frame is read as a partial
+// frame, ending the connection.
+func TestFaultConnTruncate(t *testing.T) {
+	for _, dir := range []Direction{Sent, Received} {
+		t.Run(fmt.Sprint(dir), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			typ := Tread
+			if dir == Received {
+				typ = Rread
+			}
+
+			cconn, sconn := net.Pipe()
+			fconn := NewFaultConn(cconn, FaultScript(FaultStep{Direction: dir, Type: typ, Fault: FaultTruncate}))
+			defer fconn.Close()
+
+			served := make(chan error, 1)
+			go func() {
+				defer sconn.Close()
+				served <- ServeConn(ctx, sconn, Dispatch(&rwSession{}))
+			}()
+
+			session, err := NewSession(ctx, fconn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := session.Read(ctx, 1, make([]byte, 64), 0); err == nil {
+				t.Fatal("expected error")
+			}
+
+			err = <-served
+			if dir == Sent && (err == nil || !strings.Contains(err.Error(), "partial frame")) {
+				t.Fatalf("expected partial frame, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFaultChannelMSize(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the server agrees to half the msize of the client, failing writes
+	// larger than that.
+	session := faultPipe(t, ctx, FaultScript(FaultStep{Direction: Sent, Type: Tversion, Fault: FaultMSize}))
+
+	if _, err := session.Write(ctx, 1, make([]byte, 2*DefaultMSize/3), 0); err == nil {
+		t.Fatal("expected error with mismatched msize")
+	}
+}
+
+// writtenChannel keeps the fcalls written to it, failing with err if set.
+type writtenChannel struct {
+	Channel
+
+	mu      sync.Mutex
+	written []*Fcall
+	err     error
+}
+
+func (ch *writtenChannel) WriteFcall(ctx context.Context, fcall *Fcall) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.err != nil {
+		return ch.err
+	}
+	ch.written = append(ch.written, fcall)
+	return nil
+}
+
+// TestFaultChannelCorruptUnix ensures that frames are corrupted in the
+// negotiated version, keeping the fields of 9P2000.u.
+func TestFaultChannelCorruptUnix(t *testing.T) {
+	ctx := context.Background()
+
+	wch := &writtenChannel{}
+	ch := NewFaultChannel(wch, FaultScript(FaultStep{Direction: Sent, Type: Rerror, Fault: FaultCorrupt}))
+
+	if err := ch.WriteFcall(ctx, newFcall(NOTAG, MessageRversion{MSize: DefaultMSize, Version: UnixVersion})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second write damages the tag, leaving the errno.
+	if err := ch.WriteFcall(ctx, newFcall(1, MessageRerror{Ename: "file not found", Errno: uint32(syscall.ENOENT)})); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wch.written) != 2 {
+		t.Fatalf("expected the corrupted frame to be written: %v", wch.written)
+	}
+
+	if fcall := wch.written[1]; fcall.Tag == 1 || fcall.Message.(MessageRerror).Errno != uint32(syscall.ENOENT) {
+		t.Fatalf("unexpected corrupted frame: %v", fcall)
+	}
+}
+
+// TestFaultChannelFlushError ensures that an error writing a held frame
+// after the delay is returned by the next write.
+func TestFaultChannelFlushError(t *testing.T) {
+	ctx := context.Background()
+
+	wch := &writtenChannel{err: errors.New("broken")}
+	ch := NewFaultChannel(wch, FaultScript(FaultStep{Direction: Sent, Type: Tread, Fault: FaultReorder}))
+	ch.Delay = time.Millisecond
+
+	if err := ch.WriteFcall(ctx, newFcall(1, MessageTread{Fid: 1, Count: 64})); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ch.mu.Lock()
+		held := ch.held
+		ch.mu.Unlock()
+		if held == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("held frame was not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := ch.WriteFcall(ctx, newFcall(2, MessageTclunk{Fid: 1})); err != wch.err {
+		t.Fatalf("expected %v, got %v", wch.err, err)
+	}
+}
+
+// TestFaultConnOversized ensures that frames too large to buffer are passed
+// without faults.
+func TestFaultConnOversized(t *testing.T) {
+	cconn, sconn := net.Pipe()
+	defer sconn.Close()
+
+	size := maxFaultFrame + 1
+	go func() {
+		defer cconn.Close()
+		frame := make([]byte, size)
+		binary.LittleEndian.PutUint32(frame, uint32(size))
+		frame[4] = byte(Rread)
+		cconn.Write(frame)
+	}()
+
+	fconn := NewFaultConn(sconn, FaultScript(FaultStep{Direction: Received, Fault: FaultTruncate}))
+	n, err := io.Copy(io.Discard, fconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(size) {
+		t.Fatalf("expected %v bytes, got %v", size, n)
+	}
+}
+
+// afterVersion injects the faults of a plan once the version is negotiated.
+type afterVersion struct {
+	FaultPlan
+}
+
+func (p afterVersion) Fault(dir Direction, fcall *Fcall) Fault {
+	if fcall.Type == Tversion || fcall.Type == Rversion {
+		return FaultNone
+	}
+
+	return p.FaultPlan.Fault(dir, fcall)
+}
+
+// TestFaultChaos runs requests through connections with random faults on
+// both ends, which must each complete or fail without hanging.
+func TestFaultChaos(t *testing.T) {
+	faults := []Fault{
+		FaultDelay, FaultDrop, FaultDuplicate, FaultReorder,
+		FaultCorrupt, FaultTemporary,
+	}
+
+	faulty := func(seed int64) Option {
+		return WithChannel(func(ch Channel) Channel {
+			fch := NewFaultChannel(ch, afterVersion{RandomFaults(seed, 0.1, faults...)})
+			fch.Delay = time.Millisecond
+			return fch
+		})
+	}
+
+	// truncation ends the connection, so it is rare.
+	truncating := func(conn net.Conn, seed int64) net.Conn {
+		return NewFaultConn(conn, afterVersion{RandomFaults(seed, 0.01, FaultTruncate)})
+	}
+
+	for seed := int64(0); seed < 8; seed++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		cconn, sconn := net.Pipe()
+		defer cconn.Close()
+		go ServeConn(ctx, truncating(sconn, seed), Dispatch(&rwSession{}), faulty(seed))
+
+		session, err := NewSession(ctx, truncating(cconn, -seed), faulty(-seed))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 32; i++ {
+			rctx, rcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			session.Read(rctx, 1, make([]byte, 64), 0)
+			rcancel()
+		}
+
+		if ctx.Err() != nil {
+			t.Fatalf("seed %v: requests hung", seed)
+		}
+	}
+}
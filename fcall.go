@@ -2,104 +2,16 @@ package p9p
 
 import "fmt"
 
-// FcallType encodes the message type for the target Fcall.
-type FcallType uint8
+//go:generate go run ./cmd/p9pgen -spec messages.spec -out .
 
-// Definitions for Fcall's used in 9P2000.
-const (
-	Tversion FcallType = iota + 100
-	Rversion
-	Tauth
-	Rauth
-	Tattach
-	Rattach
-	Terror
-	Rerror
-	Tflush
-	Rflush
-	Twalk
-	Rwalk
-	Topen
-	Ropen
-	Tcreate
-	Rcreate
-	Tread
-	Rread
-	Twrite
-	Rwrite
-	Tclunk
-	Rclunk
-	Tremove
-	Rremove
-	Tstat
-	Rstat
-	Twstat
-	Rwstat
-	Tmax
-)
+// FcallType encodes the message type for the target Fcall. The types and
+// their messages are generated from messages.spec.
+type FcallType uint8
 
-func (fct FcallType) String() string {
-	switch fct {
-	case Tversion:
-		return "Tversion"
-	case Rversion:
-		return "Rversion"
-	case Tauth:
-		return "Tauth"
-	case Rauth:
-		return "Rauth"
-	case Tattach:
-		return "Tattach"
-	case Rattach:
-		return "Rattach"
-	case Terror:
-		// invalid.
-		return "Terror"
-	case Rerror:
-		return "Rerror"
-	case Tflush:
-		return "Tflush"
-	case Rflush:
-		return "Rflush"
-	case Twalk:
-		return "Twalk"
-	case Rwalk:
-		return "Rwalk"
-	case Topen:
-		return "Topen"
-	case Ropen:
-		return "Ropen"
-	case Tcreate:
-		return "Tcreate"
-	case Rcreate:
-		return "Rcreate"
-	case Tread:
-		return "Tread"
-	case Rread:
-		return "Rread"
-	case Twrite:
-		return "Twrite"
-	case Rwrite:
-		return "Rwrite"
-	case Tclunk:
-		return "Tclunk"
-	case Rclunk:
-		return "Rclunk"
-	case Tremove:
-		return "Tremove"
-	case Rremove:
-		return "Rremove"
-	case Tstat:
-		return "Tstat"
-	case Rstat:
-		return "Rstat"
-	case Twstat:
-		return "Twstat"
-	case Rwstat:
-		return "Rwstat"
-	default:
-		return "Tunknown"
-	}
+// Message represents the target of an fcall.
+type Message interface {
+	// Type returns the type of call for the target message.
+	Type() FcallType
 }
 
 // Fcall defines the fields for sending a 9p formatted message. The type will
@@ -126,17 +38,31 @@ func newErrorFcall(tag Tag, err error) *Fcall {
 		msg = v
 	case *MessageRerror:
 		msg = *v
+	case MessageRlerror:
+		msg = v
 	default:
-		msg = MessageRerror{Ename: v.Error()}
+		msg = rerror(v)
 	}
 
 	return &Fcall{
-		Type:    Rerror,
+		Type:    msg.Type(),
 		Tag:     tag,
 		Message: msg,
 	}
 }
 
+// newVersionErrorFcall returns the error fcall for err in version, which
+// carries the errno in 9P2000.u.
+func newVersionErrorFcall(version string, tag Tag, err error) *Fcall {
+	fcall := newErrorFcall(tag, err)
+	if rerr, ok := fcall.Message.(MessageRerror); ok && version == UnixVersion && rerr.Errno == 0 {
+		rerr.Errno = uint32(errnoOf(err, rerr))
+		fcall.Message = rerr
+	}
+
+	return fcall
+}
+
 func (fc *Fcall) String() string {
 	return fmt.Sprintf("%v(%v) %v", fc.Type, fc.Tag, string9p(fc.Message))
 }
@@ -0,0 +1,182 @@
+package p9p
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fuzzSeeds returns encoded messages to seed the fuzz targets with.
+func fuzzSeeds(f *testing.F) [][]byte {
+	var seeds [][]byte
+	for _, testcase := range encodingTestcases {
+		seeds = append(seeds, testcase.marshaled)
+	}
+
+	for _, fcall := range edgeFcalls {
+		seeds = append(seeds, reflectMarshal(f, fcall))
+	}
+
+	return seeds
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	codec := NewCodec()
+	f.Fuzz(func(t *testing.T, p []byte) {
+		var fcall Fcall
+		if err := codec.Unmarshal(p, &fcall); err != nil {
+			return
+		}
+
+		// anything decoded must encode.
+		if _, err := codec.Marshal(&fcall); err != nil {
+			t.Fatalf("error marshaling decoded %v: %v", &fcall, err)
+		}
+	})
+}
+
+func FuzzDecodeDir(f *testing.F) {
+	for _, testcase := range encodingTestcases {
+		if _, ok := testcase.target.(*Dir); ok {
+			f.Add(testcase.marshaled)
+		}
+	}
+
+	codec := NewCodec()
+	f.Fuzz(func(t *testing.T, p []byte) {
+		rd := bytes.NewReader(p)
+		for {
+			var d Dir
+			if err := DecodeDir(codec, rd, &d); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// FuzzReadframe reads frames from arbitrary input, as the channel reads
+// them from the connection.
+func FuzzReadframe(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		var b bytes.Buffer
+		if err := sendmsg(&b, seed); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b.Bytes())
+	}
+
+	const msize = 256
+	f.Fuzz(func(t *testing.T, p []byte) {
+		rd := bufio.NewReaderSize(bytes.NewReader(p), msize)
+		for {
+			frame, err := readframe(rd, msize)
+			if err != nil {
+				if Overflow(err) > 0 {
+					continue // the frame was consumed
+				}
+				return
+			}
+
+			if len(frame)+channelMessageHeaderSize > msize {
+				t.Fatalf("frame of %v bytes beyond msize", len(frame))
+			}
+		}
+	})
+}
+
+// FuzzServeConn feeds a server arbitrary frames after negotiating the
+// version, which must be answered or end the connection.
+func FuzzServeConn(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	codec := NewCodec()
+	f.Fuzz(func(t *testing.T, p []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cconn, sconn := net.Pipe()
+		done := make(chan error, 1)
+		go func() {
+			done <- ServeConn(ctx, sconn, Dispatch(&fuzzSession{}))
+		}()
+
+		go io.Copy(io.Discard, cconn)
+
+		version, err := codec.Marshal(newFcall(NOTAG, MessageTversion{
+			MSize:   DefaultMSize,
+			Version: DefaultVersion,
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b bytes.Buffer
+		for _, frame := range [][]byte{version, p} {
+			if err := sendmsg(&b, frame); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the raw input follows as is, possibly a hostile frame.
+		b.Write(p)
+
+		cconn.Write(b.Bytes())
+		cconn.Close()
+		<-done
+	})
+}
+
+// fuzzSession implements Session with successful calls, so that fuzzed
+// requests reach deep into the server.
+type fuzzSession struct{}
+
+func (fuzzSession) Auth(ctx context.Context, afid Fid, uname, aname string) (Qid, error) {
+	return Qid{Type: QTAUTH}, nil
+}
+
+func (fuzzSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	return Qid{Type: QTDIR}, nil
+}
+
+func (fuzzSession) Clunk(ctx context.Context, fid Fid) error  { return nil }
+func (fuzzSession) Remove(ctx context.Context, fid Fid) error { return nil }
+
+func (fuzzSession) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
+	return make([]Qid, len(names)), nil
+}
+
+func (fuzzSession) Read(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	return len(p), nil
+}
+
+func (fuzzSession) Write(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	return len(p), nil
+}
+
+func (fuzzSession) Open(ctx context.Context, fid Fid, mode Flag) (Qid, uint32, error) {
+	return Qid{}, 0, nil
+}
+
+func (fuzzSession) Create(ctx context.Context, parent Fid, name string, perm uint32, mode Flag) (Qid, uint32, error) {
+	return Qid{}, 0, nil
+}
+
+func (fuzzSession) Stat(ctx context.Context, fid Fid) (Dir, error) {
+	return Dir{Name: "file"}, nil
+}
+
+func (fuzzSession) WStat(ctx context.Context, fid Fid, dir Dir) error { return nil }
+
+func (fuzzSession) Version() (int, string) {
+	return DefaultMSize, DefaultVersion
+}
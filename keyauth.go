@@ -0,0 +1,184 @@
+package p9p
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The key authentication mechanism proves that the client knows a key shared
+// with the server for the user, without needing an auth server. The
+// conversation over the afid is:
+//
+//	client reads  nonce[32]
+//	client writes HMAC-SHA256(key, nonce || uname || 0 || aname)
+//
+// The write fails if the response does not verify.
+
+const keyAuthNonceSize = 32
+
+// KeyAuthMethod is the method recorded in the AuthInfo of users verified by
+// KeyAuth.
+const KeyAuthMethod = "hmac-sha256"
+
+// Keyring provides the shared keys for users.
+type Keyring interface {
+	// Key returns the key for uname, and false if the user has none.
+	Key(uname string) ([]byte, bool)
+}
+
+// StaticKeyring is a Keyring mapping user names to keys.
+type StaticKeyring map[string][]byte
+
+// Key implements Keyring.
+func (k StaticKeyring) Key(uname string) ([]byte, bool) {
+	key, ok := k[uname]
+	return key, ok
+}
+
+// ParseKeyring reads keys from rd. Each line holds a user name followed by
+// their key in hex. Blank lines and lines starting with "#" are ignored:
+//
+//	glenda  6f1e0a...
+func ParseKeyring(rd io.Reader) (StaticKeyring, error) {
+	var (
+		keys    = StaticKeyring{}
+		scanner = bufio.NewScanner(rd)
+		lineno  int
+	)
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("p9p: keyring line %d: expected user and key", lineno)
+		}
+
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("p9p: keyring line %d: invalid key for %q", lineno, fields[0])
+		}
+
+		keys[fields[0]] = key
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// LoadKeyring parses the keyring in the file at filename. See ParseKeyring
+// for the format. The file should only be readable by the server.
+func LoadKeyring(filename string) (StaticKeyring, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseKeyring(f)
+}
+
+// KeyAuth is an Authenticator verifying that clients know the key for the
+// user from Keyring. Use KeyAuthClient on the client side.
+type KeyAuth struct {
+	Keyring Keyring
+}
+
+var _ Authenticator = KeyAuth{}
+
+// Start implements Authenticator.
+func (ka KeyAuth) Start(ctx context.Context, uname, aname string) (AuthConversation, error) {
+	conv := &keyAuthConversation{
+		nonce: make([]byte, keyAuthNonceSize),
+		uname: uname,
+		aname: aname,
+	}
+
+	if _, err := rand.Read(conv.nonce); err != nil {
+		return nil, err
+	}
+
+	// Unknown users still get a challenge, so they can't be told apart
+	// from failed responses.
+	conv.key, conv.known = ka.Keyring.Key(uname)
+
+	return conv, nil
+}
+
+type keyAuthConversation struct {
+	nonce        []byte
+	key          []byte
+	known        bool
+	uname, aname string
+	sent         int // bytes of the nonce read by the client
+	verified     bool
+}
+
+func (c *keyAuthConversation) Read(ctx context.Context, p []byte) (int, error) {
+	n := copy(p, c.nonce[c.sent:])
+	c.sent += n
+	return n, nil
+}
+
+func (c *keyAuthConversation) Write(ctx context.Context, p []byte) (int, error) {
+	if c.sent < len(c.nonce) || c.verified {
+		return 0, ErrBotch
+	}
+
+	if !c.known || !hmac.Equal(p, keyAuthResponse(c.key, c.nonce, c.uname, c.aname)) {
+		return 0, ErrAuthFailed
+	}
+
+	c.verified = true
+	return len(p), nil
+}
+
+func (c *keyAuthConversation) Identity() (AuthInfo, error) {
+	if !c.verified {
+		return AuthInfo{}, ErrAuthFailed
+	}
+
+	return AuthInfo{Uname: c.uname, Method: KeyAuthMethod}, nil
+}
+
+// KeyAuthClient is the AuthClient for KeyAuth, holding the key of the user.
+type KeyAuthClient struct {
+	Key []byte
+}
+
+var _ AuthClient = KeyAuthClient{}
+
+// Authenticate implements AuthClient.
+func (kc KeyAuthClient) Authenticate(ctx context.Context, rw io.ReadWriter, uname, aname string) error {
+	nonce := make([]byte, keyAuthNonceSize)
+	if _, err := io.ReadFull(rw, nonce); err != nil {
+		return err
+	}
+
+	_, err := rw.Write(keyAuthResponse(kc.Key, nonce, uname, aname))
+	return err
+}
+
+func keyAuthResponse(key, nonce []byte, uname, aname string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(nonce)
+	mac.Write([]byte(uname))
+	mac.Write([]byte{0})
+	mac.Write([]byte(aname))
+	return mac.Sum(nil)
+}
@@ -1,77 +1,456 @@
-// +build ignore
-
 package p9p
 
 import (
-	"log"
-	"os"
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
 )
 
-type logging struct {
-	session Session
-	logger  log.Logger
+// logDataLimit is the number of bytes of read and write payloads included in
+// log entries, unless redacted.
+const logDataLimit = 64
+
+// LogOption configures the logging middleware returned by NewLogHandler and
+// NewLogSession.
+type LogOption func(*logConfig)
+
+type logConfig struct {
+	level  slog.Level
+	redact bool
+	sample uint64
+}
+
+// LogLevel sets the level of log entries. The default is slog.LevelInfo.
+func LogLevel(level slog.Level) LogOption {
+	return func(c *logConfig) {
+		c.level = level
+	}
+}
+
+// LogRedact omits data payloads and names from user supplied strings that
+// may be sensitive, such as unames and walk elements, logging only their
+// sizes. Errors are logged as their canonical 9p error or errno, since their
+// text may carry the paths of the server.
+func LogRedact() LogOption {
+	return func(c *logConfig) {
+		c.redact = true
+	}
+}
+
+// LogSampleIO logs only one in every n successful Tread and Twrite requests.
+// Failed requests are always logged.
+func LogSampleIO(n int) LogOption {
+	return func(c *logConfig) {
+		if n > 0 {
+			c.sample = uint64(n)
+		}
+	}
+}
+
+// messageLogger writes log entries for request and response pairs.
+type messageLogger struct {
+	logger *slog.Logger
+	config logConfig
+	io     atomic.Uint64 // count of io requests, for sampling
 }
 
-var _ Session = &logging{}
+func newMessageLogger(logger *slog.Logger, opts []LogOption) *messageLogger {
+	ml := &messageLogger{
+		logger: logger,
+		config: logConfig{level: slog.LevelInfo, sample: 1},
+	}
+
+	for _, opt := range opts {
+		opt(&ml.config)
+	}
+
+	return ml
+}
+
+// log writes an entry for req, which took the time since start and resulted
+// in resp or err.
+func (ml *messageLogger) log(ctx context.Context, start time.Time, req, resp Message, err error) {
+	if err == nil && ml.config.sample > 1 {
+		switch req.(type) {
+		case MessageTread, MessageTwrite:
+			if (ml.io.Add(1)-1)%ml.config.sample != 0 {
+				return
+			}
+		}
+	}
+
+	if !ml.logger.Enabled(ctx, ml.config.level) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 8)
+	if tag, ok := GetTag(ctx); ok {
+		attrs = append(attrs, slog.Any("tag", tag))
+	}
+
+	if fid, ok := messageFid(req); ok {
+		attrs = append(attrs, slog.Any("fid", fid))
+	}
+
+	attrs = ml.appendAttrs(attrs, req)
+	if err != nil {
+		attrs = append(attrs, ml.errorAttr(err))
+	} else if resp != nil {
+		attrs = ml.appendAttrs(attrs, resp)
+	}
+
+	attrs = append(attrs, slog.Duration("duration", time.Since(start)))
+
+	ml.logger.LogAttrs(ctx, ml.config.level, req.Type().String(), attrs...)
+}
+
+// messageFid returns the fid that msg operates on, if any.
+func messageFid(msg Message) (Fid, bool) {
+	switch msg := msg.(type) {
+	case MessageTattach:
+		return msg.Fid, true
+	case MessageTwalk:
+		return msg.Fid, true
+	case MessageTopen:
+		return msg.Fid, true
+	case MessageTcreate:
+		return msg.Fid, true
+	case MessageTread:
+		return msg.Fid, true
+	case MessageTwrite:
+		return msg.Fid, true
+	case MessageTclunk:
+		return msg.Fid, true
+	case MessageTremove:
+		return msg.Fid, true
+	case MessageTstat:
+		return msg.Fid, true
+	case MessageTwstat:
+		return msg.Fid, true
+	case MessageTstatfs:
+		return msg.Fid, true
+	case MessageTgetattr:
+		return msg.Fid, true
+	case MessageTxattrwalk:
+		return msg.Fid, true
+	case MessageTxattrcreate:
+		return msg.Fid, true
+	case MessageTfsync:
+		return msg.Fid, true
+	case MessageTlock:
+		return msg.Fid, true
+	case MessageTgetlock:
+		return msg.Fid, true
+	}
+
+	return 0, false
+}
+
+// appendAttrs adds the interesting fields of msg to attrs.
+func (ml *messageLogger) appendAttrs(attrs []slog.Attr, msg Message) []slog.Attr {
+	switch msg := msg.(type) {
+	case MessageTversion:
+		attrs = append(attrs, slog.Any("msize", msg.MSize), slog.String("version", msg.Version))
+	case MessageRversion:
+		attrs = append(attrs, slog.Any("msize", msg.MSize), slog.String("version", msg.Version))
+	case MessageTauth:
+		attrs = append(attrs, slog.Any("afid", msg.Afid), ml.name("uname", msg.Uname), ml.name("aname", msg.Aname))
+	case MessageRauth:
+		attrs = append(attrs, slog.Any("qid", msg.Qid))
+	case MessageTflush:
+		attrs = append(attrs, slog.Any("oldtag", msg.Oldtag))
+	case MessageTattach:
+		attrs = append(attrs, slog.Any("afid", msg.Afid), ml.name("uname", msg.Uname), ml.name("aname", msg.Aname))
+	case MessageRattach:
+		attrs = append(attrs, slog.Any("qid", msg.Qid))
+	case MessageTwalk:
+		attrs = append(attrs, slog.Any("newfid", msg.Newfid))
+		if ml.config.redact {
+			attrs = append(attrs, slog.Int("nwname", len(msg.Wnames)))
+		} else {
+			attrs = append(attrs, slog.Any("wnames", msg.Wnames))
+		}
+	case MessageRwalk:
+		attrs = append(attrs, slog.Int("nwqid", len(msg.Qids)))
+	case MessageTopen:
+		attrs = append(attrs, slog.Any("mode", msg.Mode))
+	case MessageRopen:
+		attrs = append(attrs, slog.Any("qid", msg.Qid), slog.Any("iounit", msg.IOUnit))
+	case MessageTcreate:
+		attrs = append(attrs, ml.name("name", msg.Name), slog.Any("perm", msg.Perm), slog.Any("mode", msg.Mode))
+	case MessageRcreate:
+		attrs = append(attrs, slog.Any("qid", msg.Qid), slog.Any("iounit", msg.IOUnit))
+	case MessageTread:
+		attrs = append(attrs, slog.Any("offset", msg.Offset), slog.Any("count", msg.Count))
+	case MessageRread:
+		attrs = ml.data(attrs, "rcount", msg.Data)
+	case MessageTwrite:
+		attrs = append(attrs, slog.Any("offset", msg.Offset))
+		attrs = ml.data(attrs, "count", msg.Data)
+	case MessageRwrite:
+		attrs = append(attrs, slog.Any("rcount", msg.Count))
+	case MessageRstat:
+		attrs = append(attrs, ml.name("name", msg.Stat.Name), slog.Any("qid", msg.Stat.Qid), slog.Any("length", msg.Stat.Length))
+	case MessageTwstat:
+		attrs = append(attrs, ml.name("name", msg.Stat.Name), slog.Any("mode", msg.Stat.Mode), slog.Any("length", msg.Stat.Length))
+	case MessageTxattrwalk:
+		attrs = append(attrs, slog.Any("newfid", msg.Newfid), ml.name("name", msg.Name))
+	case MessageRxattrwalk:
+		attrs = append(attrs, slog.Any("size", msg.Size))
+	case MessageTxattrcreate:
+		attrs = append(attrs, ml.name("name", msg.Name), slog.Any("size", msg.Size), slog.Any("flags", msg.Flags))
+	case MessageTfsync:
+		attrs = append(attrs, slog.Any("datasync", msg.Datasync))
+	case MessageTlock:
+		attrs = append(attrs, slog.Any("locktype", msg.LockType), slog.Any("start", msg.Start), slog.Any("length", msg.Length))
+	case MessageRlock:
+		attrs = append(attrs, slog.Any("status", msg.Status))
+	case MessageTgetlock:
+		attrs = append(attrs, slog.Any("locktype", msg.LockType), slog.Any("start", msg.Start), slog.Any("length", msg.Length))
+	case MessageRgetlock:
+		attrs = append(attrs, slog.Any("rlocktype", msg.LockType))
+	}
+
+	return attrs
+}
+
+// name returns an attribute for a user supplied string, honoring redaction.
+func (ml *messageLogger) name(key, value string) slog.Attr {
+	if ml.config.redact && value != "" {
+		return slog.String(key, "<redacted>")
+	}
 
-func NewLogger(prefix string, session Session) Session {
-	return &logging{
+	return slog.String(key, value)
+}
+
+// errorAttr returns an attribute for err, honoring redaction.
+func (ml *messageLogger) errorAttr(err error) slog.Attr {
+	if !ml.config.redact {
+		return slog.String("error", err.Error())
+	}
+
+	switch msg := newErrorFcall(NOTAG, err).Message.(type) {
+	case MessageRerror:
+		if canonicalEnames[msg.Ename] {
+			return slog.String("error", MessageRerror{Ename: msg.Ename}.Error())
+		}
+
+		if errno := errnoOf(err, msg); errno != 0 {
+			return slog.String("error", MessageRlerror{Ecode: uint32(errno)}.Error())
+		}
+	case MessageRlerror:
+		return slog.String("error", msg.Error())
+	}
+
+	return slog.String("error", "<redacted>")
+}
+
+// data adds the size of a payload, and a prefix of the payload unless
+// redacted.
+func (ml *messageLogger) data(attrs []slog.Attr, key string, p []byte) []slog.Attr {
+	attrs = append(attrs, slog.Int(key, len(p)))
+	if ml.config.redact {
+		return attrs
+	}
+
+	if len(p) > logDataLimit {
+		p = p[:logDataLimit]
+	}
+
+	return append(attrs, slog.String("data", string(p)))
+}
+
+// NewLogHandler returns a handler logging each request passed to handler,
+// along with its response, to logger. On the server side, entries include
+// the tag of the request.
+func NewLogHandler(logger *slog.Logger, handler Handler, opts ...LogOption) Handler {
+	ml := newMessageLogger(logger, opts)
+	return HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+		start := time.Now()
+		resp, err := handler.Handle(ctx, msg)
+		ml.log(ctx, start, msg, resp, err)
+		return resp, err
+	})
+}
+
+// NewLogSession returns a session logging each call to session to logger,
+// in the same form as NewLogHandler. It may wrap a client session or a
+// session served with Dispatch. If session implements SessionL, so does the
+// returned session.
+func NewLogSession(logger *slog.Logger, session Session, opts ...LogOption) Session {
+	ls := &logSession{
 		session: session,
-		logger:  *log.New(os.Stdout, prefix, 0),
+		ml:      newMessageLogger(logger, opts),
+	}
+
+	if sessionl, ok := session.(SessionL); ok {
+		return &logSessionL{logSession: ls, sessionl: sessionl}
 	}
+
+	return ls
+}
+
+type logSession struct {
+	session Session
+	ml      *messageLogger
 }
 
-func (l *logging) Auth(afid Fid, uname, aname string) (Qid, error) {
-	qid, err := l.session.Auth(afid, uname, aname)
-	l.logger.Printf("Auth(%v, %s, %s) -> (%v, %v)", afid, uname, aname, qid, err)
+var _ Session = &logSession{}
+
+func (l *logSession) Auth(ctx context.Context, afid Fid, uname, aname string) (Qid, error) {
+	start := time.Now()
+	qid, err := l.session.Auth(ctx, afid, uname, aname)
+	l.ml.log(ctx, start, MessageTauth{Afid: afid, Uname: uname, Aname: aname}, MessageRauth{Qid: qid}, err)
 	return qid, err
 }
 
-func (l *logging) Attach(fid, afid Fid, uname, aname string) (Qid, error) {
-	qid, err := l.session.Attach(fid, afid, uname, aname)
-	l.logger.Printf("Attach(%v, %v, %s, %s) -> (%v, %v)", fid, afid, uname, aname, qid, err)
+func (l *logSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	start := time.Now()
+	qid, err := l.session.Attach(ctx, fid, afid, uname, aname)
+	l.ml.log(ctx, start, MessageTattach{Fid: fid, Afid: afid, Uname: uname, Aname: aname}, MessageRattach{Qid: qid}, err)
 	return qid, err
 }
 
-func (l *logging) Clunk(fid Fid) error {
-	return l.session.Clunk(fid)
+func (l *logSession) Clunk(ctx context.Context, fid Fid) error {
+	start := time.Now()
+	err := l.session.Clunk(ctx, fid)
+	l.ml.log(ctx, start, MessageTclunk{Fid: fid}, MessageRclunk{}, err)
+	return err
+}
+
+func (l *logSession) Remove(ctx context.Context, fid Fid) error {
+	start := time.Now()
+	err := l.session.Remove(ctx, fid)
+	l.ml.log(ctx, start, MessageTremove{Fid: fid}, MessageRremove{}, err)
+	return err
+}
+
+func (l *logSession) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
+	start := time.Now()
+	qids, err := l.session.Walk(ctx, fid, newfid, names...)
+	l.ml.log(ctx, start, MessageTwalk{Fid: fid, Newfid: newfid, Wnames: names}, MessageRwalk{Qids: qids}, err)
+	return qids, err
+}
+
+func (l *logSession) Read(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	start := time.Now()
+	n, err := l.session.Read(ctx, fid, p, offset)
+	l.ml.log(ctx, start, MessageTread{Fid: fid, Offset: uint64(offset), Count: uint32(len(p))}, MessageRread{Data: p[:n]}, err)
+	return n, err
+}
+
+func (l *logSession) Write(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	start := time.Now()
+	n, err := l.session.Write(ctx, fid, p, offset)
+	l.ml.log(ctx, start, MessageTwrite{Fid: fid, Offset: uint64(offset), Data: p}, MessageRwrite{Count: uint32(n)}, err)
+	return n, err
 }
 
-func (l *logging) Remove(fid Fid) (err error) {
-	defer func() {
-		l.logger.Printf("Remove(%v) -> %v", fid, err)
-	}()
-	return l.session.Remove(fid)
+func (l *logSession) Open(ctx context.Context, fid Fid, mode Flag) (Qid, uint32, error) {
+	start := time.Now()
+	qid, iounit, err := l.session.Open(ctx, fid, mode)
+	l.ml.log(ctx, start, MessageTopen{Fid: fid, Mode: mode}, MessageRopen{Qid: qid, IOUnit: iounit}, err)
+	return qid, iounit, err
 }
 
-func (l *logging) Walk(fid Fid, newfid Fid, names ...string) ([]Qid, error) {
-	return l.session.Walk(fid, newfid, names...)
+func (l *logSession) Create(ctx context.Context, parent Fid, name string, perm uint32, mode Flag) (Qid, uint32, error) {
+	start := time.Now()
+	qid, iounit, err := l.session.Create(ctx, parent, name, perm, mode)
+	l.ml.log(ctx, start, MessageTcreate{Fid: parent, Name: name, Perm: perm, Mode: mode}, MessageRcreate{Qid: qid, IOUnit: iounit}, err)
+	return qid, iounit, err
 }
 
-func (l *logging) Read(fid Fid, p []byte, offset int64) (n int, err error) {
-	return l.session.Read(fid, p, offset)
+func (l *logSession) Stat(ctx context.Context, fid Fid) (Dir, error) {
+	start := time.Now()
+	dir, err := l.session.Stat(ctx, fid)
+	l.ml.log(ctx, start, MessageTstat{Fid: fid}, MessageRstat{Stat: dir}, err)
+	return dir, err
 }
 
-func (l *logging) Write(fid Fid, p []byte, offset int64) (n int, err error) {
-	return l.session.Write(fid, p, offset)
+func (l *logSession) WStat(ctx context.Context, fid Fid, dir Dir) error {
+	start := time.Now()
+	err := l.session.WStat(ctx, fid, dir)
+	l.ml.log(ctx, start, MessageTwstat{Fid: fid, Stat: dir}, MessageRwstat{}, err)
+	return err
 }
 
-func (l *logging) Open(fid Fid, mode int32) (Qid, error) {
-	return l.session.Open(fid, mode)
+func (l *logSession) Version() (int, string) {
+	return l.session.Version()
 }
 
-func (l *logging) Create(parent Fid, name string, perm uint32, mode uint32) (Qid, error) {
-	return l.session.Create(parent, name, perm, mode)
+// logSessionL logs the 9P2000.L extension calls of a session that supports
+// them.
+type logSessionL struct {
+	*logSession
+	sessionl SessionL
 }
 
-func (l *logging) Stat(fid Fid) (Dir, error) {
-	return l.session.Stat(fid)
+func (l *logSessionL) Statfs(ctx context.Context, fid Fid) (StatFS, error) {
+	start := time.Now()
+	st, err := l.sessionl.Statfs(ctx, fid)
+	l.ml.log(ctx, start, MessageTstatfs{Fid: fid}, MessageRstatfs(st), err)
+	return st, err
+}
+
+func (l *logSessionL) Getattr(ctx context.Context, fid Fid, mask uint64) (Attr, error) {
+	start := time.Now()
+	attr, err := l.sessionl.Getattr(ctx, fid, mask)
+	l.ml.log(ctx, start, MessageTgetattr{Fid: fid, RequestMask: mask}, MessageRgetattr(attr), err)
+	return attr, err
+}
+
+func (l *logSessionL) XattrWalk(ctx context.Context, fid, newfid Fid, name string) (uint64, error) {
+	start := time.Now()
+	size, err := l.sessionl.XattrWalk(ctx, fid, newfid, name)
+	l.ml.log(ctx, start, MessageTxattrwalk{Fid: fid, Newfid: newfid, Name: name}, MessageRxattrwalk{Size: size}, err)
+	return size, err
+}
+
+func (l *logSessionL) XattrCreate(ctx context.Context, fid Fid, name string, size uint64, flags uint32) error {
+	start := time.Now()
+	err := l.sessionl.XattrCreate(ctx, fid, name, size, flags)
+	l.ml.log(ctx, start, MessageTxattrcreate{Fid: fid, Name: name, Size: size, Flags: flags}, MessageRxattrcreate{}, err)
+	return err
+}
+
+func (l *logSessionL) Fsync(ctx context.Context, fid Fid, datasync bool) error {
+	start := time.Now()
+	err := l.sessionl.Fsync(ctx, fid, datasync)
+
+	var ds uint32
+	if datasync {
+		ds = 1
+	}
+	l.ml.log(ctx, start, MessageTfsync{Fid: fid, Datasync: ds}, MessageRfsync{}, err)
+	return err
 }
 
-func (l *logging) WStat(fid Fid, dir Dir) error {
-	return l.session.WStat(fid, dir)
+func (l *logSessionL) Lock(ctx context.Context, fid Fid, lock Lock) (uint8, error) {
+	start := time.Now()
+	status, err := l.sessionl.Lock(ctx, fid, lock)
+	l.ml.log(ctx, start, MessageTlock{
+		Fid:      fid,
+		LockType: lock.Type,
+		Flags:    lock.Flags,
+		Start:    lock.Start,
+		Length:   lock.Length,
+		ProcID:   lock.ProcID,
+		ClientID: lock.ClientID,
+	}, MessageRlock{Status: status}, err)
+	return status, err
 }
 
-func (l *logging) Version(msize int32, version string) (int32, string, error) {
-	return l.session.Version(msize, version)
+func (l *logSessionL) GetLock(ctx context.Context, fid Fid, lock Lock) (Lock, error) {
+	start := time.Now()
+	conflict, err := l.sessionl.GetLock(ctx, fid, lock)
+	l.ml.log(ctx, start, MessageTgetlock{
+		Fid:      fid,
+		LockType: lock.Type,
+		Start:    lock.Start,
+		Length:   lock.Length,
+		ProcID:   lock.ProcID,
+		ClientID: lock.ClientID,
+	}, MessageRgetlock{LockType: conflict.Type}, err)
+	return conflict, err
 }
@@ -0,0 +1,173 @@
+package p9p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// rwSession allows any attach and serves reads and writes on any fid.
+type rwSession struct {
+	Session
+}
+
+func (s *rwSession) Read(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	return copy(p, "secret data"), nil
+}
+
+func (s *rwSession) Write(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	return len(p), nil
+}
+
+func (s *rwSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	return Qid{Type: QTDIR}, nil
+}
+
+func (s *rwSession) Clunk(ctx context.Context, fid Fid) error {
+	return ErrUnknownfid
+}
+
+func decodeLog(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestLogHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	)
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(ctx, sconn, NewLogHandler(logger, Dispatch(&rwSession{})))
+	}()
+
+	session, err := NewSession(ctx, cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Read(ctx, 3, make([]byte, 16), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Clunk(ctx, 3); !errors.Is(err, ErrUnknownfid) {
+		t.Fatalf("expected %v, got %v", ErrUnknownfid, err)
+	}
+
+	cconn.Close()
+	cancel()
+	<-done
+
+	entries := decodeLog(t, &buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %v", entries)
+	}
+
+	read := entries[0]
+	if read["msg"] != "Tread" || read["fid"] != float64(3) || read["data"] != "secret data" || read["tag"] == nil || read["duration"] == nil {
+		t.Fatalf("unexpected read entry: %v", read)
+	}
+
+	clunk := entries[1]
+	if clunk["msg"] != "Tclunk" || clunk["error"] != ErrUnknownfid.Error() {
+		t.Fatalf("unexpected clunk entry: %v", clunk)
+	}
+}
+
+func TestLogSession(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		buf    bytes.Buffer
+		logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	)
+
+	session := NewLogSession(logger, &rwSession{}, LogRedact(), LogSampleIO(3))
+	for i := 0; i < 6; i++ {
+		if _, err := session.Write(ctx, 1, []byte("secret data"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := session.Attach(ctx, 1, NOFID, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "secret") || strings.Contains(buf.String(), "glenda") {
+		t.Fatalf("redacted values in log: %v", buf.String())
+	}
+
+	entries := decodeLog(t, &buf)
+	if len(entries) != 3 {
+		t.Fatalf("expected 2 sampled writes and an attach, got %v", entries)
+	}
+
+	if entries[0]["msg"] != "Twrite" || entries[0]["count"] != float64(len("secret data")) {
+		t.Fatalf("unexpected write entry: %v", entries[0])
+	}
+}
+
+// TestLogRedactXattr ensures that the names of extended attributes are
+// redacted like other user supplied names.
+func TestLogRedactXattr(t *testing.T) {
+	ml := newMessageLogger(slog.Default(), []LogOption{LogRedact()})
+	for _, msg := range []Message{
+		MessageTxattrwalk{Fid: 1, Newfid: 2, Name: "user.secret"},
+		MessageTxattrcreate{Fid: 1, Name: "user.secret", Size: 8},
+	} {
+		for _, attr := range ml.appendAttrs(nil, msg) {
+			if attr.Key == "name" && attr.Value.String() != "<redacted>" {
+				t.Fatalf("%v: name not redacted: %v", msg.Type(), attr)
+			}
+		}
+	}
+}
+
+// TestLogRedactError ensures that errors are logged without the paths of the
+// server when redacting.
+func TestLogRedactError(t *testing.T) {
+	ml := newMessageLogger(slog.Default(), []LogOption{LogRedact()})
+	for _, testcase := range []struct {
+		err      error
+		expected string
+	}{
+		{ErrUnknownfid, ErrUnknownfid.Error()},
+		{&fs.PathError{Op: "open", Path: "/srv/secret", Err: syscall.ENOENT}, ErrNotfound.Error()},
+		{&fs.PathError{Op: "rmdir", Path: "/srv/secret", Err: syscall.ENOTEMPTY}, MessageRlerror{Ecode: uint32(syscall.ENOTEMPTY)}.Error()},
+		{MessageRlerror{Ecode: uint32(syscall.EIO)}, MessageRlerror{Ecode: uint32(syscall.EIO)}.Error()},
+		{errors.New("open /srv/secret: failed"), "<redacted>"},
+	} {
+		if attr := ml.errorAttr(testcase.err); attr.Value.String() != testcase.expected {
+			t.Fatalf("%v: expected %q, got %q", testcase.err, testcase.expected, attr.Value)
+		}
+	}
+}
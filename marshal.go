@@ -0,0 +1,359 @@
+package p9p
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// BufferCodec is implemented by codecs that can marshal into a buffer
+// provided by the caller, avoiding an allocation for each message. The codec
+// returned by NewCodec implements BufferCodec.
+type BufferCodec interface {
+	Codec
+
+	// MarshalTo marshals v into p, returning the number of bytes written.
+	// If p is too small, io.ErrShortBuffer is returned.
+	MarshalTo(p []byte, v interface{}) (int, error)
+}
+
+// Messages are marshaled by code generated from messages.spec, rather than
+// through the reflection of encoder and decoder. Their output is identical.
+// Data of Rread and Twrite aliases the buffer they are unmarshaled from,
+// which channels keep until the request is answered (see ReadFcall).
+
+// marshaler is implemented by messages with a generated encoding.
+type marshaler interface {
+	Message
+
+	// encodedSize returns the size of the encoded message body.
+	encodedSize() int
+
+	// marshal9p encodes the message into p, which is at least encodedSize
+	// bytes, returning the remainder.
+	marshal9p(p []byte) []byte
+}
+
+// marshalerDotu is implemented by messages encoded differently in 9P2000.u.
+type marshalerDotu interface {
+	encodedSizeDotu() int
+	marshal9pDotu(p []byte) []byte
+}
+
+// fcallHeaderSize is the size of the type and tag of an fcall.
+const fcallHeaderSize = 3
+
+// encodedSize returns the size of the encoded body of m in the dialect of c.
+func (c codec9p) encodedSize(m marshaler) int {
+	if mu, ok := m.(marshalerDotu); ok && c.dotu {
+		return mu.encodedSizeDotu()
+	}
+
+	return m.encodedSize()
+}
+
+// marshalFcall encodes fcall into p if its message has a generated encoding,
+// returning false otherwise.
+func (c codec9p) marshalFcall(p []byte, fcall *Fcall) (int, bool, error) {
+	m, ok := fcall.Message.(marshaler)
+	if !ok {
+		return 0, false, nil
+	}
+
+	n := fcallHeaderSize + c.encodedSize(m)
+	if len(p) < n {
+		return 0, true, io.ErrShortBuffer
+	}
+
+	p[0] = uint8(fcall.Type)
+	binary.LittleEndian.PutUint16(p[1:], uint16(fcall.Tag))
+	if mu, ok := m.(marshalerDotu); ok && c.dotu {
+		mu.marshal9pDotu(p[fcallHeaderSize:])
+	} else {
+		m.marshal9p(p[fcallHeaderSize:])
+	}
+	return n, true, nil
+}
+
+// unmarshalFcall decodes p into fcall if its type has a generated encoding,
+// returning false otherwise.
+func (c codec9p) unmarshalFcall(p []byte, fcall *Fcall) (bool, error) {
+	if len(p) < fcallHeaderSize {
+		return false, nil
+	}
+
+	var (
+		t   = FcallType(p[0])
+		tag = Tag(binary.LittleEndian.Uint16(p[1:]))
+		b   = rbuf{p: p[fcallHeaderSize:]}
+	)
+
+	unmarshal := unmarshalMessage
+	if c.dotu {
+		unmarshal = unmarshalMessageDotu
+	}
+
+	msg := unmarshal(t, &b)
+	if msg == nil {
+		return false, nil
+	}
+
+	if b.err != nil {
+		return true, b.err
+	}
+
+	if err := checkMessage(msg); err != nil {
+		return true, err
+	}
+
+	*fcall = Fcall{Type: t, Tag: tag, Message: msg}
+	return true, nil
+}
+
+// checkMessage enforces the limits of the protocol on a decoded message.
+func checkMessage(msg Message) error {
+	switch msg := msg.(type) {
+	case MessageTwalk:
+		if len(msg.Wnames) > MAXWELEM {
+			return ErrWalkLimit
+		}
+	case MessageRwalk:
+		if len(msg.Qids) > MAXWELEM {
+			return ErrWalkLimit
+		}
+	}
+
+	return nil
+}
+
+// rbuf decodes values from p, recording the first error.
+type rbuf struct {
+	p   []byte
+	err error
+}
+
+// next consumes n bytes, returning nil if they are not available.
+func (b *rbuf) next(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+
+	if len(b.p) < n {
+		b.err = io.ErrUnexpectedEOF
+		b.p = nil
+		return nil
+	}
+
+	v := b.p[:n:n]
+	b.p = b.p[n:]
+	return v
+}
+
+func (b *rbuf) uint8() uint8 {
+	if v := b.next(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (b *rbuf) uint16() uint16 {
+	if v := b.next(2); v != nil {
+		return binary.LittleEndian.Uint16(v)
+	}
+	return 0
+}
+
+func (b *rbuf) uint32() uint32 {
+	if v := b.next(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (b *rbuf) uint64() uint64 {
+	if v := b.next(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (b *rbuf) string() string {
+	return string(b.next(int(b.uint16())))
+}
+
+func (b *rbuf) strings() []string {
+	n := int(b.uint16())
+	if b.err != nil {
+		return nil
+	}
+
+	ss := make([]string, 0, min(n, len(b.p)/2))
+	for i := 0; i < n && b.err == nil; i++ {
+		ss = append(ss, b.string())
+	}
+	return ss
+}
+
+// data returns a count-prefixed byte slice, aliasing p. Like decoder, an
+// empty slice is nil.
+func (b *rbuf) data() []byte {
+	v := b.next(int(b.uint32()))
+	if len(v) == 0 {
+		return nil
+	}
+	return v
+}
+
+func (b *rbuf) qid() Qid {
+	return Qid{Type: QType(b.uint8()), Version: b.uint32(), Path: b.uint64()}
+}
+
+func (b *rbuf) dir() Dir {
+	return b.entry(nil)
+}
+
+// entry decodes a Dir from the size of the entry, ignoring any fields beyond
+// those of 9P2000 and, if set, those decoded by ext for a dialect.
+func (b *rbuf) entry(ext func(b *rbuf, d *Dir)) Dir {
+	d := rbuf{p: b.next(int(b.uint16()))}
+	if b.err != nil {
+		return Dir{}
+	}
+
+	dir := Dir{
+		Type:       d.uint16(),
+		Dev:        d.uint32(),
+		Qid:        d.qid(),
+		Mode:       d.uint32(),
+		AccessTime: d.time(),
+		ModTime:    d.time(),
+		Length:     d.uint64(),
+		Name:       d.string(),
+		UID:        d.string(),
+		GID:        d.string(),
+		MUID:       d.string(),
+	}
+	if ext != nil {
+		ext(&d, &dir)
+	}
+	b.err = d.err
+	return dir
+}
+
+func (b *rbuf) time() time.Time {
+	return time.Unix(int64(b.uint32()), 0).UTC()
+}
+
+func (b *rbuf) timespec() Timespec {
+	return Timespec{Sec: b.uint64(), Nsec: b.uint64()}
+}
+
+func (b *rbuf) qids() []Qid {
+	n := int(b.uint16())
+	if b.err != nil {
+		return nil
+	}
+
+	qids := make([]Qid, 0, min(n, len(b.p)/qidSize))
+	for i := 0; i < n && b.err == nil; i++ {
+		qids = append(qids, b.qid())
+	}
+	return qids
+}
+
+// Encoding helpers write a value to the start of p, returning the
+// remainder.
+
+func put8(p []byte, v uint8) []byte {
+	p[0] = v
+	return p[1:]
+}
+
+func put16(p []byte, v uint16) []byte {
+	binary.LittleEndian.PutUint16(p, v)
+	return p[2:]
+}
+
+func put32(p []byte, v uint32) []byte {
+	binary.LittleEndian.PutUint32(p, v)
+	return p[4:]
+}
+
+func put64(p []byte, v uint64) []byte {
+	binary.LittleEndian.PutUint64(p, v)
+	return p[8:]
+}
+
+func putstring(p []byte, s string) []byte {
+	p = put16(p, uint16(len(s)))
+	return p[copy(p, s):]
+}
+
+func putstrings(p []byte, ss []string) []byte {
+	p = put16(p, uint16(len(ss)))
+	for _, s := range ss {
+		p = putstring(p, s)
+	}
+	return p
+}
+
+func putdata(p []byte, data []byte) []byte {
+	p = put32(p, uint32(len(data)))
+	return p[copy(p, data):]
+}
+
+func putqid(p []byte, qid Qid) []byte {
+	p = put8(p, uint8(qid.Type))
+	p = put32(p, qid.Version)
+	return put64(p, qid.Path)
+}
+
+func putqids(p []byte, qids []Qid) []byte {
+	p = put16(p, uint16(len(qids)))
+	for _, qid := range qids {
+		p = putqid(p, qid)
+	}
+	return p
+}
+
+func putdir(p []byte, d Dir) []byte {
+	p = put16(p, uint16(sizedir(d)-2))
+	return putdirfields(p, d)
+}
+
+// putdirfields encodes the fields of d in 9P2000, without its size.
+func putdirfields(p []byte, d Dir) []byte {
+	p = put16(p, d.Type)
+	p = put32(p, d.Dev)
+	p = putqid(p, d.Qid)
+	p = put32(p, d.Mode)
+	p = put32(p, unixtime32(d.AccessTime))
+	p = put32(p, unixtime32(d.ModTime))
+	p = put64(p, d.Length)
+	p = putstring(p, d.Name)
+	p = putstring(p, d.UID)
+	p = putstring(p, d.GID)
+	return putstring(p, d.MUID)
+}
+
+func puttimespec(p []byte, ts Timespec) []byte {
+	p = put64(p, ts.Sec)
+	return put64(p, ts.Nsec)
+}
+
+// qidSize is the encoded size of a Qid.
+const qidSize = 13
+
+// sizedir returns the encoded size of d, including its size.
+func sizedir(d Dir) int {
+	return 2 + 2 + 4 + qidSize + 4 + 4 + 4 + 8 +
+		2 + len(d.Name) + 2 + len(d.UID) + 2 + len(d.GID) + 2 + len(d.MUID)
+}
+
+func sizestrings(ss []string) int {
+	n := 2
+	for _, s := range ss {
+		n += 2 + len(s)
+	}
+	return n
+}
@@ -0,0 +1,305 @@
+package p9p
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// edgeFcalls covers edge cases of the generated encodings, beyond those of
+// TestGeneratedMessages.
+var edgeFcalls = []*Fcall{
+	newErrorFcall(1, ErrUnknownfid),
+	newFcall(2, MessageTflush{Oldtag: 1}),
+	newFcall(2, MessageRflush{}),
+	newFcall(3, MessageTwalk{Fid: 1, Newfid: 2, Wnames: []string{"usr", "glenda", "lib"}}),
+	newFcall(3, MessageTwalk{Fid: 1, Newfid: 2, Wnames: []string{}}),
+	newFcall(3, MessageRwalk{Qids: []Qid{{Type: QTDIR, Version: 1, Path: 2}, {Path: 3}}}),
+	newFcall(3, MessageRwalk{Qids: []Qid{}}),
+	newFcall(4, MessageTopen{Fid: 2, Mode: ORDWR | OTRUNC}),
+	newFcall(4, MessageRopen{Qid: Qid{Version: 1, Path: 0x1020304050607080}, IOUnit: 8192}),
+	newFcall(5, MessageTread{Fid: 2, Offset: 1 << 40, Count: 8192}),
+	newFcall(5, MessageRread{Data: []byte("hello, 9p")}),
+	newFcall(5, MessageRread{}),
+	newFcall(6, MessageTwrite{Fid: 2, Offset: 10, Data: []byte("hello, 9p")}),
+	newFcall(6, MessageRwrite{Count: 9}),
+	newFcall(7, MessageTclunk{Fid: 2}),
+	newFcall(7, MessageRclunk{}),
+}
+
+// reflectMarshal marshals v with the reflection encoder.
+func reflectMarshal(t testing.TB, v interface{}) []byte {
+	var b bytes.Buffer
+	if err := (&encoder{&b}).encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestMarshalMatchesEncoder(t *testing.T) {
+	for _, fcall := range edgeFcalls {
+		t.Run(fcall.Type.String(), func(t *testing.T) {
+			testMarshal(t, fcall)
+		})
+	}
+}
+
+// testMarshal checks that the generated encoding of fcall matches the
+// reflection based encoder and decoder.
+func testMarshal(t *testing.T, fcall *Fcall) {
+	t.Helper()
+
+	codec := codec9p{}
+	expected := reflectMarshal(t, fcall)
+
+	p, err := codec.Marshal(fcall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(p, expected) {
+		t.Fatalf("unexpected marshal: %x != %x", p, expected)
+	}
+
+	if size := codec.Size(fcall); size != len(expected) || size != int(size9p(fcall)) {
+		t.Fatalf("unexpected size: %v != %v", size, len(expected))
+	}
+
+	buf := make([]byte, len(expected))
+	n, err := codec.MarshalTo(buf, fcall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], expected) {
+		t.Fatalf("unexpected marshal to: %x != %x", buf[:n], expected)
+	}
+
+	if _, err := codec.MarshalTo(buf[:len(buf)-1], fcall); err != io.ErrShortBuffer {
+		t.Fatalf("expected %v, got %v", io.ErrShortBuffer, err)
+	}
+
+	var decoded, reflected Fcall
+	if err := codec.Unmarshal(expected, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&decoder{bytes.NewReader(expected)}).decode(&reflected); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, reflected) || !reflect.DeepEqual(&decoded, fcall) {
+		t.Fatalf("unexpected unmarshal: %#v != %#v", decoded, reflected)
+	}
+
+	// truncated messages fail to decode.
+	if err := codec.Unmarshal(expected[:len(expected)-1], &decoded); err == nil && len(expected) > fcallHeaderSize {
+		t.Fatalf("expected error decoding truncated %v", fcall)
+	}
+}
+
+// testRoundTrip checks that fcall is marshaled by codec to the size it
+// reports and unmarshaled back unchanged.
+func testRoundTrip(t *testing.T, codec BufferCodec, fcall *Fcall) {
+	t.Helper()
+
+	p, err := codec.Marshal(fcall)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size := codec.Size(fcall); size != len(p) {
+		t.Fatalf("unexpected size: %v != %v", size, len(p))
+	}
+
+	buf := make([]byte, len(p))
+	if n, err := codec.MarshalTo(buf, fcall); err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(buf[:n], p) {
+		t.Fatalf("unexpected marshal to: %x != %x", buf[:n], p)
+	}
+
+	if _, err := codec.MarshalTo(buf[:len(buf)-1], fcall); err != io.ErrShortBuffer {
+		t.Fatalf("expected %v, got %v", io.ErrShortBuffer, err)
+	}
+
+	var decoded Fcall
+	if err := codec.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(&decoded, fcall) {
+		t.Fatalf("unexpected unmarshal: %#v != %#v", decoded, fcall)
+	}
+
+	if err := codec.Unmarshal(p[:len(p)-1], &decoded); err == nil {
+		t.Fatalf("expected error decoding truncated %v", fcall)
+	}
+}
+
+func TestUnixCodec(t *testing.T) {
+	var (
+		codec  = codec9p{}
+		codecu = codec9p{dotu: true}
+	)
+
+	// the fields of 9P2000.u are appended to those of 9P2000, which ignores
+	// them.
+	attach := newFcall(1, MessageTattach{Fid: 1, Afid: NOFID, Uname: "glenda", NUname: 1000})
+	p, err := codec.Marshal(attach)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pu, err := codecu.Marshal(attach)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(pu[:len(p)], p) || !bytes.Equal(pu[len(p):], []byte{0xe8, 0x03, 0, 0}) {
+		t.Fatalf("unexpected 9P2000.u encoding: %x, 9P2000 %x", pu, p)
+	}
+
+	if !bytes.Equal(reflectMarshal(t, attach), p) {
+		t.Fatalf("reflection encodes the fields of 9P2000.u")
+	}
+
+	// other messages are encoded alike.
+	for _, fcall := range edgeFcalls[1:] {
+		p, _ := codec.Marshal(fcall)
+		pu, _ := codecu.Marshal(fcall)
+		if !bytes.Equal(p, pu) {
+			t.Fatalf("%v encoded differently: %x != %x", fcall, pu, p)
+		}
+	}
+
+	// directory entries, as read from directories.
+	d := Dir{Qid: Qid{Path: 1}, Name: "dev", UID: "glenda", Extension: "c 1 3", NUid: 1000, NGid: 1000, NMuid: NONUNAME}
+	d.AccessTime, d.ModTime = DontTouchTime.UTC(), DontTouchTime.UTC()
+
+	var b bytes.Buffer
+	if err := EncodeDir(codecu, &b, &d); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Len() != codecu.Size(d) || b.Len() != codec.Size(d)+2+len(d.Extension)+12 {
+		t.Fatalf("unexpected size of entry: %v", b.Len())
+	}
+
+	var decoded Dir
+	if err := DecodeDir(codecu, &b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != d {
+		t.Fatalf("unexpected entry: %#v != %#v", decoded, d)
+	}
+}
+
+func TestUnmarshalAliasesData(t *testing.T) {
+	codec := codec9p{}
+	p, err := codec.Marshal(newFcall(1, MessageRread{Data: []byte("hello")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fcall Fcall
+	if err := codec.Unmarshal(p, &fcall); err != nil {
+		t.Fatal(err)
+	}
+
+	copy(p[len(p)-5:], "world")
+	if data := fcall.Message.(MessageRread).Data; string(data) != "world" {
+		t.Fatalf("data does not alias the buffer: %q", data)
+	}
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	codec := codec9p{}
+	wnames := make([]string, MAXWELEM+1)
+	for i := range wnames {
+		wnames[i] = "a"
+	}
+
+	for _, fcall := range []*Fcall{
+		newFcall(1, MessageTwalk{Fid: 1, Newfid: 2, Wnames: wnames}),
+		newFcall(1, MessageRwalk{Qids: make([]Qid, MAXWELEM+1)}),
+	} {
+		p := reflectMarshal(t, fcall)
+		if err := codec.Unmarshal(p, new(Fcall)); err != ErrWalkLimit {
+			t.Fatalf("expected %v decoding %v, got %v", ErrWalkLimit, fcall, err)
+		}
+	}
+
+	// counts beyond the input fail without allocating for them.
+	for _, v := range []interface{}{new([]byte), new(string), new([]string), new([]Qid), new(Dir)} {
+		p := []byte{0xff, 0xff, 0xff, 0xff}
+		if err := codec.Unmarshal(p, v); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected %v decoding %T, got %v", io.ErrUnexpectedEOF, v, err)
+		}
+	}
+}
+
+func benchmarkFcalls() map[string]*Fcall {
+	data := bytes.Repeat([]byte{'A'}, 8192)
+	return map[string]*Fcall{
+		"Tread":  newFcall(1, MessageTread{Fid: 1, Offset: 8192, Count: 8192}),
+		"Rread":  newFcall(1, MessageRread{Data: data}),
+		"Twrite": newFcall(1, MessageTwrite{Fid: 1, Offset: 8192, Data: data}),
+		"Twalk":  newFcall(1, MessageTwalk{Fid: 1, Newfid: 2, Wnames: []string{"usr", "glenda", "lib"}}),
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	codec := codec9p{}
+	for name, fcall := range benchmarkFcalls() {
+		b.Run(name+"/Reflect", func(b *testing.B) {
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				if err := (&encoder{&buf}).encode(fcall); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(name+"/MarshalTo", func(b *testing.B) {
+			buf := make([]byte, DefaultMSize)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := codec.MarshalTo(buf, fcall); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	codec := codec9p{}
+	for name, fcall := range benchmarkFcalls() {
+		p := reflectMarshal(b, fcall)
+
+		b.Run(name+"/Reflect", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var fcall Fcall
+				if err := (&decoder{bytes.NewReader(p)}).decode(&fcall); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(name+"/Unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var fcall Fcall
+				if err := codec.Unmarshal(p, &fcall); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+# Protocol description for the 9p messages of this package. The files
+# messages.go, encoding_gen.go and messages_gen_test.go are generated from it
+# by cmd/p9pgen. To add a message, describe it here and run go generate.
+#
+# Each dialect groups the types of its messages into a block of constants:
+#
+#	dialect <name>
+#
+# followed by a line for each message:
+#
+#	<type> <number> [<field> <go type>, ...] [!<option> ...]
+#
+# Fields are encoded in order. Supported field types are uint8, uint16,
+# uint32, uint64, Fid, Tag, Flag, string, []string, []byte, Qid, []Qid,
+# Timespec and Dir. Options are:
+#
+#	!nomessage	the type is reserved, without a message
+#	!external	the message struct and Type method are declared by hand
+#	!statsize	prefix the message with the size of its Dir, as in Rstat
+#
+# A dialect may instead append fields to the messages of the dialects before
+# it, or to Dir, with a line for each:
+#
+#	<type> extends <field> <go type>, ...
+#
+# The fields are added to the message structs, tagged with the dialect so
+# that other dialects skip them. The fields of Dir and !external messages are
+# declared by hand with the same tag. The extended messages, and those with a
+# Dir if it is extended, are encoded with the fields by the codec of the
+# dialect, codec9p with the field named after the dialect set, such as dotu
+# for 9P2000.u.
+#
+# Lines starting with // are carried to the doc comment of the next message
+# or dialect. Comments starting with # are ignored.
+
+// Definitions for Fcall's used in 9P2000.
+dialect 9P2000
+
+// MessageVersion encodes the message body for Tversion and Rversion RPC
+// calls. The body is identical in both directions.
+Tversion 100 MSize uint32, Version string
+Rversion 101 MSize uint32, Version string
+Tauth 102 Afid Fid, Uname string, Aname string
+Rauth 103 Qid Qid
+Tattach 104 Fid Fid, Afid Fid, Uname string, Aname string
+Rattach 105 Qid Qid
+# invalid, errors are only sent in response.
+Terror 106 !nomessage
+Rerror 107 Ename string !external
+Tflush 108 Oldtag Tag
+Rflush 109
+Twalk 110 Fid Fid, Newfid Fid, Wnames []string
+Rwalk 111 Qids []Qid
+Topen 112 Fid Fid, Mode Flag
+Ropen 113 Qid Qid, IOUnit uint32
+Tcreate 114 Fid Fid, Name string, Perm uint32, Mode Flag
+Rcreate 115 Qid Qid, IOUnit uint32
+Tread 116 Fid Fid, Offset uint64, Count uint32
+Rread 117 Data []byte
+Twrite 118 Fid Fid, Offset uint64, Data []byte
+Rwrite 119 Count uint32
+Tclunk 120 Fid Fid
+Rclunk 121
+Tremove 122 Fid Fid
+Rremove 123
+Tstat 124 Fid Fid
+Rstat 125 Stat Dir !statsize
+Twstat 126 Fid Fid, Stat Dir
+Rwstat 127
+Tmax 128 !nomessage
+
+# 9P2000.u adds numeric ids, errnos and special files to the messages of
+# 9P2000, identified by UnixVersion. The numeric ids are NONUNAME if unset and
+# ^uint32(0) in Dir for wstat(5).
+dialect 9P2000.u
+
+Tauth extends NUname uint32
+Tattach extends NUname uint32
+Rerror extends Errno uint32
+Tcreate extends Extension string
+Dir extends Extension string, NUid uint32, NGid uint32, NMuid uint32
+
+// Definitions for Fcall's from 9P2000.L, supported as extensions to 9P2000.
+// See SessionL for details.
+dialect 9P2000.L
+
+// MessageRlerror is the error response of 9P2000.L, carrying a Linux errno
+// rather than a string. As an error, it unwraps to the syscall.Errno.
+Rlerror 7 Ecode uint32
+Tstatfs 8 Fid Fid
+// MessageRstatfs carries the fields of StatFS and may be converted to and
+// from it.
+Rstatfs 9 FSType uint32, BSize uint32, Blocks uint64, BFree uint64, BAvail uint64, Files uint64, FFree uint64, FSID uint64, NameLen uint32
+Tgetattr 24 Fid Fid, RequestMask uint64
+// MessageRgetattr carries the fields of Attr and may be converted to and
+// from it.
+Rgetattr 25 Valid uint64, Qid Qid, Mode uint32, UID uint32, GID uint32, NLink uint64, RDev uint64, Size uint64, BlkSize uint64, Blocks uint64, ATime Timespec, MTime Timespec, CTime Timespec, BTime Timespec, Gen uint64, DataVersion uint64
+Txattrwalk 30 Fid Fid, Newfid Fid, Name string
+Rxattrwalk 31 Size uint64
+Txattrcreate 32 Fid Fid, Name string, Size uint64, Flags uint32
+Rxattrcreate 33
+Tfsync 50 Fid Fid, Datasync uint32
+Rfsync 51
+Tlock 52 Fid Fid, LockType uint8, Flags uint32, Start uint64, Length uint64, ProcID uint32, ClientID string
+Rlock 53 Status uint8
+Tgetlock 54 Fid Fid, LockType uint8, Start uint64, Length uint64, ProcID uint32, ClientID string
+Rgetlock 55 LockType uint8, Start uint64, Length uint64, ProcID uint32, ClientID string
+
+// Ttrace carries trace context for the request that follows it with the same
+// tag. It has no response and is only sent on connections that negotiated
+// TraceVersion.
+dialect 9P2000.trace
+
+// MessageTtrace holds trace context as alternating keys and values. See
+// Propagator.
+Ttrace 150 Fields []string
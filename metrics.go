@@ -0,0 +1,287 @@
+package p9p
+
+import (
+	"encoding/json"
+	"expvar"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Metrics receives measurements from servers and clients, configured with
+// WithMetrics. Implementations must be safe for concurrent use, since they
+// are called from the loops of each connection. A single implementation may
+// be shared across connections to aggregate them or be created for each
+// connection.
+type Metrics interface {
+	// Negotiated records the msize and version of a new connection.
+	Negotiated(msize int, version string)
+
+	// Request records the completion of a request of type t after
+	// latency. If the request failed, err is the error returned.
+	Request(t FcallType, latency time.Duration, err error)
+
+	// Flushed records a request that was flushed before completion.
+	Flushed()
+
+	// Outstanding adjusts the number of requests in flight, each holding a
+	// tag, by delta.
+	Outstanding(delta int)
+
+	// Fids adjusts the number of live fids by delta.
+	Fids(delta int)
+
+	// BytesRead and BytesWritten record traffic on connections.
+	BytesRead(n int)
+	BytesWritten(n int)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Negotiated(msize int, version string)                  {}
+func (nopMetrics) Request(t FcallType, latency time.Duration, err error) {}
+func (nopMetrics) Flushed()                                              {}
+func (nopMetrics) Outstanding(delta int)                                 {}
+func (nopMetrics) Fids(delta int)                                        {}
+func (nopMetrics) BytesRead(n int)                                       {}
+func (nopMetrics) BytesWritten(n int)                                    {}
+
+// meteredConn counts the bytes moved over a connection.
+type meteredConn struct {
+	net.Conn
+	metrics Metrics
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.metrics.BytesRead(n)
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.metrics.BytesWritten(n)
+	return n, err
+}
+
+// fidDelta returns the change in live fids from the request and its
+// response, which is an error if the request failed.
+func fidDelta(req, resp Message) int {
+	if messageError(resp) != nil {
+		if _, ok := req.(MessageTremove); ok {
+			// remove clunks the fid, even when it fails.
+			return -1
+		}
+
+		return 0
+	}
+
+	switch req := req.(type) {
+	case MessageTauth, MessageTattach, MessageTxattrwalk:
+		return 1
+	case MessageTwalk:
+		rwalk, ok := resp.(MessageRwalk)
+		if ok && req.Newfid != req.Fid && len(rwalk.Qids) == len(req.Wnames) {
+			return 1
+		}
+	case MessageTclunk, MessageTremove:
+		return -1
+	}
+
+	return 0
+}
+
+// messageError returns the error carried by resp, if any.
+func messageError(resp Message) error {
+	switch err := resp.(type) {
+	case MessageRerror:
+		return err
+	case MessageRlerror:
+		return err
+	}
+
+	return nil
+}
+
+// latencyBuckets are the upper bounds of the latency histograms kept by
+// ExpvarMetrics.
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// ExpvarMetrics implements Metrics by publishing to expvar. The published
+// map holds:
+//
+//	requests      count of requests by message type
+//	errors        count of errors by canonical ename, or "other"
+//	latency       histogram of latency by message type
+//	versions      count of connections by negotiated version
+//	msize         last negotiated msize
+//	flushes       count of flushed requests
+//	outstanding   requests in flight
+//	fids          live fids
+//	bytes_read    bytes read from connections
+//	bytes_written bytes written to connections
+type ExpvarMetrics struct {
+	requests     *expvar.Map
+	errors       *expvar.Map
+	versions     *expvar.Map
+	msize        *expvar.Int
+	flushes      *expvar.Int
+	outstanding  *expvar.Int
+	fids         *expvar.Int
+	bytesRead    *expvar.Int
+	bytesWritten *expvar.Int
+
+	mu      sync.Mutex
+	latency *expvar.Map // of *histogram
+}
+
+var _ Metrics = &ExpvarMetrics{}
+
+// NewExpvarMetrics publishes metrics under name with expvar. Like
+// expvar.Publish, it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		requests:     new(expvar.Map).Init(),
+		errors:       new(expvar.Map).Init(),
+		versions:     new(expvar.Map).Init(),
+		latency:      new(expvar.Map).Init(),
+		msize:        new(expvar.Int),
+		flushes:      new(expvar.Int),
+		outstanding:  new(expvar.Int),
+		fids:         new(expvar.Int),
+		bytesRead:    new(expvar.Int),
+		bytesWritten: new(expvar.Int),
+	}
+
+	vars := expvar.NewMap(name)
+	vars.Set("requests", m.requests)
+	vars.Set("errors", m.errors)
+	vars.Set("versions", m.versions)
+	vars.Set("latency", m.latency)
+	vars.Set("msize", m.msize)
+	vars.Set("flushes", m.flushes)
+	vars.Set("outstanding", m.outstanding)
+	vars.Set("fids", m.fids)
+	vars.Set("bytes_read", m.bytesRead)
+	vars.Set("bytes_written", m.bytesWritten)
+
+	return m
+}
+
+func (m *ExpvarMetrics) Negotiated(msize int, version string) {
+	m.msize.Set(int64(msize))
+	m.versions.Add(version, 1)
+}
+
+func (m *ExpvarMetrics) Request(t FcallType, latency time.Duration, err error) {
+	key := t.String()
+	m.requests.Add(key, 1)
+
+	if err != nil {
+		m.errors.Add(errorKey(err), 1)
+	}
+
+	m.mu.Lock()
+	h, ok := m.latency.Get(key).(*histogram)
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		m.latency.Set(key, h)
+	}
+	m.mu.Unlock()
+
+	h.observe(latency)
+}
+
+// otherErrors counts the errors without a canonical ename, whose text is
+// chosen by the handler or the peer and may carry paths.
+const otherErrors = "other"
+
+// errorKey returns the key of err in the errors map, keeping the number of
+// keys bounded. Errnos of 9P2000.L are counted under their canonical ename.
+func errorKey(err error) string {
+	switch err := err.(type) {
+	case MessageRerror:
+		if canonicalEnames[err.Ename] {
+			return err.Ename
+		}
+	case MessageRlerror:
+		if rerr, ok := errnos[syscall.Errno(err.Ecode)]; ok {
+			return rerr.Ename
+		}
+	}
+
+	return otherErrors
+}
+
+func (m *ExpvarMetrics) Flushed()              { m.flushes.Add(1) }
+func (m *ExpvarMetrics) Outstanding(delta int) { m.outstanding.Add(int64(delta)) }
+func (m *ExpvarMetrics) Fids(delta int)        { m.fids.Add(int64(delta)) }
+func (m *ExpvarMetrics) BytesRead(n int)       { m.bytesRead.Add(int64(n)) }
+func (m *ExpvarMetrics) BytesWritten(n int)    { m.bytesWritten.Add(int64(n)) }
+
+// histogram is an expvar.Var counting durations into buckets.
+type histogram struct {
+	mu      sync.Mutex
+	buckets []time.Duration
+	counts  []uint64 // counts[len(buckets)] holds durations above all buckets
+	count   uint64
+	sum     time.Duration
+}
+
+func newHistogram(buckets []time.Duration) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := 0
+	for i < len(h.buckets) && d > h.buckets[i] {
+		i++
+	}
+
+	h.counts[i]++
+	h.count++
+	h.sum += d
+}
+
+// String implements expvar.Var, reporting cumulative counts for each bucket
+// in the style of Prometheus.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var (
+		buckets    = make(map[string]uint64, len(h.counts))
+		cumulative uint64
+	)
+
+	for i, n := range h.counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(h.buckets) {
+			le = h.buckets[i].String()
+		}
+		buckets[le] = cumulative
+	}
+
+	p, _ := json.Marshal(struct {
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+		Buckets map[string]uint64 `json:"buckets"`
+	}{h.count, h.sum.Seconds(), buckets})
+
+	return string(p)
+}
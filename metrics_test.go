@@ -0,0 +1,153 @@
+package p9p
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testMetrics records the measurements it receives.
+type testMetrics struct {
+	mu          sync.Mutex
+	msize       int
+	requests    map[FcallType]int
+	errors      int
+	outstanding int
+	fids        int
+	read        int
+	written     int
+}
+
+func (m *testMetrics) Negotiated(msize int, version string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msize = msize
+}
+
+func (m *testMetrics) Request(t FcallType, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[t]++
+	if err != nil {
+		m.errors++
+	}
+}
+
+func (m *testMetrics) Flushed() {}
+
+func (m *testMetrics) Outstanding(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outstanding += delta
+}
+
+func (m *testMetrics) Fids(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fids += delta
+}
+
+func (m *testMetrics) BytesRead(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.read += n
+}
+
+func (m *testMetrics) BytesWritten(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written += n
+}
+
+func TestMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		server = &testMetrics{requests: map[FcallType]int{}}
+		client = &testMetrics{requests: map[FcallType]int{}}
+	)
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(ctx, sconn, Dispatch(&rwSession{}), WithMetrics(server))
+	}()
+
+	session, err := NewSession(ctx, cconn, WithMetrics(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, NOFID, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Read(ctx, 1, make([]byte, 16), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Clunk(ctx, 1); err == nil {
+		t.Fatal("expected clunk to fail")
+	}
+
+	client.mu.Lock()
+	if client.msize != DefaultMSize || client.requests[Tattach] != 1 || client.requests[Tread] != 1 ||
+		client.errors != 1 || client.outstanding != 0 || client.fids != 1 || client.read == 0 || client.written == 0 {
+		t.Fatalf("unexpected client metrics: %+v", client)
+	}
+	client.mu.Unlock()
+
+	cconn.Close()
+	<-done
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.msize != DefaultMSize || server.requests[Tattach] != 1 || server.requests[Tread] != 1 ||
+		server.errors != 1 || server.outstanding != 0 || server.fids != 0 || server.read != client.written {
+		t.Fatalf("unexpected server metrics: %+v", server)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(latencyBuckets)
+	h.observe(50 * time.Microsecond)
+	h.observe(5 * time.Millisecond)
+	h.observe(time.Minute)
+
+	var v struct {
+		Count   uint64
+		Buckets map[string]uint64
+	}
+	if err := json.Unmarshal([]byte(h.String()), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Count != 3 || v.Buckets["100µs"] != 1 || v.Buckets["10ms"] != 2 || v.Buckets["10s"] != 2 || v.Buckets["+Inf"] != 3 {
+		t.Fatalf("unexpected histogram: %v", h)
+	}
+}
+
+func TestErrorKey(t *testing.T) {
+	for _, testcase := range []struct {
+		err error
+		key string
+	}{
+		{ErrNotfound, "file not found"},
+		{MessageRerror{Ename: "file not found", Errno: uint32(syscall.ENOENT)}, "file not found"},
+		{MessageRerror{Ename: "open /srv/secret: input/output error"}, "other"},
+		{MessageRlerror{Ecode: uint32(syscall.EACCES)}, "permission denied"},
+		{MessageRlerror{Ecode: uint32(syscall.EIO)}, "other"},
+	} {
+		if key := errorKey(testcase.err); key != testcase.key {
+			t.Fatalf("expected %q for %v, got %q", testcase.key, testcase.err, key)
+		}
+	}
+}
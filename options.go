@@ -0,0 +1,69 @@
+package p9p
+
+// Option configures a connection in ServeConn or NewSession.
+type Option func(*options)
+
+type options struct {
+	metrics    Metrics
+	propagator Propagator
+	unix       bool
+	channel    func(Channel) Channel
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		metrics: nopMetrics{},
+		channel: func(ch Channel) Channel { return ch },
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithMetrics reports measurements of the connection to metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(o *options) {
+		if metrics != nil {
+			o.metrics = metrics
+		}
+	}
+}
+
+// WithPropagator carries trace context across the connection with
+// propagator. The connection negotiates TraceVersion, falling back to
+// DefaultVersion without tracing if the peer doesn't support it.
+func WithPropagator(propagator Propagator) Option {
+	return func(o *options) {
+		o.propagator = propagator
+	}
+}
+
+// WithUnix negotiates UnixVersion, falling back to DefaultVersion if the peer
+// doesn't support it. With 9P2000.u, errors carry their errno along with the
+// string, such as the syscall.Errno of the errors returned by a session.
+// WithPropagator takes precedence in the version requested by a client.
+//
+// The numeric ids of Tauth and Tattach are not passed to sessions, which see
+// the version with GetVersion. Sessions returning directory entries from
+// Read must encode them with the codec from NewVersionCodec.
+func WithUnix() Option {
+	return func(o *options) {
+		o.unix = true
+	}
+}
+
+// WithChannel wraps the channel of the connection with wrap, before the
+// version is negotiated. For example, NewRecorder can be used to record the
+// conversation. Channels are wrapped in the order of the options, the last
+// being outermost.
+func WithChannel(wrap func(ch Channel) Channel) Option {
+	return func(o *options) {
+		if wrap != nil {
+			inner := o.channel
+			o.channel = func(ch Channel) Channel { return wrap(inner(ch)) }
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package p9ptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+var checks = []check{
+	{"walk", checkWalk},
+	{"remove", checkRemove},
+	{"open", checkOpen},
+	{"readdir", checkReaddir},
+	{"flush", checkFlush},
+	{"fid", checkFid},
+	{"tag", checkTag},
+	{"wstat", checkWStat},
+	{"msize", checkMSize},
+}
+
+// checkWalk checks walk(5): a walk failing after the first element returns
+// the qids walked, leaving newfid unused, while failing the first element
+// is an error.
+func checkWalk(ctx context.Context, t *tester) {
+	if err := t.mkdir(ctx, "d"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if err := t.mkfile(ctx, nil, "d", "f"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	newfid := t.fid()
+	qids, err := t.session.Walk(ctx, t.dir, newfid, "d", "missing")
+	switch {
+	case err != nil:
+		t.violatef("partial walk returned %v rather than the qids walked", err)
+	case len(qids) != 1:
+		t.violatef("partial walk returned %d qids, expected 1", len(qids))
+	case qids[0].Type&p9p.QTDIR == 0:
+		t.violatef("walk to a directory returned %v without QTDIR", qids[0])
+	}
+
+	if err := t.session.Clunk(ctx, newfid); err == nil {
+		t.violatef("partial walk set up newfid")
+	}
+
+	newfid = t.fid()
+	if qids, err := t.session.Walk(ctx, t.dir, newfid, "missing"); err == nil {
+		t.violatef("walk failing the first element returned %d qids without error", len(qids))
+	}
+
+	if err := t.session.Clunk(ctx, newfid); err == nil {
+		t.violatef("failed walk set up newfid")
+	}
+
+	// a partial walk of a fid to itself leaves it unchanged.
+	fid := t.fid()
+	if _, err := t.walk(ctx, t.dir, fid); err != nil {
+		t.violatef("clone: %v", err)
+		return
+	}
+
+	want, err := t.session.Stat(ctx, fid)
+	if err != nil {
+		t.violatef("stat: %v", err)
+		return
+	}
+
+	t.session.Walk(ctx, fid, fid, "d", "missing")
+	if d, err := t.session.Stat(ctx, fid); err != nil {
+		t.violatef("fid unusable after a partial walk to itself: %v", err)
+	} else if d.Qid.Path != want.Qid.Path {
+		t.violatef("partial walk to itself moved fid from %v to %v", want.Qid, d.Qid)
+	}
+	t.session.Clunk(ctx, fid)
+
+	fid = t.fid()
+	if qids, err := t.walk(ctx, t.dir, fid, "d", "f"); err != nil {
+		t.violatef("walk: %v", err)
+	} else {
+		if d, err := t.session.Stat(ctx, fid); err != nil {
+			t.violatef("stat after walk: %v", err)
+		} else if qids[1].Path != d.Qid.Path {
+			t.violatef("walk returned %v for a file with %v", qids[1], d.Qid)
+		}
+
+		newfid := t.fid()
+		if _, err := t.session.Walk(ctx, fid, newfid, "x"); err == nil {
+			t.violatef("walk in a file succeeded")
+			t.session.Clunk(ctx, newfid)
+		}
+
+		t.session.Clunk(ctx, fid)
+	}
+
+	fid = t.fid()
+	if qids, err := t.walk(ctx, t.dir, fid, "d", ".."); err != nil {
+		t.violatef("walk of ..: %v", err)
+	} else {
+		if d, err := t.session.Stat(ctx, t.dir); err != nil {
+			t.violatef("stat: %v", err)
+		} else if qids[1].Path != d.Qid.Path {
+			t.violatef("walk of .. returned %v rather than the parent %v", qids[1], d.Qid)
+		}
+
+		t.session.Clunk(ctx, fid)
+	}
+}
+
+// checkRemove checks remove(5): the fid is clunked, even if the remove
+// fails.
+func checkRemove(ctx context.Context, t *tester) {
+	fid, err := t.create(ctx, 0644, p9p.OWRITE, "f")
+	if err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if err := t.session.Remove(ctx, fid); err != nil {
+		t.violatef("remove: %v", err)
+	}
+
+	if err := t.session.Clunk(ctx, fid); err == nil {
+		t.violatef("fid still in use after remove")
+	}
+
+	fid = t.fid()
+	if _, err := t.walk(ctx, t.dir, fid, "f"); err == nil {
+		t.violatef("removed file still exists")
+		t.session.Clunk(ctx, fid)
+	}
+
+	// a directory that isn't empty cannot be removed.
+	if err := t.mkdir(ctx, "d"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if err := t.mkfile(ctx, nil, "d", "f"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	fid = t.fid()
+	if _, err := t.walk(ctx, t.dir, fid, "d"); err != nil {
+		t.violatef("walk: %v", err)
+		return
+	}
+
+	if err := t.session.Remove(ctx, fid); err != nil {
+		if err := t.session.Clunk(ctx, fid); err == nil {
+			t.violatef("fid still in use after failed remove")
+		}
+	}
+}
+
+// checkOpen checks that fids are opened once, for the I/O allowed by the
+// mode, and that create refuses existing names.
+func checkOpen(ctx context.Context, t *tester) {
+	if err := t.mkfile(ctx, []byte("hello"), "f"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if err := t.mkdir(ctx, "d"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	p := make([]byte, 64)
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		if _, err := t.session.Read(ctx, fid, p, 0); err == nil {
+			t.violatef("read of a fid that is not open succeeded")
+		}
+
+		if _, err := t.session.Write(ctx, fid, []byte("x"), 0); err == nil {
+			t.violatef("write to a fid that is not open succeeded")
+		}
+	})
+
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		if _, _, err := t.session.Open(ctx, fid, p9p.OREAD); err != nil {
+			t.violatef("open for reading: %v", err)
+			return
+		}
+
+		if n, err := t.session.Read(ctx, fid, p, 0); err != nil {
+			t.violatef("read: %v", err)
+		} else if string(p[:n]) != "hello" {
+			t.violatef("read %q, expected %q", p[:n], "hello")
+		}
+
+		if _, err := t.session.Write(ctx, fid, []byte("x"), 0); err == nil {
+			t.violatef("write to a file opened for reading succeeded")
+		}
+
+		if _, _, err := t.session.Open(ctx, fid, p9p.OREAD); err == nil {
+			t.violatef("fid opened twice")
+		}
+	})
+
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		if _, _, err := t.session.Open(ctx, fid, p9p.OWRITE); err != nil {
+			t.violatef("open for writing: %v", err)
+			return
+		}
+
+		if _, err := t.session.Read(ctx, fid, p, 0); err == nil {
+			t.violatef("read of a file opened for writing succeeded")
+		}
+	})
+
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		if _, _, err := t.session.Open(ctx, fid, p9p.OWRITE|p9p.OTRUNC); err != nil {
+			t.violatef("open with OTRUNC: %v", err)
+			return
+		}
+
+		if d, err := t.session.Stat(ctx, fid); err != nil {
+			t.violatef("stat: %v", err)
+		} else if d.Length != 0 {
+			t.violatef("length %d after open with OTRUNC", d.Length)
+		}
+	})
+
+	t.withFile(ctx, "d", func(fid p9p.Fid) {
+		if _, _, err := t.session.Open(ctx, fid, p9p.OWRITE); err == nil {
+			t.violatef("directory opened for writing")
+		}
+	})
+
+	if fid, err := t.create(ctx, 0644, p9p.OREAD, "f"); err == nil {
+		t.violatef("create of an existing file succeeded")
+		t.session.Clunk(ctx, fid)
+	}
+}
+
+// withFile calls fn with a fid for the file at name in the scratch
+// directory, clunking it on return.
+func (t *tester) withFile(ctx context.Context, name string, fn func(fid p9p.Fid)) {
+	fid := t.fid()
+	if _, err := t.walk(ctx, t.dir, fid, name); err != nil {
+		t.violatef("walk: %v", err)
+		return
+	}
+	defer t.session.Clunk(ctx, fid)
+
+	fn(fid)
+}
+
+// checkReaddir checks read(5) on directories: reads return whole entries,
+// each once, and may only continue from the previous read or restart from
+// offset zero.
+func checkReaddir(ctx context.Context, t *tester) {
+	const entries = 20
+
+	if err := t.mkdir(ctx, "d"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	want := map[string]bool{}
+	for i := 0; i < entries; i++ {
+		name := fmt.Sprintf("file%02d", i)
+		if err := t.mkfile(ctx, nil, "d", name); err != nil {
+			t.violatef("setup: %v", err)
+			return
+		}
+		want[name] = true
+	}
+
+	t.withFile(ctx, "d", func(fid p9p.Fid) {
+		if _, _, err := t.session.Open(ctx, fid, p9p.OREAD); err != nil {
+			t.violatef("open: %v", err)
+			return
+		}
+
+		// reads smaller than the directory take several calls.
+		var (
+			p      = make([]byte, 512)
+			seen   = map[string]bool{}
+			offset int64
+		)
+
+		for {
+			n, err := t.session.Read(ctx, fid, p, offset)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.violatef("read at offset %d: %v", offset, err)
+				return
+			}
+			offset += int64(n)
+
+			rd := bytes.NewReader(p[:n])
+			for rd.Len() > 0 {
+				var d p9p.Dir
+				if err := p9p.DecodeDir(p9p.NewCodec(), rd, &d); err != nil {
+					t.violatef("read returned a partial entry: %v", err)
+					return
+				}
+
+				switch {
+				case !want[d.Name]:
+					t.violatef("unexpected entry %q", d.Name)
+				case seen[d.Name]:
+					t.violatef("entry %q read twice", d.Name)
+				}
+				seen[d.Name] = true
+			}
+		}
+
+		if len(seen) != len(want) {
+			t.violatef("read %d entries, expected %d", len(seen), len(want))
+		}
+
+		if _, err := t.session.Read(ctx, fid, p, 0); err == io.EOF {
+			t.violatef("read at offset 0 after the end did not restart")
+			return
+		} else if err != nil {
+			t.violatef("read at offset 0 after the end: %v", err)
+			return
+		}
+
+		if _, err := t.session.Read(ctx, fid, p, 1); err == nil {
+			t.violatef("read at an offset within an entry succeeded")
+		}
+	})
+}
+
+// checkFlush checks flush(5): a flush is always answered, and the request
+// it flushes is answered before the flush or not at all.
+func checkFlush(ctx context.Context, t *tester) {
+	const fid = 1
+
+	r, err := t.raw(ctx, p9p.DefaultMSize, fid)
+	if err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if resp, err := r.rpc(ctx, 2, p9p.MessageTflush{Oldtag: 100}); err != nil {
+		t.violatef("flush of a tag not in use returned %v rather than Rflush", err)
+	} else if _, ok := resp.(p9p.MessageRflush); !ok {
+		t.violatef("unexpected response to flush: %v", resp)
+	}
+
+	if err := r.send(ctx, 3, p9p.MessageTstat{Fid: fid}); err != nil {
+		t.violatef("send: %v", err)
+		return
+	}
+
+	if err := r.send(ctx, 4, p9p.MessageTflush{Oldtag: 3}); err != nil {
+		t.violatef("send: %v", err)
+		return
+	}
+
+	for flushed := false; !flushed; {
+		resp, err := r.recv(ctx)
+		if err != nil {
+			t.violatef("waiting for flush: %v", err)
+			return
+		}
+
+		switch resp.Tag {
+		case 3:
+		case 4:
+			if _, ok := resp.Message.(p9p.MessageRflush); !ok {
+				t.violatef("unexpected response to flush: %v", resp)
+			}
+			flushed = true
+		default:
+			t.violatef("response with unexpected tag: %v", resp)
+		}
+	}
+
+	// the tag of the flushed request is free for reuse, and its response
+	// must not follow.
+	resp, err := r.rpc(ctx, 3, p9p.MessageTwalk{Fid: fid, Newfid: 2})
+	if err != nil {
+		t.violatef("request reusing a flushed tag: %v", err)
+		return
+	}
+
+	if _, ok := resp.(p9p.MessageRwalk); !ok {
+		t.violatef("flushed request answered after the flush: %v", resp)
+	}
+}
+
+// checkFid checks that fids in use, or NOFID, cannot be attached or walked
+// to.
+func checkFid(ctx context.Context, t *tester) {
+	if err := t.mkfile(ctx, nil, "f"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		if _, err := t.session.Attach(ctx, fid, p9p.NOFID, t.uname(), t.suite.Aname); err == nil {
+			t.violatef("attach to a fid in use succeeded")
+		}
+
+		if _, err := t.session.Walk(ctx, t.dir, fid); err == nil {
+			t.violatef("walk to a newfid in use succeeded")
+		}
+
+		if d, err := t.session.Stat(ctx, fid); err != nil {
+			t.violatef("fid in use unusable after reuse: %v", err)
+		} else if d.Name != "f" {
+			t.violatef("fid in use changed to %q by reuse", d.Name)
+		}
+	})
+
+	if _, err := t.session.Attach(ctx, p9p.NOFID, p9p.NOFID, t.uname(), t.suite.Aname); err == nil {
+		t.violatef("attach to NOFID succeeded")
+	}
+
+	if _, err := t.session.Walk(ctx, t.dir, p9p.NOFID); err == nil {
+		t.violatef("walk to NOFID succeeded")
+	}
+
+	if err := t.session.Clunk(ctx, t.fid()); err == nil {
+		t.violatef("clunk of an unknown fid succeeded")
+	}
+}
+
+// checkTag checks that requests sharing a tag are each answered, or
+// refused, without ending the connection.
+func checkTag(ctx context.Context, t *tester) {
+	const fid = 1
+
+	r, err := t.raw(ctx, p9p.DefaultMSize, fid)
+	if err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.send(ctx, 2, p9p.MessageTstat{Fid: fid}); err != nil {
+			t.violatef("send: %v", err)
+			return
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := r.recv(ctx)
+		if err != nil {
+			t.violatef("waiting for requests with the same tag: %v", err)
+			return
+		}
+
+		switch resp.Message.(type) {
+		case p9p.MessageRstat, p9p.MessageRerror:
+		default:
+			t.violatef("unexpected response: %v", resp)
+		}
+
+		if resp.Tag != 2 {
+			t.violatef("response with unexpected tag: %v", resp)
+		}
+	}
+
+	if _, err := r.rpc(ctx, 3, p9p.MessageTstat{Fid: fid}); err != nil {
+		t.violatef("request after a duplicate tag: %v", err)
+	}
+}
+
+// checkWStat checks wstat(5): fields set to "don't touch" are left
+// unchanged, so that a wstat of only those changes nothing.
+func checkWStat(ctx context.Context, t *tester) {
+	if err := t.mkfile(ctx, []byte("hello"), "f"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	t.withFile(ctx, "f", func(fid p9p.Fid) {
+		before, err := t.session.Stat(ctx, fid)
+		if err != nil {
+			t.violatef("stat: %v", err)
+			return
+		}
+
+		for _, step := range []struct {
+			name   string
+			dir    p9p.Dir
+			change func(want *p9p.Dir, after p9p.Dir)
+		}{
+			{"nothing", p9p.NewWStatDir(), func(want *p9p.Dir, after p9p.Dir) {}},
+			{
+				"length",
+				p9p.NewWStatBuilder().SetLength(1).Dir(),
+				func(want *p9p.Dir, after p9p.Dir) {
+					want.Length = 1
+					want.ModTime = after.ModTime // truncation is a modification
+				},
+			},
+			{
+				"mode",
+				p9p.NewWStatBuilder().SetMode(before.Mode&^0777 | 0600).Dir(),
+				func(want *p9p.Dir, after p9p.Dir) { want.Mode = before.Mode&^0777 | 0600 },
+			},
+			{
+				"mtime",
+				p9p.NewWStatBuilder().SetTimes(p9p.DontTouchTime, time.Unix(1e9, 0)).Dir(),
+				func(want *p9p.Dir, after p9p.Dir) { want.ModTime = time.Unix(1e9, 0) },
+			},
+			{
+				"name",
+				p9p.NewWStatBuilder().SetName("g").Dir(),
+				func(want *p9p.Dir, after p9p.Dir) { want.Name = "g" },
+			},
+		} {
+			if err := t.session.WStat(ctx, fid, step.dir); err != nil {
+				t.violatef("wstat of %s: %v", step.name, err)
+				return
+			}
+
+			after, err := t.session.Stat(ctx, fid)
+			if err != nil {
+				t.violatef("stat: %v", err)
+				return
+			}
+
+			step.change(&before, after)
+			for _, field := range []struct {
+				name      string
+				want, got interface{}
+			}{
+				{"name", before.Name, after.Name},
+				{"mode", before.Mode, after.Mode},
+				{"length", before.Length, after.Length},
+				{"mtime", before.ModTime.Unix(), after.ModTime.Unix()},
+				{"uid", before.UID, after.UID},
+				{"gid", before.GID, after.GID},
+				{"qid path", before.Qid.Path, after.Qid.Path},
+			} {
+				if field.want != field.got {
+					t.violatef("wstat of %s changed %s to %v, expected %v", step.name, field.name, field.got, field.want)
+				}
+			}
+
+			before = after
+		}
+	})
+}
+
+// checkMSize checks that the negotiated msize bounds the iounit and every
+// response, including reads asking for more.
+func checkMSize(ctx context.Context, t *tester) {
+	const (
+		msize = 8192
+		fid   = 1
+		file  = 2
+	)
+
+	if err := t.mkfile(ctx, bytes.Repeat([]byte("9p"), 2*msize), "big"); err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	r, err := t.raw(ctx, msize, fid)
+	if err != nil {
+		t.violatef("setup: %v", err)
+		return
+	}
+
+	if _, err := r.rpc(ctx, 2, p9p.MessageTwalk{Fid: fid, Newfid: file, Wnames: []string{t.scratch, "big"}}); err != nil {
+		t.violatef("walk: %v", err)
+		return
+	}
+
+	resp, err := r.rpc(ctx, 3, p9p.MessageTopen{Fid: file, Mode: p9p.OREAD})
+	if err != nil {
+		t.violatef("open: %v", err)
+		return
+	}
+
+	if iounit := resp.(p9p.MessageRopen).IOUnit; int(iounit) > r.msize-p9p.IOHDRSZ {
+		t.violatef("iounit %d beyond msize %d", iounit, r.msize)
+	}
+
+	if err := r.send(ctx, 4, p9p.MessageTread{Fid: file, Count: 2 * msize}); err != nil {
+		t.violatef("send: %v", err)
+		return
+	}
+
+	rread, err := r.recv(ctx)
+	if err != nil {
+		t.violatef("read beyond msize: %v", err)
+		return
+	}
+
+	if size := r.size(rread); size > r.msize {
+		t.violatef("response of %d bytes to a read beyond msize %d", size, r.msize)
+	}
+}
@@ -0,0 +1,468 @@
+// Package p9ptest checks the protocol semantics of 9p servers. A Suite runs
+// a battery of checks against a server, reached by address or served from a
+// Session, and reports the ways it departs from 9P2000 as violations.
+//
+// The checks cover the rules of walk on partial failure, clunking by
+// remove, open modes, directory read offsets, flush, duplicate fids and tags,
+// "don't touch" wstat fields and msize limits. They create files in a
+// scratch directory within the attached tree, which must be writable, and
+// remove them when done.
+package p9ptest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// Dialer returns a new connection to the server under test.
+type Dialer func(ctx context.Context) (net.Conn, error)
+
+// Addr dials the server at address on network, such as "tcp" or "unix".
+func Addr(network, address string) Dialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+}
+
+// Serve serves a new session from newSession for each connection, over an
+// in-memory pipe, with ServeConn and Dispatch.
+func Serve(newSession func(ctx context.Context) (p9p.Session, error), opts ...p9p.Option) Dialer {
+	return func(ctx context.Context) (net.Conn, error) {
+		session, err := newSession(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		cconn, sconn := net.Pipe()
+		go func() {
+			defer sconn.Close()
+			// the server ends with the connection, when the client closes it.
+			p9p.ServeConn(context.Background(), sconn, p9p.Dispatch(session), opts...)
+		}()
+
+		return cconn, nil
+	}
+}
+
+// Violation is a departure from the protocol found by a check.
+type Violation struct {
+	Check   string // name of the check, such as "walk"
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Check + ": " + v.Message
+}
+
+// Suite runs the checks against a server.
+type Suite struct {
+	// Dial connects to the server. Each check runs on its own connections.
+	Dial Dialer
+
+	// Uname and Aname are used to attach to the server. Uname defaults to
+	// "none".
+	Uname, Aname string
+
+	// Timeout bounds each check, defaulting to 10 seconds.
+	Timeout time.Duration
+
+	// Skip lists the names of checks not to run.
+	Skip []string
+}
+
+// NewSuite returns a suite for the server reached through dial.
+func NewSuite(dial Dialer) *Suite {
+	return &Suite{Dial: dial}
+}
+
+// Run runs the checks, returning the violations found. A check that cannot
+// be set up, for example because files cannot be created, is reported as a
+// violation of that check.
+func (s *Suite) Run(ctx context.Context) []Violation {
+	var violations []Violation
+	for _, c := range s.checks() {
+		violations = append(violations, s.run(ctx, c)...)
+	}
+
+	return violations
+}
+
+// Test runs each check as a subtest of t, reporting violations as errors.
+func (s *Suite) Test(t *testing.T) {
+	for _, c := range s.checks() {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			for _, v := range s.run(context.Background(), c) {
+				t.Error(v.Message)
+			}
+		})
+	}
+}
+
+func (s *Suite) checks() []check {
+	var selected []check
+outer:
+	for _, c := range checks {
+		for _, name := range s.Skip {
+			if name == c.name {
+				continue outer
+			}
+		}
+
+		selected = append(selected, c)
+	}
+
+	return selected
+}
+
+func (s *Suite) run(ctx context.Context, c check) []Violation {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t := &tester{suite: s, name: c.name, nextfid: 1}
+	defer t.close()
+
+	if err := t.setup(ctx); err != nil {
+		t.violatef("setup: %v", err)
+		return t.violations
+	}
+
+	c.fn(ctx, t)
+	return t.violations
+}
+
+// check is a named check of the protocol.
+type check struct {
+	name string
+	fn   func(ctx context.Context, t *tester)
+}
+
+// tester holds the state of a running check: a session attached to the
+// server with a scratch directory for the check to work in.
+type tester struct {
+	suite      *Suite
+	name       string
+	violations []Violation
+
+	conns   []net.Conn
+	session p9p.Session
+	nextfid p9p.Fid
+	root    p9p.Fid // the attached root
+	dir     p9p.Fid // the scratch directory, never opened
+	scratch string  // name of the scratch directory in root
+}
+
+func (t *tester) violatef(format string, args ...interface{}) {
+	t.violations = append(t.violations, Violation{
+		Check:   t.name,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// fid returns a fid that has not been used by the tester.
+func (t *tester) fid() p9p.Fid {
+	fid := t.nextfid
+	t.nextfid++
+	return fid
+}
+
+func (t *tester) uname() string {
+	if t.suite.Uname == "" {
+		return "none"
+	}
+	return t.suite.Uname
+}
+
+func (t *tester) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := t.suite.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.conns = append(t.conns, conn)
+	return conn, nil
+}
+
+func (t *tester) setup(ctx context.Context) error {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	t.session, err = p9p.NewSession(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	t.root = t.fid()
+	if _, err := t.session.Attach(ctx, t.root, p9p.NOFID, t.uname(), t.suite.Aname); err != nil {
+		return err
+	}
+
+	t.scratch = fmt.Sprintf("p9ptest.%s.%d", t.name, time.Now().UnixNano())
+	fid := t.fid()
+	if _, err := t.session.Walk(ctx, t.root, fid); err != nil {
+		return err
+	}
+
+	if _, _, err := t.session.Create(ctx, fid, t.scratch, p9p.DMDIR|0755, p9p.OREAD); err != nil {
+		t.session.Clunk(ctx, fid)
+		return err
+	}
+
+	if err := t.session.Clunk(ctx, fid); err != nil {
+		return err
+	}
+
+	t.dir = t.fid()
+	_, err = t.walk(ctx, t.root, t.dir, t.scratch)
+	return err
+}
+
+// close removes the scratch directory and closes the connections.
+func (t *tester) close() {
+	if t.scratch != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		fid := t.fid()
+		if _, err := t.walk(ctx, t.root, fid, t.scratch); err == nil {
+			t.removeAll(ctx, fid)
+		}
+	}
+
+	for _, conn := range t.conns {
+		conn.Close()
+	}
+}
+
+// removeAll removes the file or directory tree at fid, clunking fid.
+func (t *tester) removeAll(ctx context.Context, fid p9p.Fid) {
+	d, err := t.session.Stat(ctx, fid)
+	if err == nil && d.Mode&p9p.DMDIR != 0 {
+		dirfid := t.fid()
+		if _, err := t.session.Walk(ctx, fid, dirfid); err == nil {
+			entries, _ := t.readdir(ctx, dirfid, p9p.OREAD)
+			t.session.Clunk(ctx, dirfid)
+
+			for _, entry := range entries {
+				child := t.fid()
+				if _, err := t.walk(ctx, fid, child, entry.Name); err == nil {
+					t.removeAll(ctx, child)
+				}
+			}
+		}
+	}
+
+	t.session.Remove(ctx, fid)
+}
+
+// walk walks fid to newfid, returning an error unless every name is walked.
+func (t *tester) walk(ctx context.Context, fid, newfid p9p.Fid, names ...string) ([]p9p.Qid, error) {
+	qids, err := t.session.Walk(ctx, fid, newfid, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(qids) != len(names) {
+		return nil, fmt.Errorf("walk of %v stopped after %d elements", names, len(qids))
+	}
+
+	return qids, nil
+}
+
+// create creates the file at path in the scratch directory, returning a fid
+// for it opened with mode.
+func (t *tester) create(ctx context.Context, perm uint32, mode p9p.Flag, path ...string) (p9p.Fid, error) {
+	fid := t.fid()
+	if _, err := t.walk(ctx, t.dir, fid, path[:len(path)-1]...); err != nil {
+		return p9p.NOFID, err
+	}
+
+	if _, _, err := t.session.Create(ctx, fid, path[len(path)-1], perm, mode); err != nil {
+		t.session.Clunk(ctx, fid)
+		return p9p.NOFID, err
+	}
+
+	return fid, nil
+}
+
+// mkfile creates the file at path in the scratch directory with data.
+func (t *tester) mkfile(ctx context.Context, data []byte, path ...string) error {
+	fid, err := t.create(ctx, 0644, p9p.OWRITE, path...)
+	if err != nil {
+		return err
+	}
+	defer t.session.Clunk(ctx, fid)
+
+	for offset := 0; offset < len(data); {
+		n, err := t.session.Write(ctx, fid, data[offset:], int64(offset))
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		offset += n
+	}
+
+	return nil
+}
+
+// mkdir creates the directory at path in the scratch directory.
+func (t *tester) mkdir(ctx context.Context, path ...string) error {
+	fid, err := t.create(ctx, p9p.DMDIR|0755, p9p.OREAD, path...)
+	if err != nil {
+		return err
+	}
+
+	return t.session.Clunk(ctx, fid)
+}
+
+// readdir opens fid with mode and reads all of its directory entries, in
+// reads of the msize of the session.
+func (t *tester) readdir(ctx context.Context, fid p9p.Fid, mode p9p.Flag) ([]p9p.Dir, error) {
+	if _, _, err := t.session.Open(ctx, fid, mode); err != nil {
+		return nil, err
+	}
+
+	msize, _ := t.session.Version()
+	p := make([]byte, msize-p9p.IOHDRSZ)
+
+	var (
+		entries []p9p.Dir
+		offset  int64
+	)
+
+	for {
+		n, err := t.session.Read(ctx, fid, p, offset)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		offset += int64(n)
+
+		rd := bytes.NewReader(p[:n])
+		for rd.Len() > 0 {
+			var d p9p.Dir
+			if err := p9p.DecodeDir(p9p.NewCodec(), rd, &d); err != nil {
+				return entries, err
+			}
+
+			entries = append(entries, d)
+		}
+	}
+}
+
+// raw is a connection to the server on which fcalls are exchanged
+// directly, for checks beyond what a Session can send.
+type raw struct {
+	ch    p9p.Channel
+	msize int // the negotiated msize
+}
+
+// rawMSize is the msize of the channel of raw connections, so that
+// responses beyond the negotiated msize can be read and reported.
+const rawMSize = 1 << 20
+
+// raw dials a new connection, negotiating msize, and attaches fid to the root.
+func (t *tester) raw(ctx context.Context, msize int, fid p9p.Fid) (*raw, error) {
+	conn, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &raw{ch: p9p.NewChannel(conn, rawMSize)}
+	if err := r.send(ctx, p9p.NOTAG, p9p.MessageTversion{
+		MSize:   uint32(msize),
+		Version: "9P2000",
+	}); err != nil {
+		return nil, err
+	}
+
+	resp, err := r.recv(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rversion, ok := resp.Message.(p9p.MessageRversion)
+	if !ok {
+		return nil, fmt.Errorf("unexpected response to version: %v", resp)
+	}
+
+	if rversion.Version != "9P2000" {
+		return nil, fmt.Errorf("unsupported version %q", rversion.Version)
+	}
+
+	if int(rversion.MSize) > msize {
+		t.violatef("version: msize %d beyond the %d proposed", rversion.MSize, msize)
+	}
+	r.msize = int(rversion.MSize)
+
+	if _, err := r.rpc(ctx, 1, p9p.MessageTattach{
+		Fid:   fid,
+		Afid:  p9p.NOFID,
+		Uname: t.uname(),
+		Aname: t.suite.Aname,
+	}); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *raw) send(ctx context.Context, tag p9p.Tag, msg p9p.Message) error {
+	return r.ch.WriteFcall(ctx, &p9p.Fcall{Type: msg.Type(), Tag: tag, Message: msg})
+}
+
+func (r *raw) recv(ctx context.Context) (*p9p.Fcall, error) {
+	fcall := new(p9p.Fcall)
+	if err := r.ch.ReadFcall(ctx, fcall); err != nil {
+		return nil, err
+	}
+
+	return fcall, nil
+}
+
+// rpc sends msg with tag and waits for its response. Errors from the server
+// are returned as errors.
+func (r *raw) rpc(ctx context.Context, tag p9p.Tag, msg p9p.Message) (p9p.Message, error) {
+	if err := r.send(ctx, tag, msg); err != nil {
+		return nil, err
+	}
+
+	resp, err := r.recv(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Tag != tag {
+		return nil, fmt.Errorf("response %v to %v with unexpected tag", resp, msg.Type())
+	}
+
+	if err, ok := resp.Message.(error); ok {
+		return nil, err
+	}
+
+	return resp.Message, nil
+}
+
+// size returns the size of fcall on the wire.
+func (r *raw) size(fcall *p9p.Fcall) int {
+	return 4 + p9p.NewCodec().Size(fcall)
+}
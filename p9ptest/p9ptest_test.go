@@ -0,0 +1,46 @@
+package p9ptest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	p9p "github.com/docker/go-p9p"
+	"github.com/docker/go-p9p/ufs"
+)
+
+// keepRemoved leaves fids in use after Remove, against remove(5).
+type keepRemoved struct {
+	p9p.Session
+}
+
+func (s keepRemoved) Remove(ctx context.Context, fid p9p.Fid) error {
+	return p9p.ErrNoremove
+}
+
+func TestSuiteViolations(t *testing.T) {
+	root := t.TempDir()
+	suite := NewSuite(Serve(func(ctx context.Context) (p9p.Session, error) {
+		session, err := ufs.NewSession(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		return keepRemoved{session}, nil
+	}))
+	suite.Skip = []string{"flush", "tag", "msize"}
+
+	violations := suite.Run(context.Background())
+	if len(violations) == 0 {
+		t.Fatal("expected violations")
+	}
+
+	for _, v := range violations {
+		if v.Check != "remove" {
+			t.Errorf("unexpected violation: %v", v)
+		}
+	}
+
+	if !strings.Contains(violations[0].String(), "remove: ") {
+		t.Fatalf("unexpected violation: %v", violations[0])
+	}
+}
@@ -0,0 +1,69 @@
+package p9p
+
+import (
+	"context"
+	"net"
+	"os/user"
+	"strconv"
+)
+
+// PeerCredMethod is the method recorded in the AuthInfo of users identified
+// by PeerCredIdentity.
+const PeerCredMethod = "peercred"
+
+// PeerCred holds the credentials of the process on the other end of a unix
+// socket, at the time it connected.
+type PeerCred struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// readPeerCred returns the peer credentials of conn if it is a unix socket
+// and they are supported on the platform.
+func readPeerCred(conn net.Conn) (PeerCred, bool) {
+	uconn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return PeerCred{}, false
+	}
+
+	cred, err := peerCred(uconn)
+	if err != nil {
+		return PeerCred{}, false
+	}
+
+	return cred, true
+}
+
+// PeerCredIdentity returns an IdentityFunc taking the identity from the
+// credentials of the peer of a unix socket. The uid is mapped to a uname with
+// lookup. If lookup is nil, the name of the uid from the system user database
+// is used.
+func PeerCredIdentity(lookup func(uid uint32) (string, error)) IdentityFunc {
+	if lookup == nil {
+		lookup = lookupUID
+	}
+
+	return func(ctx context.Context) (AuthInfo, error) {
+		cred, ok := GetPeerCred(ctx)
+		if !ok {
+			return AuthInfo{}, ErrAuthRequired
+		}
+
+		uname, err := lookup(cred.UID)
+		if err != nil {
+			return AuthInfo{}, ErrAuthFailed
+		}
+
+		return AuthInfo{Uname: uname, Method: PeerCredMethod}, nil
+	}
+}
+
+func lookupUID(uid uint32) (string, error) {
+	u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10))
+	if err != nil {
+		return "", err
+	}
+
+	return u.Username, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package p9p
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCred(conn *net.UnixConn) (PeerCred, error) {
+	return PeerCred{}, errors.New("p9p: peer credentials not supported")
+}
@@ -0,0 +1,73 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestPeerCredIdentity(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "9p.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var (
+		inner = &attachSession{}
+		creds = make(chan PeerCred, 1)
+	)
+
+	lookup := func(uid uint32) (string, error) {
+		return "glenda", nil
+	}
+
+	handler := AttachIdentity(HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+		cred, _ := GetPeerCred(ctx)
+		creds <- cred
+		return Dispatch(inner).Handle(ctx, msg)
+	}), PeerCredIdentity(lookup), IdentityValidate)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		ServeConn(ctx, conn, handler)
+	}()
+
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	session, err := NewSession(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, NOFID, "anyone", ""); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected %v, got %v", ErrAuthFailed, err)
+	}
+
+	if _, err := session.Attach(ctx, 1, NOFID, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cred := <-creds
+	if cred.UID != uint32(os.Getuid()) || cred.PID != int32(os.Getpid()) {
+		t.Fatalf("unexpected peer credentials: %+v", cred)
+	}
+}
@@ -0,0 +1,80 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pipe serves handler over an in-memory connection, returning a session
+// connected to it. It is useful for testing handlers end to end and for
+// serving filesystems in-process. The options configure only the client end,
+// so that WithLatency or WithChannel can be used to inject latency or faults
+// between the session and the handler; the server uses the defaults.
+//
+// The returned function closes the session and waits for the server to stop,
+// returning the error it stopped with, if it wasn't stopped by the session
+// closing. The server and session are also shut down once ctx is cancelled.
+func Pipe(ctx context.Context, handler Handler, opts ...Option) (Session, func() error, error) {
+	cconn, sconn := net.Pipe()
+
+	served := make(chan error, 1)
+	go func() {
+		defer sconn.Close()
+		served <- ServeConn(ctx, sconn, handler)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		cconn.Close()
+	}()
+
+	var (
+		once sync.Once
+		serr error
+	)
+	closer := func() error {
+		once.Do(func() {
+			cconn.Close()
+			serr = <-served
+			if errors.Is(serr, io.EOF) {
+				serr = nil // the session closed the connection
+			}
+		})
+
+		return serr
+	}
+
+	session, err := NewSession(ctx, cconn, opts...)
+	if err != nil {
+		closer()
+		return nil, nil, err
+	}
+
+	return session, closer, nil
+}
+
+// WithLatency delays every message written to the connection by d,
+// simulating a slow network.
+func WithLatency(d time.Duration) Option {
+	return WithChannel(func(ch Channel) Channel {
+		return &latencyChannel{Channel: ch, latency: d}
+	})
+}
+
+// latencyChannel delays writes to the underlying channel.
+type latencyChannel struct {
+	Channel
+	latency time.Duration
+}
+
+func (ch *latencyChannel) WriteFcall(ctx context.Context, fcall *Fcall) error {
+	if err := sleep(ctx, ch.latency); err != nil {
+		return err
+	}
+
+	return ch.Channel.WriteFcall(ctx, fcall)
+}
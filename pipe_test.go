@@ -0,0 +1,80 @@
+package p9p
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	session, closer, err := Pipe(ctx, Dispatch(&rwSession{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msize, version := session.Version(); msize != DefaultMSize || version != DefaultVersion {
+		t.Fatalf("unexpected version: %v %v", msize, version)
+	}
+
+	p := make([]byte, 64)
+	n, err := session.Read(ctx, 1, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(p[:n]) != "secret data" {
+		t.Fatalf("unexpected read: %q", p[:n])
+	}
+
+	if err := closer(); err != nil {
+		t.Fatalf("unexpected server error: %v", err)
+	}
+
+	if _, err := session.Read(context.Background(), 1, p, 0); err == nil {
+		t.Fatal("expected error after shutdown")
+	}
+}
+
+// TestPipeServerError ensures that the error ending the server is returned
+// when the pipe is closed.
+func TestPipeServerError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client sends an invalid frame for the read, ending the server.
+	session, closer, err := Pipe(ctx, Dispatch(&rwSession{}), WithChannel(func(ch Channel) Channel {
+		return &invalidChannel{Channel: ch}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rctx, rcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer rcancel()
+
+	if _, err := session.Read(rctx, 1, make([]byte, 64), 0); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if err := closer(); err == nil || !strings.Contains(err.Error(), "invalid message size") {
+		t.Fatalf("expected invalid message size, got %v", err)
+	}
+}
+
+// invalidChannel writes a frame with an invalid size in place of a Tread.
+type invalidChannel struct {
+	Channel
+}
+
+func (ch *invalidChannel) WriteFcall(ctx context.Context, fcall *Fcall) error {
+	if fcall.Type != Tread {
+		return ch.Channel.WriteFcall(ctx, fcall)
+	}
+
+	_, err := ch.Channel.(*channel).conn.Write(make([]byte, channelMessageHeaderSize))
+	return err
+}
@@ -0,0 +1,87 @@
+package p9p
+
+import (
+	"context"
+	"math/bits"
+	"sync"
+)
+
+// Buffers are pooled in size classes of powers of two, shared across
+// connections. Buffers larger than the largest class are not pooled.
+const (
+	minBufferShift = 9  // 512 bytes
+	maxBufferShift = 24 // 16MB
+)
+
+var bufferPools [maxBufferShift - minBufferShift + 1]sync.Pool
+
+// bufferClass returns the index of the smallest size class holding n bytes.
+func bufferClass(n int) int {
+	if n <= 1<<minBufferShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - minBufferShift
+}
+
+// getbuf returns a buffer of n bytes from the pool. Its contents are
+// undefined.
+func getbuf(n int) []byte {
+	class := bufferClass(n)
+	if class >= len(bufferPools) {
+		return make([]byte, n)
+	}
+
+	if p, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*p)[:n]
+	}
+
+	return make([]byte, n, 1<<uint(class+minBufferShift))
+}
+
+// putbuf returns a buffer from getbuf to the pool. The buffer must not be
+// used after the call.
+func putbuf(p []byte) {
+	class := bufferClass(cap(p))
+	if class >= len(bufferPools) || cap(p) != 1<<uint(class+minBufferShift) {
+		return // not from the pool
+	}
+
+	p = p[:0]
+	bufferPools[class].Put(&p)
+}
+
+// buffers tracks the buffers borrowed while handling a request, to be
+// released once its response is written. It is not safe for concurrent use.
+type buffers struct {
+	bufs [][]byte
+}
+
+func (b *buffers) get(n int) []byte {
+	p := getbuf(n)
+	b.bufs = append(b.bufs, p)
+	return p
+}
+
+// keep adds p, from getbuf, to the buffers to release.
+func (b *buffers) keep(p []byte) {
+	b.bufs = append(b.bufs, p)
+}
+
+func (b *buffers) release() {
+	for _, p := range b.bufs {
+		putbuf(p)
+	}
+	b.bufs = nil
+}
+
+// borrow returns a buffer of n bytes for the response to the request being
+// handled with ctx. When served by ServeConn, the buffer comes from the pool
+// and is released after the response is written, so it must not be retained
+// past the response. Otherwise, it is allocated.
+func borrow(ctx context.Context, n int) []byte {
+	b, ok := ctx.Value(buffersKey).(*buffers)
+	if !ok {
+		return make([]byte, n)
+	}
+	return b.get(n)
+}
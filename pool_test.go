@@ -0,0 +1,51 @@
+package p9p
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	for _, testcase := range []struct {
+		n   int
+		cap int
+	}{
+		{0, 512},
+		{1, 512},
+		{512, 512},
+		{513, 1024},
+		{DefaultMSize, DefaultMSize},
+		{DefaultMSize + 1, 2 * DefaultMSize},
+		{1<<maxBufferShift + 1, 1<<maxBufferShift + 1}, // not pooled
+	} {
+		p := getbuf(testcase.n)
+		if len(p) != testcase.n || cap(p) != testcase.cap {
+			t.Fatalf("getbuf(%v): len %v, cap %v, expected cap %v", testcase.n, len(p), cap(p), testcase.cap)
+		}
+		putbuf(p)
+	}
+
+	// buffers not from the pool are ignored.
+	putbuf(make([]byte, 600))
+	if p := getbuf(600); cap(p) != 1024 {
+		t.Fatalf("pooled foreign buffer of cap %v", cap(p))
+	}
+}
+
+func TestBorrow(t *testing.T) {
+	if p := borrow(context.Background(), 600); len(p) != 600 {
+		t.Fatalf("unexpected length: %v", len(p))
+	}
+
+	var bufs buffers
+	ctx := withBuffers(context.Background(), &bufs)
+	p := borrow(ctx, 600)
+	if len(p) != 600 || cap(p) != 1024 || len(bufs.bufs) != 1 {
+		t.Fatalf("buffer not borrowed from the pool: len %v, cap %v", len(p), cap(p))
+	}
+
+	bufs.release()
+	if len(bufs.bufs) != 0 {
+		t.Fatalf("buffers not released: %v", len(bufs.bufs))
+	}
+}
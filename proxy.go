@@ -0,0 +1,365 @@
+package p9p
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// proxyClunkTimeout bounds the clunks sent upstream for abandoned fids.
+const proxyClunkTimeout = 10 * time.Second
+
+// Proxy multiplexes many downstream connections onto a single upstream
+// session, typically a client returned by NewSession. Each downstream
+// connection has its own fid space, which the proxy maps onto fids
+// allocated on the upstream session, so connections may use the same fids
+// without colliding.
+//
+// Flushes from downstream cancel the context of the proxied request, which
+// flushes it upstream when the upstream is a client session. When a
+// downstream connection ends, its fids are clunked upstream.
+type Proxy struct {
+	upstream Session
+	dispatch Handler
+
+	mu   sync.Mutex
+	next Fid
+	used map[Fid]struct{} // upstream fids
+}
+
+// NewProxy returns a proxy onto the upstream session.
+func NewProxy(upstream Session) *Proxy {
+	return &Proxy{
+		upstream: upstream,
+		dispatch: Dispatch(upstream),
+		used:     make(map[Fid]struct{}),
+	}
+}
+
+// ServeConn serves a downstream connection through the proxy until it is
+// closed, then clunks its remaining fids upstream.
+func (p *Proxy) ServeConn(ctx context.Context, conn net.Conn, opts ...Option) error {
+	h := p.Handler()
+	defer h.Close()
+
+	return ServeConn(ctx, conn, h, opts...)
+}
+
+// Handler returns a handler for a new downstream connection. The handler
+// must be closed when the connection ends.
+func (p *Proxy) Handler() *ProxyHandler {
+	return &ProxyHandler{
+		proxy: p,
+		fids:  make(map[Fid]Fid),
+	}
+}
+
+// allocate reserves an unused upstream fid.
+func (p *Proxy) allocate() (Fid, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if uint64(len(p.used)) >= uint64(NOFID) {
+		return NOFID, ErrNomem
+	}
+
+	for {
+		fid := p.next
+		p.next++
+		if p.next == NOFID {
+			p.next = 0
+		}
+
+		if _, ok := p.used[fid]; !ok && fid != NOFID {
+			p.used[fid] = struct{}{}
+			return fid, nil
+		}
+	}
+}
+
+// release returns upstream fids for reuse.
+func (p *Proxy) release(fids ...Fid) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, fid := range fids {
+		delete(p.used, fid)
+	}
+}
+
+// abandon clunks an upstream fid in the background, releasing it once the
+// upstream no longer holds it.
+func (p *Proxy) abandon(fid Fid) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), proxyClunkTimeout)
+		defer cancel()
+
+		if err := p.upstream.Clunk(ctx, fid); err != nil && ctx.Err() != nil {
+			// the upstream may still hold the fid, so it is never reused.
+			return
+		}
+
+		p.release(fid)
+	}()
+}
+
+// ProxyHandler handles the messages of a downstream connection of a Proxy.
+type ProxyHandler struct {
+	proxy *Proxy
+
+	mu     sync.Mutex
+	fids   map[Fid]Fid // downstream to upstream, NOFID while reserved
+	closed bool
+}
+
+var _ Handler = &ProxyHandler{}
+
+// Handle maps the fids of msg to upstream fids and sends it upstream.
+func (h *ProxyHandler) Handle(ctx context.Context, msg Message) (Message, error) {
+	switch msg := msg.(type) {
+	case MessageTauth:
+		return h.create(ctx, msg.Afid, func(afid Fid) Message {
+			msg.Afid = afid
+			return msg
+		})
+	case MessageTattach:
+		afid, err := h.lookupOrNOFID(msg.Afid)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.create(ctx, msg.Fid, func(fid Fid) Message {
+			msg.Fid, msg.Afid = fid, afid
+			return msg
+		})
+	case MessageTwalk:
+		fid, err := h.lookup(msg.Fid)
+		if err != nil {
+			return nil, err
+		}
+
+		if msg.Newfid == msg.Fid {
+			msg.Fid, msg.Newfid = fid, fid
+			return h.proxy.dispatch.Handle(ctx, msg)
+		}
+
+		return h.create(ctx, msg.Newfid, func(newfid Fid) Message {
+			msg.Fid, msg.Newfid = fid, newfid
+			return msg
+		})
+	case MessageTxattrwalk:
+		fid, err := h.lookup(msg.Fid)
+		if err != nil {
+			return nil, err
+		}
+
+		return h.create(ctx, msg.Newfid, func(newfid Fid) Message {
+			msg.Fid, msg.Newfid = fid, newfid
+			return msg
+		})
+	case MessageTclunk:
+		return h.destroy(ctx, msg.Fid, func(fid Fid) Message {
+			msg.Fid = fid
+			return msg
+		})
+	case MessageTremove:
+		return h.destroy(ctx, msg.Fid, func(fid Fid) Message {
+			msg.Fid = fid
+			return msg
+		})
+	}
+
+	// the remaining messages operate on a single fid.
+	var err error
+	switch m := msg.(type) {
+	case MessageTopen:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTcreate:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTread:
+		m.Fid, err = h.lookup(m.Fid)
+		if limit := h.iounit(); m.Count > limit {
+			m.Count = limit
+		}
+		msg = m
+	case MessageTwrite:
+		m.Fid, err = h.lookup(m.Fid)
+		if limit := h.iounit(); uint32(len(m.Data)) > limit {
+			// the short write is retried by the downstream client.
+			m.Data = m.Data[:limit]
+		}
+		msg = m
+	case MessageTstat:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTwstat:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTstatfs:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTgetattr:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTxattrcreate:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTfsync:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTlock:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	case MessageTgetlock:
+		m.Fid, err = h.lookup(m.Fid)
+		msg = m
+	default:
+		return nil, ErrUnknownMsg
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return h.proxy.dispatch.Handle(ctx, msg)
+}
+
+// iounit returns the largest count of a read or write upstream.
+func (h *ProxyHandler) iounit() uint32 {
+	msize, _ := h.proxy.upstream.Version()
+	return uint32(msize - IOHDRSZ)
+}
+
+// lookup returns the upstream fid of the downstream fid.
+func (h *ProxyHandler) lookup(fid Fid) (Fid, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ufid, ok := h.fids[fid]
+	if !ok || ufid == NOFID {
+		return NOFID, ErrUnknownfid
+	}
+
+	return ufid, nil
+}
+
+func (h *ProxyHandler) lookupOrNOFID(fid Fid) (Fid, error) {
+	if fid == NOFID {
+		return NOFID, nil
+	}
+
+	return h.lookup(fid)
+}
+
+// create sends the message built by fn for a new downstream fid, mapping it
+// to a newly allocated upstream fid if the request succeeds.
+func (h *ProxyHandler) create(ctx context.Context, fid Fid, fn func(ufid Fid) Message) (Message, error) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+
+	if _, ok := h.fids[fid]; ok {
+		h.mu.Unlock()
+		return nil, ErrDupfid
+	}
+	h.fids[fid] = NOFID // reserved until the response
+	h.mu.Unlock()
+
+	ufid, err := h.proxy.allocate()
+	if err != nil {
+		h.forget(fid)
+		return nil, err
+	}
+
+	msg := fn(ufid)
+	resp, err := h.proxy.dispatch.Handle(ctx, msg)
+	if err == nil {
+		if rwalk, ok := resp.(MessageRwalk); ok && len(rwalk.Qids) < len(msg.(MessageTwalk).Wnames) {
+			// a partial walk does not create newfid.
+			h.forget(fid)
+			h.proxy.release(ufid)
+			return resp, nil
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// the request was flushed, perhaps after it created the fid.
+		delete(h.fids, fid)
+		h.proxy.abandon(ufid)
+	case err != nil:
+		delete(h.fids, fid)
+		h.proxy.release(ufid)
+	case h.closed:
+		// the connection ended while the fid was created.
+		h.proxy.abandon(ufid)
+	default:
+		h.fids[fid] = ufid
+	}
+
+	return resp, err
+}
+
+// destroy sends the message built by fn for a downstream fid that is
+// removed by the request.
+func (h *ProxyHandler) destroy(ctx context.Context, fid Fid, fn func(ufid Fid) Message) (Message, error) {
+	ufid, err := h.lookup(fid)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.proxy.dispatch.Handle(ctx, fn(ufid))
+
+	// clunk and remove make the fid invalid, even when they fail.
+	h.mu.Lock()
+	if h.fids[fid] == ufid {
+		delete(h.fids, fid)
+		if err != nil && ctx.Err() != nil {
+			// the request was flushed, perhaps before it reached the
+			// upstream.
+			h.proxy.abandon(ufid)
+		} else {
+			h.proxy.release(ufid)
+		}
+	}
+	h.mu.Unlock()
+
+	return resp, err
+}
+
+// forget removes a reserved downstream fid.
+func (h *ProxyHandler) forget(fid Fid) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.fids, fid)
+}
+
+// Close clunks the upstream fids of the downstream connection in the
+// background. Requests still in progress that create fids have them clunked
+// once they complete.
+func (h *ProxyHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+
+	for fid, ufid := range h.fids {
+		if ufid != NOFID {
+			h.proxy.abandon(ufid)
+		}
+		delete(h.fids, fid)
+	}
+
+	return nil
+}
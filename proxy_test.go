@@ -0,0 +1,230 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fidSession tracks the fids held on it and blocks reads until they are
+// cancelled.
+type fidSession struct {
+	Session
+
+	mu       sync.Mutex
+	fids     map[Fid]bool
+	clunkErr error // returned by clunks, which still remove the fid
+	reading  chan struct{}
+	flushed  chan struct{}
+}
+
+func newFidSession() *fidSession {
+	return &fidSession{
+		fids:    map[Fid]bool{},
+		reading: make(chan struct{}, 1),
+		flushed: make(chan struct{}, 1),
+	}
+}
+
+func (s *fidSession) add(fid Fid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fids[fid] {
+		return ErrDupfid
+	}
+	s.fids[fid] = true
+	return nil
+}
+
+func (s *fidSession) held() map[Fid]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fids := map[Fid]bool{}
+	for fid := range s.fids {
+		fids[fid] = true
+	}
+	return fids
+}
+
+func (s *fidSession) Attach(ctx context.Context, fid, afid Fid, uname, aname string) (Qid, error) {
+	return Qid{Type: QTDIR}, s.add(fid)
+}
+
+func (s *fidSession) Walk(ctx context.Context, fid Fid, newfid Fid, names ...string) ([]Qid, error) {
+	if !s.held()[fid] {
+		return nil, ErrUnknownfid
+	}
+
+	if newfid != fid {
+		if err := s.add(newfid); err != nil {
+			return nil, err
+		}
+	}
+
+	return make([]Qid, len(names)), nil
+}
+
+func (s *fidSession) Clunk(ctx context.Context, fid Fid) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.fids[fid] {
+		return ErrUnknownfid
+	}
+	delete(s.fids, fid)
+	return s.clunkErr
+}
+
+func (s *fidSession) Read(ctx context.Context, fid Fid, p []byte, offset int64) (int, error) {
+	s.reading <- struct{}{}
+	<-ctx.Done()
+	s.flushed <- struct{}{}
+	return 0, ctx.Err()
+}
+
+func (s *fidSession) Version() (int, string) {
+	return DefaultMSize, DefaultVersion
+}
+
+func TestProxy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := newFidSession()
+	uconn, bconn := net.Pipe()
+	go ServeConn(ctx, bconn, Dispatch(backend))
+
+	upstream, err := NewSession(ctx, uconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxy(upstream)
+	connect := func() (Session, net.Conn) {
+		cconn, pconn := net.Pipe()
+		go proxy.ServeConn(ctx, pconn)
+
+		session, err := NewSession(ctx, cconn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session, cconn
+	}
+
+	first, firstConn := connect()
+	second, _ := connect()
+
+	// both connections use the same fids.
+	for _, session := range []Session{first, second} {
+		if _, err := session.Attach(ctx, 1, NOFID, "glenda", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := session.Walk(ctx, 1, 2, "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := first.Attach(ctx, 1, NOFID, "glenda", ""); !errors.Is(err, ErrDupfid) {
+		t.Fatalf("expected %v, got %v", ErrDupfid, err)
+	}
+
+	if _, err := first.Walk(ctx, 5, 6); !errors.Is(err, ErrUnknownfid) {
+		t.Fatalf("expected %v, got %v", ErrUnknownfid, err)
+	}
+
+	if held := backend.held(); len(held) != 4 {
+		t.Fatalf("expected 4 upstream fids, got %v", held)
+	}
+
+	if err := second.Clunk(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if held := backend.held(); len(held) != 3 {
+		t.Fatalf("expected 3 upstream fids, got %v", held)
+	}
+
+	t.Run("Flush", func(t *testing.T) {
+		rctx, rcancel := context.WithCancel(ctx)
+		errs := make(chan error, 1)
+		go func() {
+			_, err := second.Read(rctx, 1, make([]byte, 16), 0)
+			errs <- err
+		}()
+
+		<-backend.reading
+		rcancel()
+
+		select {
+		case <-backend.flushed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("read was not flushed upstream")
+		}
+
+		if err := <-errs; err != context.Canceled {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		firstConn.Close()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for len(backend.held()) != 1 {
+			if time.Now().After(deadline) {
+				t.Fatalf("fids of closed connection not clunked: %v", backend.held())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		// the remaining connection is unaffected.
+		if _, err := second.Walk(ctx, 1, 2); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestProxyClunkError ensures that a failed clunk removes the fid, as the
+// upstream has removed it too.
+func TestProxyClunkError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := newFidSession()
+	backend.clunkErr = errors.New("clunk failed")
+
+	proxy := NewProxy(backend)
+	h := proxy.Handler()
+	defer h.Close()
+
+	if _, err := h.Handle(ctx, MessageTattach{Fid: 1, Afid: NOFID, Uname: "glenda"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.Handle(ctx, MessageTclunk{Fid: 1}); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := h.Handle(ctx, MessageTclunk{Fid: 1}); !errors.Is(err, ErrUnknownfid) {
+		t.Fatalf("expected %v clunking again, got %v", ErrUnknownfid, err)
+	}
+
+	proxy.mu.Lock()
+	used := len(proxy.used)
+	proxy.mu.Unlock()
+
+	if used != 0 {
+		t.Fatalf("expected the upstream fid to be released, %v in use", used)
+	}
+
+	// the downstream fid may be used again.
+	if _, err := h.Handle(ctx, MessageTattach{Fid: 1, Afid: NOFID, Uname: "glenda"}); err != nil {
+		t.Fatal(err)
+	}
+}
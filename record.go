@@ -0,0 +1,451 @@
+package p9p
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Recordings hold the fcalls passing through a channel, in the order they
+// were read or written. The format is a header followed by records:
+//
+//	header  magic[8] start[8]
+//	record  direction[1] delta[uvarint] size[4] type[1] tag[2] body
+//
+// The start time is in nanoseconds since the Unix epoch and the delta of each
+// record is in nanoseconds since the previous record, or the start. Each
+// fcall is in the same form as on the wire, including its size. Like on the
+// wire, the fcalls following an Rversion are in the dialect it negotiated,
+// such as 9P2000.u.
+
+var recordMagic = [8]byte{'9', 'P', 'R', 'E', 'C', 0, 0, 1}
+
+// maxRecordSize limits the size of fcalls read from recordings.
+const maxRecordSize = 64 << 20
+
+// Direction is the direction of a recorded fcall, from the point of view of
+// the recording channel.
+type Direction uint8
+
+const (
+	Received Direction = iota // read from the channel
+	Sent                      // written to the channel
+)
+
+func (d Direction) String() string {
+	switch d {
+	case Received:
+		return "received"
+	case Sent:
+		return "sent"
+	}
+
+	return "unknown"
+}
+
+// Record is an fcall read from a recording.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Fcall     *Fcall
+}
+
+func (r Record) String() string {
+	return fmt.Sprintf("%v %v %v", r.Time.Format(time.RFC3339Nano), r.Direction, r.Fcall)
+}
+
+// RecordWriter writes a recording. It is safe for concurrent use.
+type RecordWriter struct {
+	mu    sync.Mutex
+	wr    *bufio.Writer
+	codec Codec
+	last  time.Time
+	err   error // first error writing the recording
+}
+
+// NewRecordWriter starts a recording on wr.
+func NewRecordWriter(wr io.Writer) *RecordWriter {
+	rw := &RecordWriter{
+		wr:    bufio.NewWriter(wr),
+		codec: NewCodec(),
+		last:  time.Now(),
+	}
+
+	rw.wr.Write(recordMagic[:])
+	rw.err = binary.Write(rw.wr, binary.LittleEndian, rw.last.UnixNano())
+
+	return rw
+}
+
+// Write records fcall, timestamped with the current time. Once writing
+// fails, all further writes return the same error.
+func (rw *RecordWriter) Write(dir Direction, fcall *Fcall) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.err != nil {
+		return rw.err
+	}
+
+	p, err := rw.codec.Marshal(fcall)
+	if err != nil {
+		rw.err = err
+		return err
+	}
+
+	now := time.Now()
+	delta := now.Sub(rw.last)
+	if delta < 0 {
+		delta = 0
+	}
+	rw.last = now
+
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = byte(dir)
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(delta))
+
+	if _, err := rw.wr.Write(hdr[:n]); err != nil {
+		rw.err = err
+		return err
+	}
+
+	if err := sendmsg(rw.wr, p); err != nil {
+		rw.err = err
+		return err
+	}
+
+	if rversion, ok := fcall.Message.(MessageRversion); ok {
+		rw.codec = NewVersionCodec(rversion.Version)
+	}
+
+	// flush each record, so the recording survives a crash.
+	rw.err = rw.wr.Flush()
+	return rw.err
+}
+
+// Err returns the first error encountered writing the recording.
+func (rw *RecordWriter) Err() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.err
+}
+
+// Recorder is a Channel recording each fcall read from and written to
+// another channel. Use it with WithChannel to record the conversations of
+// ServeConn or NewSession:
+//
+//	p9p.WithChannel(func(ch p9p.Channel) p9p.Channel {
+//		return p9p.NewRecorder(ch, f)
+//	})
+type Recorder struct {
+	Channel
+	rw *RecordWriter
+}
+
+var _ Channel = &Recorder{}
+
+// NewRecorder returns a channel recording the fcalls of ch to wr. Writing
+// the recording never fails operations on the channel. Use Err to check that
+// the recording is complete.
+func NewRecorder(ch Channel, wr io.Writer) *Recorder {
+	return &Recorder{
+		Channel: ch,
+		rw:      NewRecordWriter(wr),
+	}
+}
+
+// ReadFcall reads from the channel, recording the fcall on success.
+func (r *Recorder) ReadFcall(ctx context.Context, fcall *Fcall) error {
+	if err := r.Channel.ReadFcall(ctx, fcall); err != nil {
+		return err
+	}
+
+	r.rw.Write(Received, fcall)
+	return nil
+}
+
+// WriteFcall records the fcall and writes it to the channel. The fcall is
+// recorded before it is written, so that any response from the peer is
+// recorded after it.
+func (r *Recorder) WriteFcall(ctx context.Context, fcall *Fcall) error {
+	r.rw.Write(Sent, fcall)
+	return r.Channel.WriteFcall(ctx, fcall)
+}
+
+// Err returns the first error encountered writing the recording.
+func (r *Recorder) Err() error {
+	return r.rw.Err()
+}
+
+// ReadRecords reads all the records from a recording.
+func ReadRecords(rd io.Reader) ([]Record, error) {
+	var (
+		br    = bufio.NewReader(rd)
+		magic [8]byte
+		start int64
+		codec = NewCodec()
+	)
+
+	if _, err := io.ReadFull(br, magic[:]); err != nil {
+		return nil, err
+	}
+
+	if magic != recordMagic {
+		return nil, errors.New("p9p: not a recording")
+	}
+
+	if err := binary.Read(br, binary.LittleEndian, &start); err != nil {
+		return nil, err
+	}
+
+	var (
+		records []Record
+		t       = time.Unix(0, start)
+	)
+
+	for {
+		dir, err := br.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return records, err
+		}
+
+		delta, err := binary.ReadUvarint(br)
+		if err != nil {
+			return records, io.ErrUnexpectedEOF
+		}
+		t = t.Add(time.Duration(delta))
+
+		var size uint32
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return records, io.ErrUnexpectedEOF
+		}
+
+		if size < 4 || size > maxRecordSize {
+			return records, fmt.Errorf("p9p: invalid record size %v", size)
+		}
+
+		p := make([]byte, size-4)
+		if _, err := io.ReadFull(br, p); err != nil {
+			return records, io.ErrUnexpectedEOF
+		}
+
+		fcall := new(Fcall)
+		if err := codec.Unmarshal(p, fcall); err != nil {
+			return records, err
+		}
+
+		if rversion, ok := fcall.Message.(MessageRversion); ok {
+			codec = NewVersionCodec(rversion.Version)
+		}
+
+		records = append(records, Record{Time: t, Direction: Direction(dir), Fcall: fcall})
+	}
+}
+
+// exchange pairs a recorded request with its response, which is nil if the
+// request was flushed or the recording ended first.
+type exchange struct {
+	req, resp *Fcall
+}
+
+// exchanges pairs the requests and responses of records by tag, in the order
+// of the requests. Trace annotations are dropped.
+func exchanges(records []Record) []*exchange {
+	var (
+		exs     []*exchange
+		pending = map[Tag]*exchange{}
+	)
+
+	for _, record := range records {
+		fcall := record.Fcall
+		switch {
+		case fcall.Type == Ttrace:
+		case isRequest(fcall.Type):
+			ex := &exchange{req: fcall}
+			exs = append(exs, ex)
+			pending[fcall.Tag] = ex
+
+			if tflush, ok := fcall.Message.(MessageTflush); ok {
+				delete(pending, tflush.Oldtag)
+			}
+		default:
+			if ex, ok := pending[fcall.Tag]; ok {
+				ex.resp = fcall
+				delete(pending, fcall.Tag)
+			}
+		}
+	}
+
+	return exs
+}
+
+// isRequest returns true for the types of T-messages, which are even.
+func isRequest(t FcallType) bool {
+	return t%2 == 0
+}
+
+// CompareFunc compares the response to a replayed request with the recorded
+// response, returning an error if they differ.
+type CompareFunc func(req, want, got *Fcall) error
+
+// CompareExact requires the responses of a replay to be identical to the
+// recording.
+func CompareExact(req, want, got *Fcall) error {
+	if !reflect.DeepEqual(want.Message, got.Message) {
+		return fmt.Errorf("p9p: replay of %v: expected %v, got %v", req, want, got)
+	}
+
+	return nil
+}
+
+// Replay sends each request in records to handler, in order, comparing the
+// responses to those recorded with compare. If compare is nil, CompareExact
+// is used. Version negotiation and flushes are skipped, since they are
+// handled by the connection rather than the handler. Requests without a
+// recorded response are sent but not compared.
+//
+// Records from either side of a connection may be replayed, since requests
+// are told from responses by their type.
+func Replay(ctx context.Context, records []Record, handler Handler, compare CompareFunc) error {
+	if compare == nil {
+		compare = CompareExact
+	}
+
+	version := DefaultVersion
+	for _, ex := range exchanges(records) {
+		switch ex.req.Message.(type) {
+		case MessageTversion:
+			// handled by the server connection, not the handler, though it
+			// decides the dialect of the responses.
+			if ex.resp != nil {
+				if rversion, ok := ex.resp.Message.(MessageRversion); ok {
+					version = rversion.Version
+				}
+			}
+			continue
+		case MessageTflush:
+			continue
+		}
+
+		resp, err := handler.Handle(ctx, ex.req.Message)
+		if ex.resp == nil {
+			continue
+		}
+
+		var got *Fcall
+		if err != nil {
+			got = newVersionErrorFcall(version, ex.req.Tag, err)
+		} else {
+			got = newFcall(ex.req.Tag, resp)
+		}
+
+		// normalize the response through the codec, as if it was sent.
+		if err := roundtrip(NewVersionCodec(version), got); err != nil {
+			return err
+		}
+
+		if err := compare(ex.req, ex.resp, got); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// roundtrip marshals and unmarshals fcall with codec, such that it matches
+// one read from the wire.
+func roundtrip(codec Codec, fcall *Fcall) error {
+	p, err := codec.Marshal(fcall)
+	if err != nil {
+		return err
+	}
+
+	*fcall = Fcall{}
+	return codec.Unmarshal(p, fcall)
+}
+
+// ReplayServer fakes a server on conn from records, responding to each
+// request from the client with the recorded response. Requests are matched
+// with the first unanswered recorded request with the same message,
+// regardless of tag, or failing that, the same type. Requests without a match
+// get an error. ReplayServer returns when the client closes the connection.
+func ReplayServer(ctx context.Context, conn net.Conn, records []Record) error {
+	var (
+		ch  = newChannel(conn, NewCodec(), DefaultMSize)
+		exs = exchanges(records)
+	)
+
+	for {
+		req := new(Fcall)
+		if err := ch.ReadFcall(ctx, req); err != nil {
+			if err == io.EOF || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		var resp *Fcall
+		switch req.Message.(type) {
+		case MessageTtrace:
+			continue
+		case MessageTflush:
+			resp = newFcall(req.Tag, MessageRflush{})
+		default:
+			ex := matchExchange(exs, req)
+			if ex == nil {
+				resp = newErrorFcall(req.Tag, fmt.Errorf("replay: unexpected request %v", req))
+				break
+			}
+
+			resp = &Fcall{Type: ex.resp.Type, Tag: req.Tag, Message: ex.resp.Message}
+		}
+
+		if err := ch.WriteFcall(ctx, resp); err != nil {
+			return err
+		}
+
+		if rversion, ok := resp.Message.(MessageRversion); ok {
+			if int(rversion.MSize) < ch.MSize() {
+				ch.SetMSize(int(rversion.MSize))
+			}
+			ch.codec = NewVersionCodec(rversion.Version)
+		}
+	}
+}
+
+// matchExchange finds and consumes the exchange answering req.
+func matchExchange(exs []*exchange, req *Fcall) *exchange {
+	match := -1
+	for i, ex := range exs {
+		if ex == nil || ex.resp == nil || ex.req.Type != req.Type {
+			continue
+		}
+
+		if reflect.DeepEqual(ex.req.Message, req.Message) {
+			match = i
+			break
+		}
+
+		if match < 0 {
+			match = i
+		}
+	}
+
+	if match < 0 {
+		return nil
+	}
+
+	ex := exs[match]
+	exs[match] = nil
+	return ex
+}
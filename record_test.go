@@ -0,0 +1,197 @@
+package p9p
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// converse runs a short conversation with session.
+func converse(t *testing.T, ctx context.Context, session Session) []byte {
+	t.Helper()
+
+	if _, err := session.Attach(ctx, 1, NOFID, "glenda", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 16)
+	n, err := session.Read(ctx, 1, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := session.Clunk(ctx, 1); !errors.Is(err, ErrUnknownfid) {
+		t.Fatalf("expected %v, got %v", ErrUnknownfid, err)
+	}
+
+	return p[:n]
+}
+
+func TestRecordReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		buf      bytes.Buffer
+		recorder *Recorder
+	)
+
+	cconn, sconn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(ctx, sconn, Dispatch(&rwSession{}), WithChannel(func(ch Channel) Channel {
+			recorder = NewRecorder(ch, &buf)
+			return recorder
+		}))
+	}()
+
+	session, err := NewSession(ctx, cconn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converse(t, ctx, session)
+	cconn.Close()
+	<-done
+
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		dir Direction
+		typ FcallType
+	}{
+		{Received, Tversion}, {Sent, Rversion},
+		{Received, Tattach}, {Sent, Rattach},
+		{Received, Tread}, {Sent, Rread},
+		{Received, Tclunk}, {Sent, Rerror},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("unexpected records:\n%v", records)
+	}
+
+	for i, record := range records {
+		if record.Direction != expected[i].dir || record.Fcall.Type != expected[i].typ {
+			t.Fatalf("record %d: unexpected %v", i, record)
+		}
+
+		if i > 0 && record.Time.Before(records[i-1].Time) {
+			t.Fatalf("record %d: time went backwards: %v", i, record)
+		}
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		if err := Replay(ctx, records, Dispatch(&rwSession{}), nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// a handler that has regressed fails the replay.
+		broken := HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+			if _, ok := msg.(MessageTread); ok {
+				return MessageRread{Data: []byte("other data")}, nil
+			}
+			return Dispatch(&rwSession{}).Handle(ctx, msg)
+		})
+
+		if err := Replay(ctx, records, broken, nil); err == nil {
+			t.Fatal("expected replay to fail")
+		}
+	})
+
+	t.Run("Server", func(t *testing.T) {
+		cconn, sconn := net.Pipe()
+		defer cconn.Close()
+
+		go ReplayServer(ctx, sconn, records)
+
+		session, err := NewSession(ctx, cconn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if data := converse(t, ctx, session); string(data) != "secret data" {
+			t.Fatalf("unexpected data: %q", data)
+		}
+	})
+}
+
+func TestRecordReplayUnix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		buf      bytes.Buffer
+		recorder *Recorder
+		session  = &errnoSession{dir: t.TempDir()}
+	)
+
+	cconn, sconn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ServeConn(ctx, sconn, Dispatch(session), WithUnix(), WithChannel(func(ch Channel) Channel {
+			recorder = NewRecorder(ch, &buf)
+			return recorder
+		}))
+	}()
+
+	client, err := NewSession(ctx, cconn, WithUnix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Walk(ctx, 1, 2, "file"); !errors.Is(err, syscall.ENOENT) {
+		t.Fatalf("expected %v, got %v", syscall.ENOENT, err)
+	}
+	cconn.Close()
+	<-done
+
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := ReadRecords(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the errno is only kept when the records are read in 9P2000.u.
+	last := records[len(records)-1]
+	if rerr, ok := last.Fcall.Message.(MessageRerror); !ok || rerr.Errno != uint32(syscall.ENOENT) {
+		t.Fatalf("expected errno in the recording: %v", last)
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		if err := Replay(ctx, records, Dispatch(session), nil); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Server", func(t *testing.T) {
+		cconn, sconn := net.Pipe()
+		defer cconn.Close()
+
+		go ReplayServer(ctx, sconn, records)
+
+		client, err := NewSession(ctx, cconn, WithUnix())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = client.Walk(ctx, 1, 2, "file")
+		var rerr MessageRerror
+		if !errors.As(err, &rerr) || rerr.Errno != uint32(syscall.ENOENT) {
+			t.Fatalf("expected errno in the replay: %#v", err)
+		}
+	})
+}
@@ -2,7 +2,6 @@ package p9p
 
 import (
 	"fmt"
-	"log"
 	"net"
 	"sync"
 	"time"
@@ -15,7 +14,30 @@ import (
 // servers.
 
 // ServeConn the 9p handler over the provided network connection.
-func ServeConn(ctx context.Context, cn net.Conn, handler Handler) error {
+//
+// If cn is a tls.Conn, the handshake is completed before negotiating the
+// version and the connection state is available to the handler through
+// GetTLSState. For unix sockets, the credentials of the peer are available
+// through GetPeerCred.
+func ServeConn(ctx context.Context, cn net.Conn, handler Handler, opts ...Option) error {
+	o := newOptions(opts)
+
+	state, err := handshake(ctx, cn)
+	if err != nil {
+		return fmt.Errorf("error in tls handshake: %v", err)
+	}
+
+	if state != nil {
+		ctx = withTLSState(ctx, state)
+	}
+
+	if cred, ok := readPeerCred(cn); ok {
+		ctx = withPeerCred(ctx, cred)
+	}
+
+	if _, ok := o.metrics.(nopMetrics); !ok {
+		cn = &meteredConn{Conn: cn, metrics: o.metrics}
+	}
 
 	// TODO(stevvooe): It would be nice if the handler could declare the
 	// supported version. Before we had handler, we used the session to get
@@ -23,7 +45,8 @@ func ServeConn(ctx context.Context, cn net.Conn, handler Handler) error {
 	// we want to proxy version and message size decisions all the back to the
 	// origin server or make those decisions at each link of a proxy chain.
 
-	ch := newChannel(cn, codec9p{}, DefaultMSize)
+	base := newChannel(cn, codec9p{}, DefaultMSize)
+	ch := o.channel(base)
 	negctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
@@ -31,29 +54,56 @@ func ServeConn(ctx context.Context, cn net.Conn, handler Handler) error {
 	// do this outside of this function and then pass in a ready made channel.
 	// We are not really ready to export the channel type yet.
 
-	if err := servernegotiate(negctx, ch, DefaultVersion); err != nil {
+	versions := []string{DefaultVersion}
+	if o.propagator != nil {
+		versions = append(versions, TraceVersion)
+	}
+	if o.unix {
+		versions = append(versions, UnixVersion)
+	}
+
+	version, err := servernegotiate(negctx, ch, versions...)
+	if err != nil {
 		// TODO(stevvooe): Need better error handling and retry support here.
 		return fmt.Errorf("error negotiating version: %s", err)
 	}
 
-	ctx = withVersion(ctx, DefaultVersion)
+	if version == UnixVersion {
+		base.codec = codec9p{dotu: true}
+	}
+
+	ctx = withVersion(ctx, version)
+	ctx = withMSize(ctx, ch.MSize())
+	o.metrics.Negotiated(ch.MSize(), version)
 
 	c := &conn{
 		ctx:     ctx,
+		version: version,
 		ch:      ch,
 		handler: handler,
+		metrics: o.metrics,
 		closed:  make(chan struct{}),
 	}
 
+	if version == TraceVersion {
+		c.propagator = o.propagator
+	}
+
 	return c.serve()
 }
 
 // conn plays role of session dispatch for handler in a server.
 type conn struct {
 	ctx     context.Context
+	version string
 	session Session
 	ch      Channel
 	handler Handler
+	metrics Metrics
+	fids    int // live fids, only accessed by the serve loop
+
+	// propagator is set if trace context is carried on the connection.
+	propagator Propagator
 
 	once   sync.Once
 	closed chan struct{}
@@ -65,15 +115,30 @@ type activeRequest struct {
 	ctx     context.Context
 	request *Fcall
 	cancel  context.CancelFunc
+	start   time.Time
+}
+
+// response is an fcall for the write loop. If set, release is called once the
+// fcall is written or dropped, returning buffers borrowed by the handler.
+type response struct {
+	fcall   *Fcall
+	request *Fcall // the request answered, for responses from handlers
+	release func()
 }
 
 // serve messages on the connection until an error is encountered.
 func (c *conn) serve() error {
 	tags := map[Tag]*activeRequest{} // active requests
+	traces := map[Tag][]string{}     // trace context for the next request on a tag
+	defer func() {
+		// requests and fids are abandoned with the connection.
+		c.metrics.Outstanding(-len(tags))
+		c.metrics.Fids(-c.fids)
+	}()
 
-	requests := make(chan *Fcall)  // sync, read-limited
-	responses := make(chan *Fcall) // sync, goroutine consumed
-	completed := make(chan *Fcall) // sync, send in goroutine per request
+	requests := make(chan request)   // sync, read-limited
+	responses := make(chan response) // sync, goroutine consumed
+	completed := make(chan response) // sync, send in goroutine per request
 
 	// read loop
 	go c.read(requests)
@@ -81,10 +146,29 @@ func (c *conn) serve() error {
 
 	for {
 		select {
-		case req := <-requests:
+		case received := <-requests:
+			req, bufs := received.fcall, received.bufs
+			if msg, ok := req.Message.(MessageTtrace); ok && c.propagator != nil {
+				// Annotates the next request with the tag. There is no
+				// response. Without TraceVersion, Ttrace is an unknown
+				// message like any other, left to the handler. Trace
+				// context over the limits, or for a tag already in use,
+				// is dropped, since it can't be answered with an error.
+				delete(traces, req.Tag)
+				if _, active := tags[req.Tag]; !active && validTrace(msg.Fields) {
+					traces[req.Tag] = msg.Fields
+				}
+				bufs.release()
+				continue
+			}
+
+			fields, traced := traces[req.Tag]
+			delete(traces, req.Tag)
+
 			if _, ok := tags[req.Tag]; ok {
+				bufs.release()
 				select {
-				case responses <- newErrorFcall(req.Tag, ErrDuptag):
+				case responses <- response{fcall: c.errorFcall(req.Tag, ErrDuptag)}:
 					// Send to responses, bypass tag management.
 				case <-c.ctx.Done():
 					return c.ctx.Err()
@@ -96,19 +180,18 @@ func (c *conn) serve() error {
 
 			switch msg := req.Message.(type) {
 			case MessageTflush:
-				var resp *Fcall
-				// check if we have actually know about the requested flush
-				active, ok := tags[msg.Oldtag]
-				if ok {
+				bufs.release()
+				// flush(5) answers every flush with Rflush, even if the tag
+				// is unknown or has already been answered.
+				if active, ok := tags[msg.Oldtag]; ok {
 					active.cancel() // propagate cancellation to callees
 					delete(tags, msg.Oldtag)
-					resp = newFcall(req.Tag, MessageRflush{})
-				} else {
-					resp = newErrorFcall(req.Tag, ErrUnknownTag)
+					c.metrics.Flushed()
+					c.metrics.Outstanding(-1)
 				}
 
 				select {
-				case responses <- resp:
+				case responses <- response{fcall: newFcall(req.Tag, MessageRflush{})}:
 					// bypass tag management in completed.
 				case <-c.ctx.Done():
 					return c.ctx.Err()
@@ -118,7 +201,11 @@ func (c *conn) serve() error {
 			default:
 				// Allows us to session handlers to cancel processing of the fcall
 				// through context.
-				ctx, cancel := context.WithCancel(c.ctx)
+				ctx, cancel := context.WithCancel(withTag(c.ctx, req.Tag))
+				ctx = withBuffers(ctx, bufs)
+				if traced {
+					ctx = extractTrace(ctx, c.propagator, fields)
+				}
 
 				// The contents of these instances are only writable in the main
 				// server loop. The value of tag will not change.
@@ -126,7 +213,9 @@ func (c *conn) serve() error {
 					ctx:     ctx,
 					request: req,
 					cancel:  cancel,
+					start:   time.Now(),
 				}
+				c.metrics.Outstanding(1)
 
 				go func(ctx context.Context, req *Fcall) {
 					// TODO(stevvooe): Re-write incoming Treads so that handler
@@ -136,36 +225,46 @@ func (c *conn) serve() error {
 					msg, err := c.handler.Handle(ctx, req.Message)
 					if err != nil {
 						// all handler errors are forwarded as protocol errors.
-						resp = newErrorFcall(req.Tag, err)
+						resp = c.errorFcall(req.Tag, err)
 					} else {
 						resp = newFcall(req.Tag, msg)
 					}
 
 					select {
-					case completed <- resp:
+					case completed <- response{fcall: resp, request: req, release: bufs.release}:
 					case <-ctx.Done():
-						return
+						bufs.release()
 					case <-c.closed:
-						return
+						bufs.release()
 					}
 				}(ctx, req)
 			}
-		case resp := <-completed:
+		case done := <-completed:
 			// only responses that flip the tag state traverse this section.
+			resp := done.fcall
 			active, ok := tags[resp.Tag]
-			if !ok {
-				// The tag is no longer active. Likely a flushed message.
+			if !ok || active.request != done.request {
+				// The tag is no longer active, or has been reused since
+				// the request was flushed.
+				done.release()
 				continue
 			}
 
 			select {
-			case responses <- resp:
+			case responses <- done:
 			case <-active.ctx.Done():
 				// the context was canceled for some reason, perhaps timeout or
 				// due to a flush call. We treat this as a condition where a
 				// response should not be sent.
+				done.release()
 			}
 			delete(tags, resp.Tag)
+
+			delta := fidDelta(active.request.Message, resp.Message)
+			c.fids += delta
+			c.metrics.Fids(delta)
+			c.metrics.Outstanding(-1)
+			c.metrics.Request(active.request.Type, time.Since(active.start), messageError(resp.Message))
 		case <-c.ctx.Done():
 			return c.ctx.Err()
 		case <-c.closed:
@@ -174,11 +273,25 @@ func (c *conn) serve() error {
 	}
 }
 
+// errorFcall returns the response to a request failing with err. In
+// 9P2000.u, the errno of err is sent with the error.
+func (c *conn) errorFcall(tag Tag, err error) *Fcall {
+	return newVersionErrorFcall(c.version, tag, err)
+}
+
+// request is an fcall read from the channel, with the buffers its data
+// aliases. They are released once the request is answered.
+type request struct {
+	fcall *Fcall
+	bufs  *buffers
+}
+
 // read takes requests off the channel and sends them on requests.
-func (c *conn) read(requests chan *Fcall) {
+func (c *conn) read(requests chan request) {
 	for {
-		req := new(Fcall)
-		if err := c.ch.ReadFcall(c.ctx, req); err != nil {
+		req := request{fcall: new(Fcall), bufs: new(buffers)}
+		if err := c.ch.ReadFcall(withBuffers(c.ctx, req.bufs), req.fcall); err != nil {
+			req.bufs.release()
 			if err, ok := err.(net.Error); ok {
 				if err.Timeout() || err.Temporary() {
 					// TODO(stevvooe): A full idle timeout on the connection
@@ -188,22 +301,24 @@ func (c *conn) read(requests chan *Fcall) {
 				}
 			}
 
-			c.CloseWithError(fmt.Errorf("error reading fcall: %v", err))
+			c.CloseWithError(fmt.Errorf("error reading fcall: %w", err))
 			return
 		}
 
 		select {
 		case requests <- req:
 		case <-c.ctx.Done():
+			req.bufs.release()
 			c.CloseWithError(c.ctx.Err())
 			return
 		case <-c.closed:
+			req.bufs.release()
 			return
 		}
 	}
 }
 
-func (c *conn) write(responses chan *Fcall) {
+func (c *conn) write(responses chan response) {
 	for {
 		select {
 		case resp := <-responses:
@@ -212,17 +327,15 @@ func (c *conn) write(responses chan *Fcall) {
 			// loop, by adjusting incoming Tread calls to have a Count that
 			// won't overflow the msize.
 
-			if err := c.ch.WriteFcall(c.ctx, resp); err != nil {
-				if err, ok := err.(net.Error); ok {
-					if err.Timeout() || err.Temporary() {
-						// TODO(stevvooe): A full idle timeout on the
-						// connection should be enforced here. We log here,
-						// since this is less common.
-						log.Printf("p9p: temporary error writing fcall: %v", err)
-						continue
-					}
-				}
+			err := c.ch.WriteFcall(c.ctx, resp.fcall)
+			if resp.release != nil {
+				resp.release()
+			}
 
+			if err != nil {
+				// Even temporary errors are fatal: the response would be
+				// lost, leaving the client waiting, and the frame may have
+				// been partly written.
 				c.CloseWithError(fmt.Errorf("error writing fcall: %v", err))
 				return
 			}
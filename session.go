@@ -4,7 +4,8 @@ import "context"
 
 // Session provides the central abstraction for a 9p connection. Clients
 // implement sessions and servers serve sessions. Sessions can be proxied by
-// serving up a client session.
+// serving up a client session. To share one client session among many
+// connections, use a Proxy, which keeps the fids of each connection apart.
 //
 // The interface is also wired up with full context support to manage timeouts
 // and resource clean up.
@@ -40,3 +41,45 @@ type Session interface {
 	// session implementation.
 	Version() (msize int, version string)
 }
+
+// SessionL is implemented by sessions that support the 9P2000.L extension
+// messages for extended attributes, fsync, statfs and byte-range locks.
+// Dispatch routes these messages to the session if it implements SessionL
+// and responds with ErrUnknownMsg otherwise. The client session returned by
+// NewSession implements SessionL.
+//
+// These messages keep their 9P2000.L wire format but are used within a
+// 9P2000 session. Full 9P2000.L, as used by the Linux kernel client, is not
+// negotiated by this package.
+type SessionL interface {
+	Session
+
+	// Statfs returns information about the filesystem containing fid.
+	Statfs(ctx context.Context, fid Fid) (StatFS, error)
+
+	// Getattr returns the attributes of fid, with at least the fields of
+	// mask, a combination of the Getattr flags, set in Attr.Valid. Unlike
+	// Stat, times carry nanoseconds and 64 bits of seconds.
+	Getattr(ctx context.Context, fid Fid, mask uint64) (Attr, error)
+
+	// XattrWalk prepares newfid for reading the extended attribute name of
+	// fid, returning the size of its value. If name is empty, newfid reads
+	// the list of attribute names, each terminated by a NUL byte.
+	XattrWalk(ctx context.Context, fid, newfid Fid, name string) (uint64, error)
+
+	// XattrCreate prepares fid for setting the extended attribute name to a
+	// value of size bytes, which are then written to fid. The attribute is
+	// set when fid is clunked. A size of zero removes the attribute.
+	XattrCreate(ctx context.Context, fid Fid, name string, size uint64, flags uint32) error
+
+	// Fsync flushes the data of the open fid to stable storage.
+	Fsync(ctx context.Context, fid Fid, datasync bool) error
+
+	// Lock acquires or releases a byte-range lock on the open fid,
+	// returning one of the LockStatus values.
+	Lock(ctx context.Context, fid Fid, lock Lock) (uint8, error)
+
+	// GetLock tests for a lock conflicting with lock on the open fid. If
+	// there is none, the returned lock has type LockUnlock.
+	GetLock(ctx context.Context, fid Fid, lock Lock) (Lock, error)
+}
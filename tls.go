@@ -0,0 +1,158 @@
+package p9p
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake when serving or dialing over a
+// tls.Conn.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSIdentityMethod is the method recorded in the AuthInfo of users
+// identified by TLSIdentity.
+const TLSIdentityMethod = "tls"
+
+// handshake completes the TLS handshake if conn is a tls.Conn, returning the
+// connection state. Doing so up front keeps handshake failures from being
+// reported as version negotiation errors.
+func handshake(ctx context.Context, conn net.Conn) (*tls.ConnectionState, error) {
+	tconn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+
+	state := tconn.ConnectionState()
+	return &state, nil
+}
+
+// IdentityFunc returns the identity of the peer of a connection, from the
+// context passed to the handler.
+type IdentityFunc func(ctx context.Context) (AuthInfo, error)
+
+// IdentityMode controls how AttachIdentity treats the uname in a Tattach.
+type IdentityMode int
+
+const (
+	// IdentityValidate fails attaches with a uname that differs from the
+	// identity of the peer.
+	IdentityValidate IdentityMode = iota
+
+	// IdentityOverride replaces the uname with the identity of the peer.
+	IdentityOverride
+)
+
+// AttachIdentity returns a handler that establishes the identity of each
+// Tattach with fn before passing it on to handler. The identity is then
+// available to Session.Attach through GetAuthInfo. Attaches fail if fn
+// returns an error.
+func AttachIdentity(handler Handler, fn IdentityFunc, mode IdentityMode) Handler {
+	return HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+		tattach, ok := msg.(MessageTattach)
+		if !ok {
+			return handler.Handle(ctx, msg)
+		}
+
+		info, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch mode {
+		case IdentityOverride:
+			tattach.Uname = info.Uname
+		default:
+			if tattach.Uname != info.Uname {
+				return nil, ErrAuthFailed
+			}
+		}
+
+		return handler.Handle(withAuthInfo(ctx, info), tattach)
+	})
+}
+
+// CertName selects the name in a client certificate used as the uname.
+type CertName int
+
+const (
+	CertCommonName   CertName = iota // common name of the subject
+	CertDNSName                      // first DNS subject alternative name
+	CertEmailAddress                 // first email subject alternative name
+	CertURI                          // first URI subject alternative name
+)
+
+// TLSIdentity returns an IdentityFunc taking the identity from the verified
+// client certificate of connections served over TLS. The server must be
+// configured to verify client certificates. If users is non-nil, it maps
+// names from certificates to unames and names not in users are refused.
+func TLSIdentity(name CertName, users map[string]string) IdentityFunc {
+	return func(ctx context.Context) (AuthInfo, error) {
+		state, ok := GetTLSState(ctx)
+		if !ok || len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+			return AuthInfo{}, ErrAuthRequired
+		}
+
+		cn, ok := certName(state.PeerCertificates[0], name)
+		if !ok {
+			return AuthInfo{}, ErrAuthFailed
+		}
+
+		if users != nil {
+			cn, ok = users[cn]
+			if !ok {
+				return AuthInfo{}, ErrAuthFailed
+			}
+		}
+
+		return AuthInfo{Uname: cn, Method: TLSIdentityMethod}, nil
+	}
+}
+
+func certName(cert *x509.Certificate, name CertName) (string, bool) {
+	switch name {
+	case CertCommonName:
+		return cert.Subject.CommonName, cert.Subject.CommonName != ""
+	case CertDNSName:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0], true
+		}
+	case CertEmailAddress:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0], true
+		}
+	case CertURI:
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String(), true
+		}
+	}
+
+	return "", false
+}
+
+// ParseCertName parses the names used for CertName in flags: "cn", "dns",
+// "email" or "uri".
+func ParseCertName(s string) (CertName, error) {
+	switch s {
+	case "cn":
+		return CertCommonName, nil
+	case "dns":
+		return CertDNSName, nil
+	case "email":
+		return CertEmailAddress, nil
+	case "uri":
+		return CertURI, nil
+	}
+
+	return 0, errors.New("p9p: unknown certificate name " + s)
+}
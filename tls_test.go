@@ -0,0 +1,115 @@
+package p9p
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for cn, signed by parent or self-signed if
+// parent is nil.
+func testCert(t *testing.T, cn string, parent *tls.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestTLSIdentity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		ca     = testCert(t, "ca", nil)
+		server = testCert(t, "localhost", &ca)
+		client = testCert(t, "glenda", &ca)
+		pool   = x509.NewCertPool()
+	)
+	pool.AddCert(ca.Leaf)
+
+	for _, testcase := range []struct {
+		description string
+		mode        IdentityMode
+		uname       string
+		err         error
+	}{
+		{description: "override", mode: IdentityOverride, uname: "anyone"},
+		{description: "validate", mode: IdentityValidate, uname: "glenda"},
+		{description: "mismatch", mode: IdentityValidate, uname: "anyone", err: ErrAuthFailed},
+	} {
+		t.Run(testcase.description, func(t *testing.T) {
+			cconn, sconn := net.Pipe()
+			defer cconn.Close()
+
+			inner := &attachSession{}
+			handler := AttachIdentity(Dispatch(inner), TLSIdentity(CertCommonName, nil), testcase.mode)
+			go ServeConn(ctx, tls.Server(sconn, &tls.Config{
+				Certificates: []tls.Certificate{server},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}), handler)
+
+			session, err := NewSession(ctx, tls.Client(cconn, &tls.Config{
+				Certificates: []tls.Certificate{client},
+				RootCAs:      pool,
+				ServerName:   "localhost",
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := session.Attach(ctx, 1, NOFID, testcase.uname, ""); !errors.Is(err, testcase.err) {
+				t.Fatalf("expected %v, got %v", testcase.err, err)
+			}
+
+			if testcase.err != nil {
+				return
+			}
+
+			if inner.info.Uname != "glenda" || inner.info.Method != TLSIdentityMethod {
+				t.Fatalf("unexpected auth info: %+v", inner.info)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package p9p
+
+import (
+	"context"
+	"sort"
+)
+
+// TraceVersion is negotiated by connections configured with WithPropagator.
+// It extends DefaultVersion with the Ttrace message, sent by the client
+// before any request with trace context to carry.
+const TraceVersion = DefaultVersion + ".trace"
+
+// maxTraceFields and maxTraceSize limit the trace context of a request, which
+// servers hold until the request arrives.
+const (
+	maxTraceFields = 32
+	maxTraceSize   = 4096 // total bytes of the fields
+)
+
+// Propagator moves trace context, such as the W3C traceparent and tracestate
+// headers, between a context and a carrier sent over the wire. It is not tied
+// to a particular tracing library. For example, an OpenTelemetry
+// TextMapPropagator can be adapted by passing the carrier as a
+// propagation.MapCarrier.
+type Propagator interface {
+	// Inject adds the trace context of ctx to carrier. It is called on the
+	// client for each request.
+	Inject(ctx context.Context, carrier map[string]string)
+
+	// Extract returns ctx with the trace context from carrier. It is called
+	// on the server for requests with trace context, before the handler.
+	Extract(ctx context.Context, carrier map[string]string) context.Context
+}
+
+// traceFields returns the trace context of ctx as fields of a Ttrace
+// message.
+func traceFields(ctx context.Context, propagator Propagator) []string {
+	carrier := map[string]string{}
+	propagator.Inject(ctx, carrier)
+
+	keys := make([]string, 0, len(carrier))
+	for k := range carrier {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := make([]string, 0, 2*len(keys))
+	for _, k := range keys {
+		fields = append(fields, k, carrier[k])
+	}
+
+	return fields
+}
+
+// validTrace returns true if fields are within the limits of trace context.
+// Other trace context is not sent, and dropped when received.
+func validTrace(fields []string) bool {
+	if len(fields) > maxTraceFields {
+		return false
+	}
+
+	size := 0
+	for _, field := range fields {
+		size += len(field)
+	}
+
+	return size <= maxTraceSize
+}
+
+// extractTrace returns ctx with the trace context from the fields of a
+// Ttrace message.
+func extractTrace(ctx context.Context, propagator Propagator, fields []string) context.Context {
+	carrier := make(map[string]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		carrier[fields[i]] = fields[i+1]
+	}
+
+	return propagator.Extract(ctx, carrier)
+}
@@ -0,0 +1,207 @@
+package p9p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+type traceIDKey struct{}
+
+// testPropagator carries a trace id stored on the context.
+type testPropagator struct{}
+
+func (testPropagator) Inject(ctx context.Context, carrier map[string]string) {
+	if id, ok := ctx.Value(traceIDKey{}).(string); ok {
+		carrier["trace-id"] = id
+	}
+}
+
+func (testPropagator) Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if id, ok := carrier["trace-id"]; ok {
+		return context.WithValue(ctx, traceIDKey{}, id)
+	}
+
+	return ctx
+}
+
+func TestTracePropagation(t *testing.T) {
+	for _, testcase := range []struct {
+		description      string
+		server, client   Propagator
+		version, traceID string
+	}{
+		{description: "both", server: testPropagator{}, client: testPropagator{}, version: TraceVersion, traceID: "abc"},
+		{description: "server only", server: testPropagator{}, version: DefaultVersion},
+		{description: "client only", client: testPropagator{}, version: DefaultVersion},
+	} {
+		t.Run(testcase.description, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			seen := make(chan string, 2)
+			handler := HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+				id, _ := ctx.Value(traceIDKey{}).(string)
+				seen <- id
+				return Dispatch(&rwSession{}).Handle(ctx, msg)
+			})
+
+			cconn, sconn := net.Pipe()
+			defer cconn.Close()
+
+			go ServeConn(ctx, sconn, handler, WithPropagator(testcase.server))
+
+			session, err := NewSession(ctx, cconn, WithPropagator(testcase.client))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, version := session.Version(); version != testcase.version {
+				t.Fatalf("expected version %q, got %q", testcase.version, version)
+			}
+
+			tctx := context.WithValue(ctx, traceIDKey{}, "abc")
+			if _, err := session.Attach(tctx, 1, NOFID, "glenda", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			if id := <-seen; id != testcase.traceID {
+				t.Fatalf("expected trace id %q, got %q", testcase.traceID, id)
+			}
+
+			// requests without trace context aren't annotated.
+			if _, err := session.Attach(ctx, 2, NOFID, "glenda", ""); err != nil {
+				t.Fatal(err)
+			}
+
+			if id := <-seen; id != "" {
+				t.Fatalf("unexpected trace id %q", id)
+			}
+		})
+	}
+}
+
+func TestTraceUnnegotiated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	go ServeConn(ctx, sconn, Dispatch(&rwSession{}))
+
+	ch := newChannel(cconn, codec9p{}, DefaultMSize)
+	if _, err := clientnegotiate(ctx, ch, DefaultVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	// without TraceVersion, Ttrace is answered as an unknown message.
+	if err := ch.WriteFcall(ctx, newFcall(5, MessageTtrace{Fields: []string{"trace-id", "abc"}})); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp Fcall
+	if err := ch.ReadFcall(ctx, &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err, _ := resp.Message.(error); resp.Tag != 5 || !errors.Is(err, ErrUnknownMsg) {
+		t.Fatalf("expected %v for tag 5, got %v", ErrUnknownMsg, &resp)
+	}
+}
+
+func TestTraceDropped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		seen    = make(chan string, 4)
+		blocked = make(chan struct{})
+	)
+	handler := HandlerFunc(func(ctx context.Context, msg Message) (Message, error) {
+		id, _ := ctx.Value(traceIDKey{}).(string)
+		seen <- id
+		if tattach, ok := msg.(MessageTattach); ok && tattach.Fid == 1 {
+			<-blocked
+		}
+		return Dispatch(&rwSession{}).Handle(ctx, msg)
+	})
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+
+	go ServeConn(ctx, sconn, handler, WithPropagator(testPropagator{}))
+
+	ch := newChannel(cconn, codec9p{}, DefaultMSize)
+	if _, err := clientnegotiate(ctx, ch, TraceVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	attach := func(tag Tag, fid Fid) {
+		t.Helper()
+		if err := ch.WriteFcall(ctx, newFcall(tag, MessageTattach{Fid: fid, Afid: NOFID, Uname: "glenda"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trace := func(tag Tag, fields ...string) {
+		t.Helper()
+		if err := ch.WriteFcall(ctx, newFcall(tag, MessageTtrace{Fields: fields})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	response := func(tag Tag) {
+		t.Helper()
+		var resp Fcall
+		if err := ch.ReadFcall(ctx, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Tag != tag || resp.Type != Rattach {
+			t.Fatalf("expected Rattach for tag %v, got %v", tag, &resp)
+		}
+	}
+
+	// trace context for a tag in use is dropped, rather than applied to
+	// the next request on the tag.
+	attach(1, 1)
+	if id := <-seen; id != "" {
+		t.Fatalf("unexpected trace id %q", id)
+	}
+	trace(1, "trace-id", "active")
+
+	// requests are handled in order, so the Ttrace was seen once the next
+	// request reaches the handler.
+	attach(2, 2)
+	if id := <-seen; id != "" {
+		t.Fatalf("unexpected trace id %q", id)
+	}
+	response(2)
+	close(blocked)
+	response(1)
+
+	attach(1, 3)
+	response(1)
+	if id := <-seen; id != "" {
+		t.Fatalf("unexpected trace id %q", id)
+	}
+
+	// trace context over the limits is dropped.
+	trace(1, "trace-id", string(make([]byte, maxTraceSize)))
+	attach(1, 4)
+	response(1)
+
+	if id := <-seen; id != "" {
+		t.Fatalf("unexpected trace id %q", id)
+	}
+
+	trace(1, "trace-id", "abc")
+	attach(1, 5)
+	response(1)
+
+	if id := <-seen; id != "abc" {
+		t.Fatalf("expected trace id %q, got %q", "abc", id)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 	"sync"
+	"time"
 
 	"context"
 )
@@ -25,6 +26,10 @@ type transport struct {
 	ctx      context.Context
 	ch       Channel
 	requests chan *fcallRequest
+	metrics  Metrics
+
+	// propagator is set if trace context is carried on the connection.
+	propagator Propagator
 
 	shutdown chan struct{}
 	once     sync.Once // protect closure of shutdown
@@ -35,13 +40,15 @@ type transport struct {
 
 var _ roundTripper = &transport{}
 
-func newTransport(ctx context.Context, ch Channel) roundTripper {
+func newTransport(ctx context.Context, ch Channel, o options) roundTripper {
 	t := &transport{
-		ctx:      ctx,
-		ch:       ch,
-		requests: make(chan *fcallRequest),
-		shutdown: make(chan struct{}),
-		closed:   make(chan struct{}),
+		ctx:        ctx,
+		ch:         ch,
+		requests:   make(chan *fcallRequest),
+		metrics:    o.metrics,
+		propagator: o.propagator,
+		shutdown:   make(chan struct{}),
+		closed:     make(chan struct{}),
 	}
 
 	go t.handle()
@@ -55,6 +62,14 @@ type fcallRequest struct {
 	message  Message
 	response chan *Fcall
 	err      chan error
+	start    time.Time // set when sent, for metrics
+
+	// tag is the tag of the request once sent, only accessed by the
+	// handle loop.
+	tag Tag
+
+	// flushes is the request cancelled by this Tflush.
+	flushes *fcallRequest
 }
 
 func newFcallRequest(ctx context.Context, msg Message) *fcallRequest {
@@ -83,18 +98,24 @@ func (t *transport) send(ctx context.Context, msg Message) (Message, error) {
 	case <-t.closed:
 		return nil, ErrClosed
 	case <-ctx.Done():
+		t.flush(req)
 		return nil, ctx.Err()
 	case err := <-req.err:
 		return nil, err
 	case resp := <-req.response:
-		if resp.Type == Rerror {
+		if resp.Type == Rerror || resp.Type == Rlerror {
 			// pack the error into something useful
-			respmesg, ok := resp.Message.(MessageRerror)
-			if !ok {
+			err := messageError(resp.Message)
+			if err == nil {
 				return nil, fmt.Errorf("invalid error response: %v", resp)
 			}
 
-			return nil, respmesg
+			fid, ok := messageFid(msg)
+			if !ok {
+				fid = NOFID
+			}
+
+			return nil, &OpError{Op: msg.Type(), Fid: fid, Err: err}
 		}
 
 		return resp.Message, nil
@@ -137,8 +158,15 @@ func (t *transport) handle() {
 		// outstanding provides a map of tags to outstanding requests.
 		outstanding = map[Tag]*fcallRequest{}
 		selected    Tag
+		fids        int // live fids, for metrics
 	)
 
+	defer func() {
+		// requests and fids are abandoned with the connection.
+		t.metrics.Outstanding(-len(outstanding))
+		t.metrics.Fids(-fids)
+	}()
+
 	// loop to read messages off of the connection
 	go func() {
 		defer func() {
@@ -151,10 +179,9 @@ func (t *transport) handle() {
 				switch err := err.(type) {
 				case net.Error:
 					if err.Timeout() || err.Temporary() {
-						// BUG(stevvooe): There may be partial reads under
-						// timeout errors where this is actually fatal.
-
-						// can only retry if we haven't offset the frame.
+						// The channel fails reads that leave part of a
+						// frame consumed with other errors, so the next
+						// read starts at a frame.
 						continue loop
 					}
 				}
@@ -176,6 +203,15 @@ func (t *transport) handle() {
 	for {
 		select {
 		case req := <-t.requests:
+			if req.flushes != nil {
+				old := req.flushes
+				if outstanding[old.tag] != old {
+					// already answered, nothing to flush.
+					continue
+				}
+				req.message = MessageTflush{Oldtag: old.tag}
+			}
+
 			var err error
 
 			selected, err = allocateTag(req, outstanding, selected)
@@ -184,33 +220,53 @@ func (t *transport) handle() {
 				continue
 			}
 
+			req.tag = selected
 			outstanding[selected] = req
+			req.start = time.Now()
 			fcall := newFcall(selected, req.message)
 
 			// TODO(stevvooe): Consider the case of requests that never
 			// receive a response. We need to remove the fcall context from
 			// the tag map and dealloc the tag. We may also want to send a
 			// flush for the tag.
+			if err := t.writeTrace(req.ctx, selected); err != nil {
+				delete(outstanding, fcall.Tag)
+				req.err <- err
+				continue
+			}
+
 			if err := t.ch.WriteFcall(req.ctx, fcall); err != nil {
 				delete(outstanding, fcall.Tag)
 				req.err <- err
+				continue
 			}
+			t.metrics.Outstanding(1)
 		case b := <-responses:
 			req, ok := outstanding[b.Tag]
 			if !ok {
-				// BUG(stevvooe): The exact handling of an unknown tag is
-				// unclear at this point. These may not necessarily fatal to
-				// the session, since they could be messages that the client no
-				// longer cares for. When we figure this out, replace this
-				// panic with something more sensible.
-				panic(fmt.Sprintf("unknown tag received: %v", b))
+				// A response to no request, such as a duplicate or one
+				// following the response to its flush. Nobody is waiting
+				// for it, so it is dropped.
+				log.Println("p9p: dropping response with unknown tag:", b)
+				continue
 			}
 
-			// BUG(stevvooe): Must detect duplicate tag and ensure that we are
-			// waking up the right caller. If a duplicate is received, the
-			// entry should not be deleted.
 			delete(outstanding, b.Tag)
 
+			delta := fidDelta(req.message, b.Message)
+			fids += delta
+			t.metrics.Fids(delta)
+			t.metrics.Outstanding(-1)
+			t.metrics.Request(req.message.Type(), time.Since(req.start), messageError(b.Message))
+
+			if old := req.flushes; old != nil && outstanding[old.tag] == old {
+				// the flushed request will not be answered, releasing
+				// its tag.
+				delete(outstanding, old.tag)
+				t.metrics.Flushed()
+				t.metrics.Outstanding(-1)
+			}
+
 			req.response <- b
 
 			// TODO(stevvooe): Reclaim tag id.
@@ -222,10 +278,34 @@ func (t *transport) handle() {
 	}
 }
 
-func (t *transport) flush(ctx context.Context, tag Tag) error {
-	// TODO(stevvooe): We need to fire and forget flush messages when a call
-	// context gets cancelled.
-	panic("not implemented")
+// writeTrace sends the trace context of ctx ahead of the request with tag, if
+// the connection carries it.
+func (t *transport) writeTrace(ctx context.Context, tag Tag) error {
+	if t.propagator == nil {
+		return nil
+	}
+
+	fields := traceFields(ctx, t.propagator)
+	if len(fields) == 0 || !validTrace(fields) {
+		return nil
+	}
+
+	return t.ch.WriteFcall(ctx, newFcall(tag, MessageTtrace{Fields: fields}))
+}
+
+// flush sends a Tflush for req, which was cancelled while waiting for its
+// response. The flush is sent under the context of the transport and its
+// response is not waited for. Until the response, the tag of req remains
+// in use.
+func (t *transport) flush(req *fcallRequest) {
+	freq := newFcallRequest(t.ctx, MessageTflush{})
+	freq.flushes = req
+
+	select {
+	case t.requests <- freq:
+	case <-t.closed:
+	case <-t.ctx.Done():
+	}
 }
 
 func (t *transport) Close() error {
@@ -2,6 +2,7 @@ package p9p
 
 import (
 	"fmt"
+	"math"
 	"time"
 )
 
@@ -11,6 +12,17 @@ const (
 
 	// DefaultVersion for this package. Currently, the only supported version.
 	DefaultVersion = "9P2000"
+
+	// UnixVersion is the 9P2000.u dialect, encoded by NewUnixCodec.
+	UnixVersion = DefaultVersion + ".u"
+
+	// IOHDRSZ is the size of the header of Twrite and Rread messages, which
+	// must be reserved from msize when choosing an iounit.
+	IOHDRSZ = 24
+
+	// MAXWELEM is the largest number of names in a Twalk and of qids in an
+	// Rwalk.
+	MAXWELEM = 16
 )
 
 // Mode constants for use Dir.Mode.
@@ -69,6 +81,11 @@ const (
 	QTAUTH   QType = 0x08 // type bit for authentication file
 	QTTMP    QType = 0x04 // type bit for not-backed-up file
 	QTFILE   QType = 0x00 // plain file
+
+	// 9p2000.u extensions
+
+	QTSYMLINK QType = 0x02 // type bit for symbolic links
+	QTLINK    QType = 0x01 // type bit for hard links
 )
 
 func (qt QType) String() string {
@@ -87,6 +104,10 @@ func (qt QType) String() string {
 		return "tmp"
 	case QTFILE:
 		return "file"
+	case QTSYMLINK:
+		return "symlink"
+	case QTLINK:
+		return "link"
 	}
 
 	return "unknown"
@@ -105,6 +126,10 @@ type Fid uint32
 // NOFID indicates the lack of an Fid.
 const NOFID Fid = ^Fid(0)
 
+// NONUNAME indicates the lack of a numeric user id in the Tauth and Tattach
+// messages of 9P2000.u.
+const NONUNAME = ^uint32(0)
+
 // Qid indicates the type, path and version of the resource returned by a
 // server. It is only valid for a session.
 //
@@ -129,9 +154,10 @@ type Dir struct {
 	Qid  Qid
 	Mode uint32
 
-	// BUG(stevvooe): The Year 2038 is coming soon. 9p wire protocol has these
-	// as 4 byte epoch times. Some possibilities include time dilation fields
-	// or atemporal files. We can also just not use them and set them to zero.
+	// The 9p wire protocol has these as unsigned 4 byte epoch times, in
+	// seconds, ending in 2106. Times outside of the range are clamped to it
+	// rather than wrapped, so the zero time is sent as the epoch. Use
+	// DontTouchTime to leave a time unchanged with WStat.
 
 	AccessTime time.Time
 	ModTime    time.Time
@@ -141,9 +167,145 @@ type Dir struct {
 	UID    string
 	GID    string
 	MUID   string
+
+	// 9P2000.u
+	Extension string `p9p:"9P2000.u" json:",omitempty"` // of special files
+	NUid      uint32 `p9p:"9P2000.u" json:",omitempty"`
+	NGid      uint32 `p9p:"9P2000.u" json:",omitempty"`
+	NMuid     uint32 `p9p:"9P2000.u" json:",omitempty"`
 }
 
 func (d Dir) String() string {
 	return fmt.Sprintf("dir(%v mode=%v atime=%v mtime=%v length=%v name=%v uid=%v gid=%v muid=%v)",
 		d.Qid, d.Mode, d.AccessTime, d.ModTime, d.Length, d.Name, d.UID, d.GID, d.MUID)
 }
+
+// DontTouchTime is set as the AccessTime or ModTime of a Dir passed to WStat
+// to leave the time unchanged. It is sent as ^uint32(0), the "don't touch"
+// value of stat(5), which decodes back to DontTouchTime.
+var DontTouchTime = time.Unix(math.MaxUint32, 0).UTC()
+
+// unixtime32 returns the seconds of t for the 9P2000 wire format, clamped to
+// its range. The last second is reserved for DontTouchTime.
+func unixtime32(t time.Time) uint32 {
+	switch sec := t.Unix(); {
+	case sec < 0:
+		return 0
+	case sec > math.MaxUint32:
+		return math.MaxUint32 - 1
+	default:
+		return uint32(sec)
+	}
+}
+
+// Timespec is a time with 64 bits of seconds and nanoseconds, as carried by
+// the 9P2000.L extensions.
+type Timespec struct {
+	Sec  uint64
+	Nsec uint64
+}
+
+// NewTimespec returns the Timespec of t. Times before the epoch have
+// negative seconds, in two's complement.
+func NewTimespec(t time.Time) Timespec {
+	return Timespec{Sec: uint64(t.Unix()), Nsec: uint64(t.Nanosecond())}
+}
+
+// Time returns the time of ts, in UTC.
+func (ts Timespec) Time() time.Time {
+	return time.Unix(int64(ts.Sec), int64(ts.Nsec)).UTC()
+}
+
+func (ts Timespec) String() string {
+	return ts.Time().Format(time.RFC3339Nano)
+}
+
+// Attr describes the attributes of a file, as returned by SessionL.Getattr.
+// It has the same fields as MessageRgetattr. Valid is a mask of the
+// Getattr flags for the fields that are set.
+type Attr struct {
+	Valid       uint64
+	Qid         Qid
+	Mode        uint32
+	UID         uint32
+	GID         uint32
+	NLink       uint64
+	RDev        uint64
+	Size        uint64
+	BlkSize     uint64
+	Blocks      uint64
+	ATime       Timespec
+	MTime       Timespec
+	CTime       Timespec
+	BTime       Timespec
+	Gen         uint64
+	DataVersion uint64
+}
+
+// Flags for the request mask of SessionL.Getattr and Attr.Valid.
+const (
+	GetattrMode        = 0x00000001
+	GetattrNLink       = 0x00000002
+	GetattrUID         = 0x00000004
+	GetattrGID         = 0x00000008
+	GetattrRDev        = 0x00000010
+	GetattrATime       = 0x00000020
+	GetattrMTime       = 0x00000040
+	GetattrCTime       = 0x00000080
+	GetattrIno         = 0x00000100
+	GetattrSize        = 0x00000200
+	GetattrBlocks      = 0x00000400
+	GetattrBTime       = 0x00000800
+	GetattrGen         = 0x00001000
+	GetattrDataVersion = 0x00002000
+
+	GetattrBasic = 0x000007ff // the fields of stat(2)
+	GetattrAll   = 0x00003fff
+)
+
+// StatFS describes a filesystem, as returned by SessionL.Statfs. It has the
+// same fields as MessageRstatfs.
+type StatFS struct {
+	FSType  uint32
+	BSize   uint32
+	Blocks  uint64
+	BFree   uint64
+	BAvail  uint64
+	Files   uint64
+	FFree   uint64
+	FSID    uint64
+	NameLen uint32
+}
+
+// Lock describes a POSIX byte-range lock for use with SessionL.Lock and
+// SessionL.GetLock. A Length of zero extends the lock to the end of the
+// file.
+type Lock struct {
+	Type     uint8  // LockRead, LockWrite or LockUnlock
+	Flags    uint32 // LockFlagBlock, LockFlagReclaim; unused by GetLock
+	Start    uint64
+	Length   uint64
+	ProcID   uint32
+	ClientID string
+}
+
+// Lock types for Lock.Type.
+const (
+	LockRead   = 0
+	LockWrite  = 1
+	LockUnlock = 2
+)
+
+// Flags for Lock.Flags.
+const (
+	LockFlagBlock   = 1
+	LockFlagReclaim = 2
+)
+
+// Lock status returned by SessionL.Lock.
+const (
+	LockSuccess = 0
+	LockBlocked = 1
+	LockError   = 2
+	LockGrace   = 3
+)
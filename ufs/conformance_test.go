@@ -0,0 +1,18 @@
+package ufs
+
+import (
+	"context"
+	"testing"
+
+	p9p "github.com/docker/go-p9p"
+	"github.com/docker/go-p9p/p9ptest"
+)
+
+func TestConformance(t *testing.T) {
+	root := t.TempDir()
+	suite := p9ptest.NewSuite(p9ptest.Serve(func(ctx context.Context) (p9p.Session, error) {
+		return NewSession(ctx, root)
+	}))
+
+	suite.Test(t)
+}
@@ -0,0 +1,598 @@
+package ufs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// Access describes what clients may do with an exported path.
+type Access int
+
+const (
+	// AccessReadWrite allows all operations.
+	AccessReadWrite Access = iota
+
+	// AccessReadOnly allows walking, reading and stat but refuses any
+	// modification.
+	AccessReadOnly
+
+	// AccessHidden makes the path appear not to exist. It cannot be walked
+	// to and is omitted from directory reads.
+	AccessHidden
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessReadWrite:
+		return "rw"
+	case AccessReadOnly:
+		return "ro"
+	case AccessHidden:
+		return "hidden"
+	}
+
+	return "unknown"
+}
+
+// ExportRule sets the access for a path and everything below it. Paths are
+// absolute within the export, such that "/" is the root of the attach.
+type ExportRule struct {
+	Path   string
+	Access Access
+}
+
+// ExportRules decide the access allowed for paths within an export. The
+// rule with the longest matching path wins. Paths not matched by any rule
+// get Default.
+type ExportRules struct {
+	Default Access
+	Rules   []ExportRule
+}
+
+// ParseExportRules reads rules from rd. Each line holds an absolute path
+// followed by one of "rw", "ro" or "hidden". Blank lines and lines starting
+// with "#" are ignored:
+//
+//	/          ro
+//	/scratch   rw
+//	/secrets   hidden
+//
+// Access for paths not covered by the rules is read-write.
+func ParseExportRules(rd io.Reader) (*ExportRules, error) {
+	var (
+		rules   ExportRules
+		scanner = bufio.NewScanner(rd)
+		lineno  int
+	)
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("ufs: exports line %d: expected path and access", lineno)
+		}
+
+		if !path.IsAbs(fields[0]) {
+			return nil, fmt.Errorf("ufs: exports line %d: path must be absolute: %q", lineno, fields[0])
+		}
+
+		var access Access
+		switch fields[1] {
+		case "rw":
+			access = AccessReadWrite
+		case "ro":
+			access = AccessReadOnly
+		case "hidden":
+			access = AccessHidden
+		default:
+			return nil, fmt.Errorf("ufs: exports line %d: unknown access %q", lineno, fields[1])
+		}
+
+		rules.Rules = append(rules.Rules, ExportRule{Path: path.Clean(fields[0]), Access: access})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// LoadExportRules parses the rules in the file at filename. See
+// ParseExportRules for the format.
+func LoadExportRules(filename string) (*ExportRules, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseExportRules(f)
+}
+
+// Access returns the access allowed to p.
+func (r *ExportRules) Access(p string) Access {
+	p = path.Clean("/" + p)
+
+	access, matched := r.Default, -1
+	for _, rule := range r.Rules {
+		if len(rule.Path) > matched && within(rule.Path, p) {
+			access, matched = rule.Access, len(rule.Path)
+		}
+	}
+
+	return access
+}
+
+// hiddenWithin returns true if any path below dir is hidden.
+func (r *ExportRules) hiddenWithin(dir string) bool {
+	if r.Access(dir) == AccessHidden {
+		return true
+	}
+
+	for _, rule := range r.Rules {
+		if rule.Access == AccessHidden && within(dir, rule.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// within returns true if p is dir or below it.
+func within(dir, p string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+type exportSession struct {
+	session p9p.Session
+	rules   *ExportRules
+
+	mu   sync.Mutex
+	fids map[p9p.Fid]*exportFid
+}
+
+// exportFid tracks the path of a fid within the export. The path is never
+// changed once the fid is set.
+type exportFid struct {
+	sync.Mutex
+	path    string
+	dir     bool // opened as a directory
+	symlink bool // walked to a symlink, which is never opened
+
+	// offsets for filtered directory reads
+	offset int64 // as seen by the client
+	inner  int64 // in the wrapped session
+}
+
+// NewExportSession returns a session enforcing rules on top of session.
+// Modifications to paths that are not read-write are refused with ErrPerm
+// or ErrNowrite and hidden paths are reported as not found.
+//
+// Paths are tracked from the aname used in attach and the names walked, so
+// the wrapped session need not be a ufs session. As the rules apply to these
+// paths, walks through symlinks are refused and symlinks are never opened or
+// created in. If session implements p9p.SessionL, so does the returned
+// session.
+func NewExportSession(session p9p.Session, rules *ExportRules) p9p.Session {
+	es := &exportSession{
+		session: session,
+		rules:   rules,
+		fids:    make(map[p9p.Fid]*exportFid),
+	}
+
+	if sessionl, ok := session.(p9p.SessionL); ok {
+		return &exportSessionL{exportSession: es, sessionl: sessionl}
+	}
+
+	return es
+}
+
+var (
+	_ p9p.Session  = &exportSession{}
+	_ p9p.SessionL = &exportSessionL{}
+)
+
+func (es *exportSession) getFid(fid p9p.Fid) (*exportFid, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	ef, ok := es.fids[fid]
+	if !ok {
+		return nil, p9p.ErrUnknownfid
+	}
+
+	return ef, nil
+}
+
+func (es *exportSession) setFid(fid p9p.Fid, ef *exportFid) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.fids[fid] = ef
+}
+
+func (es *exportSession) deleteFid(fid p9p.Fid) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.fids, fid)
+}
+
+// writable returns the path of fid if it may be modified.
+func (es *exportSession) writable(fid p9p.Fid, err error) (*exportFid, error) {
+	ef, ferr := es.getFid(fid)
+	if ferr != nil {
+		return nil, ferr
+	}
+
+	if es.rules.Access(ef.path) != AccessReadWrite {
+		return nil, err
+	}
+
+	return ef, nil
+}
+
+func (es *exportSession) Auth(ctx context.Context, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
+	return es.session.Auth(ctx, afid, uname, aname)
+}
+
+func (es *exportSession) Attach(ctx context.Context, fid, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
+	p := path.Clean("/" + aname)
+	if es.rules.Access(p) == AccessHidden {
+		return p9p.Qid{}, p9p.ErrNotfound
+	}
+
+	qid, err := es.session.Attach(ctx, fid, afid, uname, aname)
+	if err != nil {
+		return qid, err
+	}
+
+	es.setFid(fid, &exportFid{path: p})
+	return qid, nil
+}
+
+func (es *exportSession) Clunk(ctx context.Context, fid p9p.Fid) error {
+	err := es.session.Clunk(ctx, fid)
+	es.deleteFid(fid)
+	return err
+}
+
+func (es *exportSession) Remove(ctx context.Context, fid p9p.Fid) error {
+	if _, err := es.writable(fid, p9p.ErrPerm); err != nil {
+		// remove clunks the fid, even on failure.
+		es.Clunk(ctx, fid)
+		return err
+	}
+
+	err := es.session.Remove(ctx, fid)
+	es.deleteFid(fid)
+	return err
+}
+
+func (es *exportSession) Walk(ctx context.Context, fid p9p.Fid, newfid p9p.Fid, names ...string) ([]p9p.Qid, error) {
+	ef, err := es.getFid(fid)
+	if err != nil {
+		return nil, err
+	}
+
+	// stop the walk at the first hidden element.
+	p, walk := ef.path, names
+	for i, name := range names {
+		next := path.Join(p, name)
+		if es.rules.Access(next) == AccessHidden {
+			walk = names[:i]
+			break
+		}
+		p = next
+	}
+
+	if len(walk) < len(names) {
+		if len(walk) == 0 || newfid == fid {
+			// we cannot walk part of the way without moving fid.
+			return nil, p9p.ErrNotfound
+		}
+
+		qids, err := es.session.Walk(ctx, fid, newfid, walk...)
+		if err == nil && len(qids) == len(walk) {
+			// the wrapped session walked newfid but the client has not.
+			es.session.Clunk(ctx, newfid)
+		}
+		return qids, err
+	}
+
+	qids, err := es.session.Walk(ctx, fid, newfid, names...)
+	if err != nil || len(qids) < len(names) {
+		return qids, err
+	}
+
+	// the rules apply to the path as walked, which a symlink would change.
+	symlink := ef.symlink // for a clone of the fid
+	for i, qid := range qids {
+		if qid.Type&p9p.QTSYMLINK == 0 {
+			symlink = false
+			continue
+		}
+
+		if i == len(qids)-1 {
+			symlink = true
+			break
+		}
+
+		if newfid == fid {
+			// the fid has moved to where we don't know, so it can only
+			// be clunked.
+			es.deleteFid(fid)
+			return nil, p9p.ErrWalknodir
+		}
+
+		// a partial walk, as if the symlink wasn't a directory.
+		es.session.Clunk(ctx, newfid)
+		return qids[:i+1], nil
+	}
+
+	es.setFid(newfid, &exportFid{path: p, symlink: symlink})
+	return qids, nil
+}
+
+func (es *exportSession) Read(ctx context.Context, fid p9p.Fid, p []byte, offset int64) (n int, err error) {
+	ef, err := es.getFid(fid)
+	if err != nil {
+		return 0, err
+	}
+
+	ef.Lock()
+	defer ef.Unlock()
+
+	if !ef.dir || !es.rules.hiddenWithin(ef.path) {
+		return es.session.Read(ctx, fid, p, offset)
+	}
+
+	if offset == 0 {
+		ef.offset, ef.inner = 0, 0
+	}
+
+	if offset != ef.offset {
+		return 0, p9p.ErrBadoffset
+	}
+
+	// Filtered entries make the offsets of the wrapped session differ from
+	// those seen by the client. Keep reading until something is left after
+	// filtering or the directory is exhausted.
+	for n == 0 {
+		nn, err := es.session.Read(ctx, fid, p, ef.inner)
+		if err != nil {
+			return 0, err
+		}
+
+		if nn == 0 {
+			break
+		}
+
+		ef.inner += int64(nn)
+		n, err = es.filter(p9p.NewVersionCodec(p9p.GetVersion(ctx)), ef.path, p[:nn])
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	ef.offset += int64(n)
+	return n, nil
+}
+
+// filter removes hidden entries from the directory entries in p, encoded
+// with codec, in place, returning the remaining length.
+func (es *exportSession) filter(codec p9p.Codec, dir string, p []byte) (int, error) {
+	var n int
+	for off := 0; off < len(p); {
+		if len(p)-off < 2 {
+			return 0, p9p.ErrBotch
+		}
+
+		size := 2 + int(binary.LittleEndian.Uint16(p[off:]))
+		if off+size > len(p) {
+			return 0, p9p.ErrBotch
+		}
+
+		entry := p[off : off+size]
+		off += size
+
+		var d p9p.Dir
+		if err := p9p.DecodeDir(codec, bytes.NewReader(entry), &d); err != nil {
+			return 0, err
+		}
+
+		if es.rules.Access(path.Join(dir, d.Name)) == AccessHidden {
+			continue
+		}
+
+		n += copy(p[n:], entry)
+	}
+
+	return n, nil
+}
+
+func (es *exportSession) Write(ctx context.Context, fid p9p.Fid, p []byte, offset int64) (n int, err error) {
+	if _, err := es.writable(fid, p9p.ErrNowrite); err != nil {
+		return 0, err
+	}
+
+	return es.session.Write(ctx, fid, p, offset)
+}
+
+func (es *exportSession) Open(ctx context.Context, fid p9p.Fid, mode p9p.Flag) (p9p.Qid, uint32, error) {
+	ef, err := es.getFid(fid)
+	if err != nil {
+		return p9p.Qid{}, 0, err
+	}
+
+	if ef.symlink {
+		return p9p.Qid{}, 0, p9p.ErrPerm
+	}
+
+	if mode&3 == p9p.OWRITE || mode&3 == p9p.ORDWR || mode&(p9p.OTRUNC|p9p.ORCLOSE) != 0 {
+		if es.rules.Access(ef.path) != AccessReadWrite {
+			return p9p.Qid{}, 0, p9p.ErrPerm
+		}
+	}
+
+	qid, iounit, err := es.session.Open(ctx, fid, mode)
+	if err != nil {
+		return qid, iounit, err
+	}
+
+	ef.Lock()
+	ef.dir = qid.Type&p9p.QTDIR != 0
+	ef.Unlock()
+
+	return qid, iounit, nil
+}
+
+func (es *exportSession) Create(ctx context.Context, parent p9p.Fid, name string, perm uint32, mode p9p.Flag) (p9p.Qid, uint32, error) {
+	ef, err := es.writable(parent, p9p.ErrPerm)
+	if err != nil {
+		return p9p.Qid{}, 0, err
+	}
+
+	if ef.symlink {
+		return p9p.Qid{}, 0, p9p.ErrPerm
+	}
+
+	p := path.Join(ef.path, name)
+	if es.rules.Access(p) != AccessReadWrite {
+		return p9p.Qid{}, 0, p9p.ErrPerm
+	}
+
+	qid, iounit, err := es.session.Create(ctx, parent, name, perm, mode)
+	if err != nil {
+		return qid, iounit, err
+	}
+
+	// the fid now refers to the new file.
+	es.setFid(parent, &exportFid{path: p, dir: perm&p9p.DMDIR != 0})
+	return qid, iounit, nil
+}
+
+func (es *exportSession) Stat(ctx context.Context, fid p9p.Fid) (p9p.Dir, error) {
+	return es.session.Stat(ctx, fid)
+}
+
+func (es *exportSession) WStat(ctx context.Context, fid p9p.Fid, dir p9p.Dir) error {
+	ef, err := es.writable(fid, p9p.ErrPerm)
+	if err != nil {
+		return err
+	}
+
+	p := ef.path
+	if dir.Name != "" {
+		p = path.Join(path.Dir(ef.path), dir.Name)
+		if es.rules.Access(p) != AccessReadWrite {
+			return p9p.ErrPerm
+		}
+	}
+
+	if err := es.session.WStat(ctx, fid, dir); err != nil {
+		return err
+	}
+
+	ef.Lock()
+	defer ef.Unlock()
+	es.setFid(fid, &exportFid{path: p, dir: ef.dir, symlink: ef.symlink})
+
+	return nil
+}
+
+func (es *exportSession) Version() (msize int, version string) {
+	return es.session.Version()
+}
+
+// exportSessionL passes the 9P2000.L extension messages through to a session
+// that supports them, refusing to set extended attributes or take write
+// locks on paths that are not read-write.
+type exportSessionL struct {
+	*exportSession
+	sessionl p9p.SessionL
+}
+
+func (es *exportSessionL) Statfs(ctx context.Context, fid p9p.Fid) (p9p.StatFS, error) {
+	if _, err := es.getFid(fid); err != nil {
+		return p9p.StatFS{}, err
+	}
+
+	return es.sessionl.Statfs(ctx, fid)
+}
+
+func (es *exportSessionL) Getattr(ctx context.Context, fid p9p.Fid, mask uint64) (p9p.Attr, error) {
+	if _, err := es.getFid(fid); err != nil {
+		return p9p.Attr{}, err
+	}
+
+	return es.sessionl.Getattr(ctx, fid, mask)
+}
+
+func (es *exportSessionL) XattrWalk(ctx context.Context, fid, newfid p9p.Fid, name string) (uint64, error) {
+	ef, err := es.getFid(fid)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := es.sessionl.XattrWalk(ctx, fid, newfid, name)
+	if err != nil {
+		return 0, err
+	}
+
+	// newfid reads the attribute, so it is never a directory.
+	es.setFid(newfid, &exportFid{path: ef.path})
+	return size, nil
+}
+
+func (es *exportSessionL) XattrCreate(ctx context.Context, fid p9p.Fid, name string, size uint64, flags uint32) error {
+	if _, err := es.writable(fid, p9p.ErrPerm); err != nil {
+		return err
+	}
+
+	return es.sessionl.XattrCreate(ctx, fid, name, size, flags)
+}
+
+func (es *exportSessionL) Fsync(ctx context.Context, fid p9p.Fid, datasync bool) error {
+	if _, err := es.getFid(fid); err != nil {
+		return err
+	}
+
+	return es.sessionl.Fsync(ctx, fid, datasync)
+}
+
+// Lock refuses write locks on paths that are not read-write. Read locks and
+// unlocking are allowed, as they leave the file as it is.
+func (es *exportSessionL) Lock(ctx context.Context, fid p9p.Fid, lock p9p.Lock) (uint8, error) {
+	if lock.Type == p9p.LockWrite {
+		if _, err := es.writable(fid, p9p.ErrPerm); err != nil {
+			return p9p.LockError, err
+		}
+	} else if _, err := es.getFid(fid); err != nil {
+		return p9p.LockError, err
+	}
+
+	return es.sessionl.Lock(ctx, fid, lock)
+}
+
+func (es *exportSessionL) GetLock(ctx context.Context, fid p9p.Fid, lock p9p.Lock) (p9p.Lock, error) {
+	if _, err := es.getFid(fid); err != nil {
+		return p9p.Lock{}, err
+	}
+
+	return es.sessionl.GetLock(ctx, fid, lock)
+}
@@ -0,0 +1,339 @@
+package ufs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+func TestExportSession(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	for _, dir := range []string{"scratch", "secrets"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseExportRules(strings.NewReader(`
+# default read-only
+/          ro
+/scratch   rw
+/secrets   hidden
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewExportSession(inner, rules)
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 2, "secrets"); err != p9p.ErrNotfound {
+		t.Fatalf("expected hidden directory to be not found: %v", err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 2, "file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 2, p9p.ORDWR); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error opening read-only file for write: %v", err)
+	}
+
+	if err := session.Remove(ctx, 2); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error removing read-only file: %v", err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 3, "scratch"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Create(ctx, 3, "new", 0644, p9p.OWRITE); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Write(ctx, 3, []byte("data"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 4, p9p.OREAD); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		names []string
+		p     = make([]byte, 8192)
+	)
+	n, err := session.Read(ctx, 4, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rd := bytes.NewReader(p[:n])
+	for {
+		var d p9p.Dir
+		if err := p9p.DecodeDir(p9p.NewCodec(), rd, &d); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "file,scratch" {
+		t.Fatalf("unexpected directory entries: %v", names)
+	}
+
+	if n, err := session.Read(ctx, 4, p, int64(n)); err != nil || n != 0 {
+		t.Fatalf("expected end of directory: %v, %v", n, err)
+	}
+}
+
+func TestExportSessionL(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseExportRules(strings.NewReader("/ ro\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionl, ok := NewExportSession(inner, rules).(p9p.SessionL)
+	if !ok {
+		t.Fatal("export session does not pass through 9P2000.L")
+	}
+
+	if _, err := sessionl.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sessionl.Statfs(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sessionl.Walk(ctx, 1, 2, "file"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sessionl.XattrCreate(ctx, 2, "user.test", 4, 0); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error setting xattr on read-only file: %v", err)
+	}
+
+	if _, _, err := sessionl.Open(ctx, 2, p9p.OREAD); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := p9p.Lock{Type: p9p.LockWrite, Length: 1, ProcID: 1, ClientID: "test"}
+	if _, err := sessionl.Lock(ctx, 2, lock); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error write locking read-only file: %v", err)
+	}
+
+	lock.Type = p9p.LockRead
+	if status, err := sessionl.Lock(ctx, 2, lock); err != nil || status != p9p.LockSuccess {
+		t.Fatalf("read lock: %v, %v", status, err)
+	}
+}
+
+// followSession walks "alias" as a symlink to "secrets", following it like
+// a server resolving symlinks itself.
+type followSession struct {
+	p9p.Session
+}
+
+func (s followSession) Walk(ctx context.Context, fid, newfid p9p.Fid, names ...string) ([]p9p.Qid, error) {
+	resolved := make([]string, len(names))
+	for i, name := range names {
+		if name == "alias" {
+			name = "secrets"
+		}
+		resolved[i] = name
+	}
+
+	qids, err := s.Session.Walk(ctx, fid, newfid, resolved...)
+	for i := range qids {
+		if names[i] == "alias" {
+			qids[i].Type = p9p.QTSYMLINK
+		}
+	}
+
+	return qids, err
+}
+
+// TestExportSymlink ensures that a symlink can't be used to reach a hidden
+// path under another name.
+func TestExportSymlink(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	if err := os.Mkdir(filepath.Join(root, "secrets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "secrets", "key"), []byte("key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseExportRules(strings.NewReader("/secrets hidden\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := NewExportSession(followSession{inner}, rules)
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the walk stops at the symlink, leaving newfid unused.
+	qids, err := session.Walk(ctx, 1, 2, "alias", "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(qids) != 1 {
+		t.Fatalf("expected a partial walk to the symlink, got %v", qids)
+	}
+
+	if _, _, err := session.Open(ctx, 2, p9p.OREAD); err != p9p.ErrUnknownfid {
+		t.Fatalf("expected %v after a partial walk, got %v", p9p.ErrUnknownfid, err)
+	}
+
+	// the symlink may be walked to, but not opened.
+	if _, err := session.Walk(ctx, 1, 3, "alias"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 3, p9p.OREAD); err != p9p.ErrPerm {
+		t.Fatalf("expected %v opening symlink, got %v", p9p.ErrPerm, err)
+	}
+
+	// a fid walked through the symlink can only be clunked.
+	if _, err := session.Walk(ctx, 1, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 4, 4, "alias", "key"); err != p9p.ErrWalknodir {
+		t.Fatalf("expected %v, got %v", p9p.ErrWalknodir, err)
+	}
+
+	if _, _, err := session.Open(ctx, 4, p9p.OREAD); err != p9p.ErrUnknownfid {
+		t.Fatalf("expected %v, got %v", p9p.ErrUnknownfid, err)
+	}
+}
+
+// TestExportSessionUnix reads a filtered directory on a 9P2000.u connection,
+// which encodes the entries with the extended fields.
+func TestExportSessionUnix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := t.TempDir()
+	for _, dir := range []string{"public", "secrets"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rules, err := ParseExportRules(strings.NewReader("/secrets hidden\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cconn, sconn := net.Pipe()
+	defer cconn.Close()
+	go p9p.ServeConn(ctx, sconn, p9p.Dispatch(NewExportSession(inner, rules)), p9p.WithUnix())
+
+	session, err := p9p.NewSession(ctx, cconn, p9p.WithUnix())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, version := session.Version(); version != p9p.UnixVersion {
+		t.Fatalf("unexpected version %v", version)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 1, p9p.OREAD); err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 8192)
+	n, err := session.Read(ctx, 1, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	rd := bytes.NewReader(p[:n])
+	for {
+		var d p9p.Dir
+		if err := p9p.DecodeDir(p9p.NewUnixCodec(), rd, &d); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatal(err)
+		}
+
+		if d.NUid != uint32(os.Getuid()) {
+			t.Fatalf("unexpected n_uid for %v: %v", d.Name, d.NUid)
+		}
+		names = append(names, d.Name)
+	}
+
+	if strings.Join(names, ",") != "public" {
+		t.Fatalf("unexpected directory entries: %v", names)
+	}
+}
@@ -0,0 +1,304 @@
+package ufs
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// This file implements the 9P2000.L extensions from p9p.SessionL.
+
+var _ p9p.SessionL = &session{}
+
+// maxXattrSize limits the size of attribute values accepted by
+// XattrCreate, matching XATTR_SIZE_MAX on Linux.
+const maxXattrSize = 64 << 10
+
+// xattrRef holds the value of an extended attribute for a fid prepared by
+// XattrWalk or XattrCreate.
+type xattrRef struct {
+	name   string
+	value  []byte
+	create bool // set the attribute when the fid is clunked
+	flags  int
+}
+
+func (x *xattrRef) readAt(p []byte, offset int64) (int, error) {
+	if offset >= int64(len(x.value)) {
+		return 0, nil
+	}
+
+	return copy(p, x.value[offset:]), nil
+}
+
+func (x *xattrRef) writeAt(p []byte, offset int64) (int, error) {
+	if !x.create {
+		return 0, p9p.ErrNowrite
+	}
+
+	if offset < 0 || offset+int64(len(p)) > int64(len(x.value)) {
+		return 0, p9p.ErrBadoffset
+	}
+
+	return copy(x.value[offset:], p), nil
+}
+
+// commit returns a function that sets or, for an empty value, removes the
+// attribute on path.
+func (x *xattrRef) commit(path string) func() error {
+	return func() error {
+		if len(x.value) == 0 {
+			return removexattr(path, x.name)
+		}
+
+		return setxattr(path, x.name, x.value, x.flags)
+	}
+}
+
+func (sess *session) Statfs(ctx context.Context, fid p9p.Fid) (p9p.StatFS, error) {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return p9p.StatFS{}, err
+	}
+
+	var st p9p.StatFS
+	err = sess.as(ref.User, func() (err error) {
+		st, err = statfs(ref.Path)
+		return err
+	})
+
+	return st, err
+}
+
+func (sess *session) Getattr(ctx context.Context, fid p9p.Fid, mask uint64) (p9p.Attr, error) {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return p9p.Attr{}, err
+	}
+
+	var info os.FileInfo
+	err = sess.as(ref.User, func() (err error) {
+		info, err = os.Lstat(ref.Path)
+		return err
+	})
+	if err != nil {
+		return p9p.Attr{}, err
+	}
+
+	return maskAttr(attrFromInfo(info), mask), nil
+}
+
+// maskAttr clears the fields of attr not requested by mask. The qid is
+// always returned.
+func maskAttr(attr p9p.Attr, mask uint64) p9p.Attr {
+	attr.Valid &= mask
+	if attr.Valid&p9p.GetattrMode == 0 {
+		attr.Mode = 0
+	}
+	if attr.Valid&p9p.GetattrNLink == 0 {
+		attr.NLink = 0
+	}
+	if attr.Valid&p9p.GetattrUID == 0 {
+		attr.UID = 0
+	}
+	if attr.Valid&p9p.GetattrGID == 0 {
+		attr.GID = 0
+	}
+	if attr.Valid&p9p.GetattrRDev == 0 {
+		attr.RDev = 0
+	}
+	if attr.Valid&p9p.GetattrATime == 0 {
+		attr.ATime = p9p.Timespec{}
+	}
+	if attr.Valid&p9p.GetattrMTime == 0 {
+		attr.MTime = p9p.Timespec{}
+	}
+	if attr.Valid&p9p.GetattrCTime == 0 {
+		attr.CTime = p9p.Timespec{}
+	}
+	if attr.Valid&p9p.GetattrSize == 0 {
+		attr.Size = 0
+	}
+	if attr.Valid&p9p.GetattrBlocks == 0 {
+		attr.BlkSize, attr.Blocks = 0, 0
+	}
+
+	return attr
+}
+
+func (sess *session) XattrWalk(ctx context.Context, fid, newfid p9p.Fid, name string) (uint64, error) {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return 0, err
+	}
+
+	// the attributes of the target would be read, which may be out of the
+	// root.
+	if _, info := ref.snapshot(); info.Mode()&os.ModeSymlink != 0 {
+		return 0, errSymlink
+	}
+
+	var value []byte
+	if err := sess.as(ref.User, func() (err error) {
+		if name == "" {
+			value, err = listxattr(ref.Path)
+		} else {
+			value, err = getxattr(ref.Path, name)
+		}
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	newref, err := sess.newRef(newfid, ref.Path, ref.User)
+	if err != nil {
+		return 0, err
+	}
+
+	newref.Lock()
+	defer newref.Unlock()
+	newref.xattr = &xattrRef{name: name, value: value}
+
+	return uint64(len(value)), nil
+}
+
+func (sess *session) XattrCreate(ctx context.Context, fid p9p.Fid, name string, size uint64, flags uint32) error {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		return errIllegalName
+	}
+
+	if size > maxXattrSize {
+		return syscall.E2BIG
+	}
+
+	_, info := ref.snapshot()
+	if info.Mode()&os.ModeSymlink != 0 {
+		return errSymlink
+	}
+
+	if err := sess.check(ref.User, info, p9p.DMWRITE); err != nil {
+		return err
+	}
+
+	ref.Lock()
+	defer ref.Unlock()
+	ref.xattr = &xattrRef{
+		name:   name,
+		value:  make([]byte, int(size)),
+		create: true,
+		flags:  int(flags),
+	}
+
+	return nil
+}
+
+func (sess *session) Fsync(ctx context.Context, fid p9p.Fid, datasync bool) error {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return err
+	}
+
+	ref.Lock()
+	defer ref.Unlock()
+	if ref.File == nil {
+		return p9p.MessageRerror{Ename: "no file open"}
+	}
+
+	if datasync {
+		return fdatasync(ref.File)
+	}
+
+	return ref.File.Sync()
+}
+
+func (sess *session) Lock(ctx context.Context, fid p9p.Fid, lock p9p.Lock) (uint8, error) {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return p9p.LockError, err
+	}
+
+	ref.Lock()
+	defer ref.Unlock()
+	if ref.File == nil {
+		return p9p.LockError, p9p.MessageRerror{Ename: "no file open"}
+	}
+
+	// Locks are never taken with a blocking call, since that would hold up
+	// the handler. Clients asking to block will retry. The lock is owned by
+	// the open file of the fid, so ProcID and ClientID aren't needed to
+	// tell the clients apart.
+	flk := flock(lock)
+	if err := setlock(ref.File, &flk); err != nil {
+		if err == syscall.EAGAIN || err == syscall.EACCES {
+			return p9p.LockBlocked, nil
+		}
+
+		return p9p.LockError, err
+	}
+
+	return p9p.LockSuccess, nil
+}
+
+func (sess *session) GetLock(ctx context.Context, fid p9p.Fid, lock p9p.Lock) (p9p.Lock, error) {
+	ref, err := sess.getRef(fid)
+	if err != nil {
+		return p9p.Lock{}, err
+	}
+
+	ref.Lock()
+	defer ref.Unlock()
+	if ref.File == nil {
+		return p9p.Lock{}, p9p.MessageRerror{Ename: "no file open"}
+	}
+
+	flk := flock(lock)
+	if err := getlock(ref.File, &flk); err != nil {
+		return p9p.Lock{}, err
+	}
+
+	if flk.Type == syscall.F_UNLCK {
+		lock.Type = p9p.LockUnlock
+		return lock, nil
+	}
+
+	// the owner of an open file lock is not a process, so the conflict
+	// carries no ProcID or ClientID.
+	conflict := p9p.Lock{
+		Type:   p9p.LockRead,
+		Start:  uint64(flk.Start),
+		Length: uint64(flk.Len),
+	}
+
+	if flk.Type == syscall.F_WRLCK {
+		conflict.Type = p9p.LockWrite
+	}
+
+	return conflict, nil
+}
+
+// flock converts the lock to a struct for fcntl.
+func flock(lock p9p.Lock) syscall.Flock_t {
+	flk := syscall.Flock_t{
+		Type:   syscall.F_UNLCK,
+		Whence: io.SeekStart,
+		Start:  int64(lock.Start),
+		Len:    int64(lock.Length),
+	}
+
+	switch lock.Type {
+	case p9p.LockRead:
+		flk.Type = syscall.F_RDLCK
+	case p9p.LockWrite:
+		flk.Type = syscall.F_WRLCK
+	}
+
+	return flk
+}
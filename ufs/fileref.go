@@ -13,6 +13,12 @@ type FileRef struct {
 	Info    p9p.Dir
 	File    *os.File
 	Readdir *p9p.Readdir
+
+	// User is the identity of the attach that the fid descends from.
+	User *User
+
+	info  os.FileInfo // from the last stat, for permission checks
+	xattr *xattrRef   // set for fids from XattrWalk and XattrCreate
 }
 
 func (f *FileRef) Stat() error {
@@ -21,12 +27,21 @@ func (f *FileRef) Stat() error {
 	return f.statLocked()
 }
 
+// snapshot returns the path and the info from the last stat, which may be
+// updated by concurrent requests on the fid.
+func (f *FileRef) snapshot() (string, os.FileInfo) {
+	f.Lock()
+	defer f.Unlock()
+	return f.Path, f.info
+}
+
 func (f *FileRef) statLocked() error {
 	info, err := os.Lstat(f.Path)
 	if err != nil {
 		return err
 	}
 
+	f.info = info
 	f.Info = dirFromInfo(info)
 	return nil
 }
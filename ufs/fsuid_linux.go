@@ -0,0 +1,93 @@
+package ufs
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const supportsSetfsuid = true
+
+// runAs calls fn on a locked OS thread with the fsuid, fsgid and
+// supplementary groups of the thread set to those of user. The credentials
+// are restored before the thread is released. If they cannot be restored,
+// the thread stays locked and is terminated by the runtime when the calling
+// goroutine exits.
+func runAs(user *User, fn func() error) error {
+	runtime.LockOSThread()
+
+	groups, err := getgroups()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	if err := setfsids(user.UID, user.GID, user.Groups); err != nil {
+		if rerr := setfsids(uint32(os.Geteuid()), uint32(os.Getegid()), groups); rerr == nil {
+			runtime.UnlockOSThread()
+		}
+		return err
+	}
+
+	err = fn()
+
+	if rerr := setfsids(uint32(os.Geteuid()), uint32(os.Getegid()), groups); rerr != nil {
+		// leave the thread locked so it will not be reused.
+		return err
+	}
+
+	runtime.UnlockOSThread()
+	return err
+}
+
+// setfsids sets the credentials of the current thread. We make the raw
+// system calls, rather than the syscall package helpers, since those apply
+// the change to every thread in the process.
+func setfsids(uid, gid uint32, groups []uint32) error {
+	var p unsafe.Pointer
+	if len(groups) > 0 {
+		p = unsafe.Pointer(&groups[0])
+	}
+
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_SETGROUPS, uintptr(len(groups)), uintptr(p), 0); errno != 0 {
+		return errno
+	}
+
+	if err := setfsid(syscall.SYS_SETFSGID, gid); err != nil {
+		return err
+	}
+
+	return setfsid(syscall.SYS_SETFSUID, uid)
+}
+
+// setfsid calls setfsuid or setfsgid, selected by trap. These never return
+// an error, so we detect failure by reading back the current value.
+func setfsid(trap uintptr, id uint32) error {
+	syscall.RawSyscall(trap, uintptr(id), 0, 0)
+	cur, _, _ := syscall.RawSyscall(trap, uintptr(^uint32(0)), 0, 0)
+	if uint32(cur) != id {
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+func getgroups() ([]uint32, error) {
+	n, _, errno := syscall.RawSyscall(syscall.SYS_GETGROUPS, 0, 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	groups := make([]uint32, n)
+	n, _, errno = syscall.RawSyscall(syscall.SYS_GETGROUPS, n, uintptr(unsafe.Pointer(&groups[0])), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return groups[:n], nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package ufs
+
+import "errors"
+
+const supportsSetfsuid = false
+
+func runAs(user *User, fn func() error) error {
+	return errors.New("ufs: setfsuid not supported on this platform")
+}
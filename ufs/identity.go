@@ -0,0 +1,152 @@
+package ufs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// Identity selects how the user attaching to a session is applied to
+// filesystem operations.
+type Identity int
+
+const (
+	// IdentityNone performs all operations with the credentials of the server
+	// process. Apart from being required, the uname is ignored.
+	IdentityNone Identity = iota
+
+	// IdentityCheck checks permissions in-process against the mode, uid and
+	// gid of the file and the user resolved from the uname. Operations are
+	// still carried out with the credentials of the server process.
+	IdentityCheck
+
+	// IdentitySetfsuid carries out each operation on a locked OS thread with
+	// the fsuid and fsgid set to the attaching user, leaving the permission
+	// checks to the kernel. Only supported on Linux and requires the server
+	// to hold CAP_SETUID and CAP_SETGID.
+	IdentitySetfsuid
+)
+
+func (id Identity) String() string {
+	switch id {
+	case IdentityNone:
+		return "none"
+	case IdentityCheck:
+		return "check"
+	case IdentitySetfsuid:
+		return "setfsuid"
+	}
+
+	return "unknown"
+}
+
+// ParseIdentity returns the Identity named by s, as returned by
+// Identity.String.
+func ParseIdentity(s string) (Identity, error) {
+	for _, id := range []Identity{IdentityNone, IdentityCheck, IdentitySetfsuid} {
+		if id.String() == s {
+			return id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("ufs: unknown identity policy %q", s)
+}
+
+// as runs fn with the credentials of user, if the session impersonates
+// users.
+func (sess *session) as(user *User, fn func() error) error {
+	if sess.identity != IdentitySetfsuid || user == nil {
+		return fn()
+	}
+
+	return runAs(user, fn)
+}
+
+// check returns ErrPerm if the session checks permissions in-process and
+// user is not allowed the access in want (a combination of DMREAD, DMWRITE
+// and DMEXEC) to the file described by info.
+func (sess *session) check(user *User, info os.FileInfo, want uint32) error {
+	if sess.identity != IdentityCheck || user == nil {
+		return nil
+	}
+
+	if !access(user, info, want) {
+		return p9p.ErrPerm
+	}
+
+	return nil
+}
+
+// checkParent is like check but applies to the parent directory of path.
+func (sess *session) checkParent(user *User, path string, want uint32) error {
+	if sess.identity != IdentityCheck || user == nil {
+		return nil
+	}
+
+	info, err := os.Lstat(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	return sess.check(user, info, want)
+}
+
+// checkOwner returns ErrPerm if the session checks permissions in-process
+// and user neither owns the file nor is root.
+func (sess *session) checkOwner(user *User, info os.FileInfo) error {
+	if sess.identity != IdentityCheck || user == nil {
+		return nil
+	}
+
+	if user.UID != 0 && info.Sys().(*syscall.Stat_t).Uid != user.UID {
+		return p9p.ErrPerm
+	}
+
+	return nil
+}
+
+// access reports whether user is allowed the access in want to the file
+// described by info, following the usual owner, group and other rules.
+func access(user *User, info os.FileInfo, want uint32) bool {
+	perm := uint32(info.Mode().Perm())
+
+	if user.UID == 0 {
+		// root may read and write anything, but may only execute files
+		// with at least one execute bit set.
+		return want&p9p.DMEXEC == 0 || info.IsDir() || perm&0111 != 0
+	}
+
+	st := info.Sys().(*syscall.Stat_t)
+	switch {
+	case st.Uid == user.UID:
+		perm >>= 6
+	case user.inGroup(st.Gid):
+		perm >>= 3
+	}
+
+	return perm&want == want
+}
+
+// openperm returns the permissions required to open a file with mode.
+func openperm(mode p9p.Flag) uint32 {
+	var want uint32
+	switch mode & 3 {
+	case p9p.OREAD:
+		want = p9p.DMREAD
+	case p9p.OWRITE:
+		want = p9p.DMWRITE
+	case p9p.ORDWR:
+		want = p9p.DMREAD | p9p.DMWRITE
+	case p9p.OEXEC:
+		want = p9p.DMEXEC
+	}
+
+	if mode&p9p.OTRUNC != 0 {
+		want |= p9p.DMWRITE
+	}
+
+	return want
+}
@@ -0,0 +1,74 @@
+package ufs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+func TestIdentityCheck(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		root  = t.TempDir()
+		owner = User{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+		other = User{UID: owner.UID + 1000, GID: owner.GID + 1000}
+	)
+
+	if err := os.Mkdir(filepath.Join(root, "private"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "readonly"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(ctx, root,
+		WithIdentity(IdentityCheck),
+		WithUsers(StaticUsers{"owner": owner, "other": other}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "other", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 2, "private"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 2, 7, "anything"); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error walking within private directory: %v", err)
+	}
+
+	if qids, err := session.Walk(ctx, 1, 7, "private", "anything"); err != nil || len(qids) != 1 {
+		t.Fatalf("expected partial walk: %v, %v", qids, err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 3, "readonly"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 3, p9p.OWRITE); err != p9p.ErrPerm {
+		t.Fatalf("expected permission error opening for write: %v", err)
+	}
+
+	if _, _, err := session.Open(ctx, 3, p9p.OREAD); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 4, p9p.NOFID, "nobody", ""); err == nil {
+		t.Fatal("expected error attaching unknown user")
+	}
+
+	if _, err := session.Attach(ctx, 5, p9p.NOFID, "owner", "/../.."); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 5, 6, "..", "private"); err != nil {
+		t.Fatalf("walking .. from the root should stay at the root: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build linux
+// +build linux
+
+package ufs
+
+import (
+	"os"
+	"syscall"
+)
+
+const supportsLocks = true
+
+// The commands for open file description locks, which the syscall package
+// doesn't define.
+const (
+	fOFDGetlk = 36 // F_OFD_GETLK
+	fOFDSetlk = 37 // F_OFD_SETLK
+)
+
+// setlock takes or releases a lock on f. The lock is owned by the open file
+// rather than the server process, so the locks of different fids conflict
+// and closing one fid leaves the locks of the others in place.
+func setlock(f *os.File, flk *syscall.Flock_t) error {
+	return syscall.FcntlFlock(f.Fd(), fOFDSetlk, flk)
+}
+
+// getlock replaces flk with the first lock on f conflicting with it, or sets
+// its type to F_UNLCK if there is none.
+func getlock(f *os.File, flk *syscall.Flock_t) error {
+	return syscall.FcntlFlock(f.Fd(), fOFDGetlk, flk)
+}
+
+// fdatasync flushes the data of f, and only the metadata needed to read it.
+func fdatasync(f *os.File) error {
+	return syscall.Fdatasync(int(f.Fd()))
+}
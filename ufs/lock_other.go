@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package ufs
+
+import (
+	"os"
+	"syscall"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// Locks need open file description locks, as the process locks of fcntl
+// would never conflict between fids.
+const supportsLocks = false
+
+var errNoLock = p9p.MessageRerror{Ename: "locks not supported"}
+
+func setlock(f *os.File, flk *syscall.Flock_t) error {
+	return errNoLock
+}
+
+func getlock(f *os.File, flk *syscall.Flock_t) error {
+	return errNoLock
+}
+
+func fdatasync(f *os.File) error {
+	return f.Sync()
+}
@@ -2,12 +2,13 @@ package ufs
 
 import (
 	"context"
+	"errors"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 
@@ -15,21 +16,71 @@ import (
 )
 
 type session struct {
-	sync.Mutex
+	mu      sync.Mutex // protects refs
 	rootRef *FileRef
 	refs    map[p9p.Fid]*FileRef
+
+	identity Identity
+	users    UserMap
+	squash   bool
+}
+
+// Option configures a session created with NewSession.
+type Option func(*session) error
+
+// WithIdentity sets the policy for applying the identity of the attaching
+// user to filesystem operations. The default is IdentityNone.
+func WithIdentity(identity Identity) Option {
+	return func(sess *session) error {
+		sess.identity = identity
+		return nil
+	}
+}
+
+// WithUsers sets the map used to resolve unames to local users. The default
+// is SystemUsers.
+func WithUsers(users UserMap) Option {
+	return func(sess *session) error {
+		sess.users = users
+		return nil
+	}
+}
+
+// WithSquash maps every attaching user to "nobody", regardless of uname. It
+// requires an identity policy other than IdentityNone.
+func WithSquash() Option {
+	return func(sess *session) error {
+		sess.squash = true
+		return nil
+	}
 }
 
-func NewSession(ctx context.Context, root string) (p9p.Session, error) {
-	return &session{
-		rootRef: &FileRef{Path: root},
+func NewSession(ctx context.Context, root string, opts ...Option) (p9p.Session, error) {
+	sess := &session{
+		rootRef: &FileRef{Path: filepath.Clean(root)},
 		refs:    make(map[p9p.Fid]*FileRef),
-	}, nil
+		users:   SystemUsers(),
+	}
+
+	for _, opt := range opts {
+		if err := opt(sess); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case sess.identity == IdentitySetfsuid && !supportsSetfsuid:
+		return nil, errors.New("ufs: setfsuid not supported on this platform")
+	case sess.squash && sess.identity == IdentityNone:
+		return nil, errors.New("ufs: squashing users requires an identity policy")
+	}
+
+	return sess, nil
 }
 
 func (sess *session) getRef(fid p9p.Fid) (*FileRef, error) {
-	sess.Lock()
-	defer sess.Unlock()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
 
 	if fid == p9p.NOFID {
 		return nil, p9p.ErrUnknownfid
@@ -40,16 +91,16 @@ func (sess *session) getRef(fid p9p.Fid) (*FileRef, error) {
 		return nil, p9p.ErrUnknownfid
 	}
 
-	if err := ref.Stat(); err != nil {
+	if err := sess.as(ref.User, ref.Stat); err != nil {
 		return nil, err
 	}
 
 	return ref, nil
 }
 
-func (sess *session) newRef(fid p9p.Fid, path string) (*FileRef, error) {
-	sess.Lock()
-	defer sess.Unlock()
+func (sess *session) newRef(fid p9p.Fid, path string, user *User) (*FileRef, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
 
 	if fid == p9p.NOFID {
 		return nil, p9p.ErrUnknownfid
@@ -60,8 +111,8 @@ func (sess *session) newRef(fid p9p.Fid, path string) (*FileRef, error) {
 		return nil, p9p.ErrDupfid
 	}
 
-	ref := &FileRef{Path: path}
-	if err := ref.Stat(); err != nil {
+	ref := &FileRef{Path: path, User: user}
+	if err := sess.as(user, ref.Stat); err != nil {
 		return nil, err
 	}
 
@@ -69,27 +120,120 @@ func (sess *session) newRef(fid p9p.Fid, path string) (*FileRef, error) {
 	return ref, nil
 }
 
+// lookupUser resolves uname to the identity used for the attach. No
+// identity is needed if the session doesn't apply one.
+func (sess *session) lookupUser(uname string) (*User, error) {
+	if sess.identity == IdentityNone {
+		return nil, nil
+	}
+
+	if sess.squash {
+		if u, err := sess.users.Lookup(Nobody.Name); err == nil {
+			return u, nil
+		}
+
+		nobody := Nobody
+		return &nobody, nil
+	}
+
+	return sess.users.Lookup(uname)
+}
+
+// join returns the path of name within dir, never escaping the root of the
+// session as text. Walking ".." from the root stays at the root. The kernel
+// still follows symlinks in the path, so paths are only built by walk, which
+// refuses to walk past them.
+func (sess *session) join(dir, name string) string {
+	root := sess.rootRef.Path
+	rel, err := filepath.Rel(root, filepath.Join(dir, name))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return root
+	}
+
+	return filepath.Join(root, rel)
+}
+
+// walk returns the path and info of name within the directory at path,
+// described by info. Symlinks are never walked past, as they may point out of
+// the root, and the permissions checked would be those of the link.
+func (sess *session) walk(user *User, path string, info os.FileInfo, name string) (string, os.FileInfo, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return "", nil, p9p.ErrWalknodir
+	}
+
+	if err := sess.check(user, info, p9p.DMEXEC); err != nil {
+		return "", nil, err
+	}
+
+	if name != ".." && !validName(name) {
+		return "", nil, p9p.ErrNotfound
+	}
+
+	newpath := sess.join(path, name)
+	newinfo, err := os.Lstat(newpath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return newpath, newinfo, nil
+}
+
+// validName returns true if name may be used as a single path element.
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+var (
+	errIllegalName = p9p.MessageRerror{Ename: "illegal name"}
+	errOpened      = p9p.MessageRerror{Ename: "fid already open"}
+	errSymlink     = p9p.MessageRerror{Ename: "is a symbolic link"}
+)
+
 func (sess *session) Auth(ctx context.Context, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
-	// TODO: AuthInit?
-	return p9p.Qid{}, nil //p9p.MessageRerror{Ename: "no auth"}
+	// Authentication is provided by wrapping the session with
+	// p9p.NewAuthSession.
+	return p9p.Qid{}, p9p.ErrNoauth
 }
 
 func (sess *session) Attach(ctx context.Context, fid, afid p9p.Fid, uname, aname string) (p9p.Qid, error) {
+	if info, ok := p9p.GetAuthInfo(ctx); ok {
+		uname = info.Uname
+	}
+
 	if uname == "" {
 		return p9p.Qid{}, p9p.MessageRerror{Ename: "no user"}
 	}
 
-	// TODO: AuthCheck?
+	user, err := sess.lookupUser(uname)
+	if err != nil {
+		return p9p.Qid{}, err
+	}
+
+	// aname selects a directory within the root, rather than on the host,
+	// walked like any other path.
+	path := sess.rootRef.Path
+	if err := sess.as(user, func() error {
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range strings.Split(aname, "/") {
+			if name == "" || name == "." {
+				continue
+			}
 
-	// if afid > 0 {
-	// 	return p9p.Qid{}, p9p.MessageRerror{Ename: "attach: no auth"}
-	// }
+			if path, info, err = sess.walk(user, path, info, name); err != nil {
+				return err
+			}
+		}
 
-	if aname == "" {
-		aname = sess.rootRef.Path
+		return nil
+	}); err != nil {
+		return p9p.Qid{}, err
 	}
 
-	ref, err := sess.newRef(fid, aname)
+	ref, err := sess.newRef(fid, path, user)
 	if err != nil {
 		return p9p.Qid{}, err
 	}
@@ -109,9 +253,14 @@ func (sess *session) Clunk(ctx context.Context, fid p9p.Fid) error {
 		ref.File.Close()
 	}
 
-	sess.Lock()
-	defer sess.Unlock()
+	sess.mu.Lock()
 	delete(sess.refs, fid)
+	sess.mu.Unlock()
+
+	if ref.xattr != nil && ref.xattr.create {
+		// the value is complete, so now we can set the attribute.
+		return sess.as(ref.User, ref.xattr.commit(ref.Path))
+	}
 
 	return nil
 }
@@ -124,9 +273,14 @@ func (sess *session) Remove(ctx context.Context, fid p9p.Fid) error {
 		return err
 	}
 
-	// TODO: check write perms on parent
+	path, _ := ref.snapshot()
+	if err := sess.checkParent(ref.User, path, p9p.DMWRITE|p9p.DMEXEC); err != nil {
+		return err
+	}
 
-	return os.Remove(ref.Path)
+	return sess.as(ref.User, func() error {
+		return os.Remove(path)
+	})
 }
 
 func (sess *session) Walk(ctx context.Context, fid p9p.Fid, newfid p9p.Fid, names ...string) ([]p9p.Qid, error) {
@@ -137,23 +291,42 @@ func (sess *session) Walk(ctx context.Context, fid p9p.Fid, newfid p9p.Fid, name
 		return qids, err
 	}
 
-	newref, err := sess.newRef(newfid, ref.Path)
-	if err != nil {
-		return qids, err
-	}
+	path, info := ref.snapshot()
+	err = sess.as(ref.User, func() error {
+		for _, name := range names {
+			newpath, newinfo, err := sess.walk(ref.User, path, info, name)
+			if err != nil {
+				return err
+			}
 
-	path := newref.Path
-	for _, name := range names {
-		newpath := filepath.Join(path, name)
-		r := &FileRef{Path: newpath}
-		if err := r.Stat(); err != nil {
-			break
+			qids = append(qids, dirFromInfo(newinfo).Qid)
+			path, info = newpath, newinfo
 		}
-		qids = append(qids, r.Info.Qid)
-		path = newpath
+
+		return nil
+	})
+
+	if err != nil && len(qids) == 0 {
+		// failing the first element is an error.
+		return nil, err
+	}
+
+	if len(qids) < len(names) {
+		// partial walk: newfid is left untouched.
+		return qids, nil
+	}
+
+	if newfid == fid {
+		ref.Lock()
+		defer ref.Unlock()
+		ref.Path = path
+		return qids, ref.statLocked()
+	}
+
+	if _, err := sess.newRef(newfid, path, ref.User); err != nil {
+		return nil, err
 	}
 
-	newref.Path = path
 	return qids, nil
 }
 
@@ -166,17 +339,21 @@ func (sess *session) Read(ctx context.Context, fid p9p.Fid, p []byte, offset int
 	ref.Lock()
 	defer ref.Unlock()
 
+	if ref.xattr != nil {
+		return ref.xattr.readAt(p, offset)
+	}
+
+	if ref.File == nil {
+		return 0, p9p.MessageRerror{Ename: "no file open"} //p9p.ErrClosed
+	}
+
 	if ref.IsDir() {
-		if offset == 0 && ref.Readdir == nil {
-			files, err := ioutil.ReadDir(ref.Path)
-			if err != nil {
+		if offset == 0 {
+			// (re)start reading from the beginning of the directory.
+			if _, err := ref.File.Seek(0, io.SeekStart); err != nil {
 				return 0, err
 			}
-			var dirs []p9p.Dir
-			for _, info := range files {
-				dirs = append(dirs, dirFromInfo(info))
-			}
-			ref.Readdir = p9p.NewFixedReaddir(p9p.NewCodec(), dirs)
+			ref.Readdir = p9p.NewReaddir(p9p.NewVersionCodec(p9p.GetVersion(ctx)), readdir(ref.File))
 		}
 		if ref.Readdir == nil {
 			return 0, p9p.ErrBadoffset
@@ -184,10 +361,6 @@ func (sess *session) Read(ctx context.Context, fid p9p.Fid, p []byte, offset int
 		return ref.Readdir.Read(ctx, p, offset)
 	}
 
-	if ref.File == nil {
-		return 0, p9p.MessageRerror{Ename: "no file open"} //p9p.ErrClosed
-	}
-
 	n, err = ref.File.ReadAt(p, offset)
 	if err != nil && err != io.EOF {
 		return n, err
@@ -203,6 +376,10 @@ func (sess *session) Write(ctx context.Context, fid p9p.Fid, p []byte, offset in
 
 	ref.Lock()
 	defer ref.Unlock()
+	if ref.xattr != nil {
+		return ref.xattr.writeAt(p, offset)
+	}
+
 	if ref.File == nil {
 		return 0, p9p.ErrClosed
 	}
@@ -218,12 +395,30 @@ func (sess *session) Open(ctx context.Context, fid p9p.Fid, mode p9p.Flag) (p9p.
 
 	ref.Lock()
 	defer ref.Unlock()
-	f, err := os.OpenFile(ref.Path, oflags(mode), 0)
-	if err != nil {
+
+	if ref.File != nil {
+		return p9p.Qid{}, 0, errOpened
+	}
+
+	if err := sess.check(ref.User, ref.info, openperm(mode)); err != nil {
+		return p9p.Qid{}, 0, err
+	}
+
+	if mode&p9p.ORCLOSE != 0 {
+		if err := sess.checkParent(ref.User, ref.Path, p9p.DMWRITE|p9p.DMEXEC); err != nil {
+			return p9p.Qid{}, 0, err
+		}
+	}
+
+	var f *os.File
+	if err := sess.as(ref.User, func() (err error) {
+		f, err = os.OpenFile(ref.Path, oflags(mode), 0)
+		return err
+	}); err != nil {
 		return p9p.Qid{}, 0, err
 	}
 	ref.File = f
-	return ref.Info.Qid, 0, nil
+	return ref.Info.Qid, iounit(ctx), nil
 }
 
 func (sess *session) Create(ctx context.Context, parent p9p.Fid, name string, perm uint32, mode p9p.Flag) (p9p.Qid, uint32, error) {
@@ -232,25 +427,47 @@ func (sess *session) Create(ctx context.Context, parent p9p.Fid, name string, pe
 		return p9p.Qid{}, 0, err
 	}
 
-	newpath := filepath.Join(ref.Path, name)
+	if !validName(name) {
+		return p9p.Qid{}, 0, errIllegalName
+	}
+
+	path, info := ref.snapshot()
+	if info.Mode()&os.ModeSymlink != 0 {
+		// the file would be created in the target of the link.
+		return p9p.Qid{}, 0, errSymlink
+	}
+
+	if err := sess.check(ref.User, info, p9p.DMWRITE|p9p.DMEXEC); err != nil {
+		return p9p.Qid{}, 0, err
+	}
+
+	newpath := filepath.Join(path, name)
 
 	var file *os.File
-	switch {
-	case perm&p9p.DMDIR != 0:
-		err = os.Mkdir(newpath, os.FileMode(perm&0777))
+	err = sess.as(ref.User, func() (err error) {
+		switch {
+		case perm&p9p.DMDIR != 0:
+			err = os.Mkdir(newpath, os.FileMode(perm&0777))
+
+		case perm&p9p.DMSYMLINK != 0:
+		case perm&p9p.DMNAMEDPIPE != 0:
+		case perm&p9p.DMDEVICE != 0:
+			err = p9p.MessageRerror{Ename: "not implemented"}
+
+		default:
+			file, err = os.OpenFile(newpath, oflags(mode)|os.O_CREATE|os.O_EXCL, os.FileMode(perm&0777))
+		}
 
-	case perm&p9p.DMSYMLINK != 0:
-	case perm&p9p.DMNAMEDPIPE != 0:
-	case perm&p9p.DMDEVICE != 0:
-		err = p9p.MessageRerror{Ename: "not implemented"}
+		if err == nil {
+			sess.chown(ref.User, newpath)
+		}
 
-	default:
-		file, err = os.OpenFile(newpath, oflags(mode)|os.O_CREATE, os.FileMode(perm&0777))
-	}
+		if file == nil && err == nil {
+			file, err = os.OpenFile(newpath, oflags(mode), 0)
+		}
 
-	if file == nil && err == nil {
-		file, err = os.OpenFile(newpath, oflags(mode), 0)
-	}
+		return err
+	})
 
 	if err != nil {
 		return p9p.Qid{}, 0, err
@@ -263,7 +480,18 @@ func (sess *session) Create(ctx context.Context, parent p9p.Fid, name string, pe
 	if err := ref.statLocked(); err != nil {
 		return p9p.Qid{}, 0, err
 	}
-	return ref.Info.Qid, 0, err
+	return ref.Info.Qid, iounit(ctx), err
+}
+
+// chown gives a newly created file to user when permissions are checked
+// in-process by a privileged server. Under setfsuid, the kernel takes care
+// of this.
+func (sess *session) chown(user *User, path string) {
+	if sess.identity != IdentityCheck || user == nil || os.Geteuid() != 0 {
+		return
+	}
+
+	os.Lchown(path, int(user.UID), int(user.GID))
 }
 
 func (sess *session) Stat(ctx context.Context, fid p9p.Fid) (p9p.Dir, error) {
@@ -271,6 +499,9 @@ func (sess *session) Stat(ctx context.Context, fid p9p.Fid) (p9p.Dir, error) {
 	if err != nil {
 		return p9p.Dir{}, err
 	}
+
+	ref.Lock()
+	defer ref.Unlock()
 	return ref.Info, nil
 }
 
@@ -280,75 +511,120 @@ func (sess *session) WStat(ctx context.Context, fid p9p.Fid, dir p9p.Dir) error
 		return err
 	}
 
-	if dir.Mode != ^uint32(0) {
-		// TODO: 9P2000.u: DMSETUID DMSETGID
-		err := os.Chmod(ref.Path, os.FileMode(dir.Mode&0777))
-		if err != nil {
+	// changes to the type, dev, qid and muid are ignored.
+	mask := dir.WStatMask()
+	path, info := ref.snapshot()
+
+	if info.Mode()&os.ModeSymlink != 0 && mask&(p9p.WStatMode|p9p.WStatLength|p9p.WStatAccessTime|p9p.WStatModTime) != 0 {
+		// these would apply to the target of the link.
+		return errSymlink
+	}
+
+	if mask&(p9p.WStatMode|p9p.WStatUID|p9p.WStatGID|p9p.WStatAccessTime|p9p.WStatModTime) != 0 {
+		if err := sess.checkOwner(ref.User, info); err != nil {
 			return err
 		}
 	}
 
-	if dir.UID != "" || dir.GID != "" {
-		usr, err := user.Lookup(dir.UID)
-		if err != nil {
-			return err
+	if mask&p9p.WStatUID != 0 && ref.User != nil && ref.User.UID != 0 && sess.identity == IdentityCheck {
+		// only root may give files away.
+		return p9p.ErrPerm
+	}
+
+	if mask&p9p.WStatName != 0 {
+		if !validName(dir.Name) {
+			return errIllegalName
 		}
-		uid, err := strconv.Atoi(usr.Uid)
-		if err != nil {
+
+		if err := sess.checkParent(ref.User, path, p9p.DMWRITE|p9p.DMEXEC); err != nil {
 			return err
 		}
-		grp, err := user.LookupGroup(dir.GID)
-		if err != nil {
+	}
+
+	if mask&p9p.WStatLength != 0 {
+		if err := sess.check(ref.User, info, p9p.DMWRITE); err != nil {
 			return err
 		}
-		gid, err := strconv.Atoi(grp.Gid)
+	}
+
+	return sess.as(ref.User, func() error {
+		return sess.wstat(ref, dir, mask)
+	})
+}
+
+func (sess *session) wstat(ref *FileRef, dir p9p.Dir, mask p9p.WStatMask) error {
+	if mask&p9p.WStatMode != 0 {
+		// TODO: 9P2000.u: DMSETUID DMSETGID
+		err := os.Chmod(ref.Path, os.FileMode(dir.Mode&0777))
 		if err != nil {
 			return err
 		}
-		if err := os.Chown(ref.Path, uid, gid); err != nil {
+	}
+
+	if mask&(p9p.WStatUID|p9p.WStatGID) != 0 {
+		uid, gid := -1, -1 // unchanged
+		if mask&p9p.WStatUID != 0 {
+			usr, err := user.Lookup(dir.UID)
+			if err != nil {
+				return err
+			}
+			if uid, err = strconv.Atoi(usr.Uid); err != nil {
+				return err
+			}
+		}
+		if mask&p9p.WStatGID != 0 {
+			grp, err := user.LookupGroup(dir.GID)
+			if err != nil {
+				return err
+			}
+			if gid, err = strconv.Atoi(grp.Gid); err != nil {
+				return err
+			}
+		}
+		if err := os.Lchown(ref.Path, uid, gid); err != nil {
 			return err
 		}
 	}
 
-	if dir.Name != "" {
+	if mask&p9p.WStatName != 0 {
 		newpath := filepath.Join(filepath.Dir(ref.Path), dir.Name)
 		if err := syscall.Rename(ref.Path, newpath); err != nil {
-			return nil
+			return err
 		}
 		ref.Lock()
 		defer ref.Unlock()
 		ref.Path = newpath
 	}
 
-	if dir.Length != ^uint64(0) {
+	if mask&p9p.WStatLength != 0 {
 		if err := os.Truncate(ref.Path, int64(dir.Length)); err != nil {
 			return err
 		}
 	}
 
-	// If either mtime or atime need to be changed, then
-	// we must change both.
-	//if dir.ModTime != time.Time{} || dir.AccessTime != ^uint32(0) {
-	// mt, at := time.Unix(int64(dir.Mtime), 0), time.Unix(int64(dir.Atime), 0)
-	// if cmt, cat := (dir.Mtime == ^uint32(0)), (dir.Atime == ^uint32(0)); cmt || cat {
-	// 	st, e := os.Stat(fid.path)
-	// 	if e != nil {
-	// 		req.RespondError(toError(e))
-	// 		return
-	// 	}
-	// 	switch cmt {
-	// 	case true:
-	// 		mt = st.ModTime()
-	// 	default:
-	// 		at = atime(st.Sys().(*syscall.Stat_t))
-	// 	}
-	// }
-	// e := os.Chtimes(fid.path, at, mt)
-	// if e != nil {
-	// 	req.RespondError(toError(e))
-	// 	return
-	// }
-	//}
+	if mask&(p9p.WStatAccessTime|p9p.WStatModTime) != 0 {
+		at, mt := dir.AccessTime, dir.ModTime
+		if mask&p9p.WStatAccessTime == 0 || mask&p9p.WStatModTime == 0 {
+			// Chtimes sets both times, so the one left untouched is
+			// carried over from the file.
+			info, err := os.Stat(ref.Path)
+			if err != nil {
+				return err
+			}
+
+			if mask&p9p.WStatAccessTime == 0 {
+				at = atime(info.Sys().(*syscall.Stat_t))
+			}
+			if mask&p9p.WStatModTime == 0 {
+				mt = info.ModTime()
+			}
+		}
+
+		if err := os.Chtimes(ref.Path, at, mt); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
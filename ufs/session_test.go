@@ -0,0 +1,390 @@
+package ufs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// TestReaddirRewind reads a directory larger than a single read and a
+// single batch, then reads it again from offset 0 on the same fid.
+func TestReaddirRewind(t *testing.T) {
+	const entries = readdirBatch*2 + 3
+
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	for i := 0; i < entries; i++ {
+		if err := os.WriteFile(filepath.Join(root, fmt.Sprintf("file%03d", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := session.Open(ctx, 1, p9p.OREAD); err != nil {
+		t.Fatal(err)
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		var (
+			seen   = map[string]bool{}
+			offset int64
+			p      = make([]byte, 1024)
+		)
+
+		for {
+			n, err := session.Read(ctx, 1, p, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n == 0 {
+				break
+			}
+			offset += int64(n)
+
+			rd := bytes.NewReader(p[:n])
+			for {
+				var d p9p.Dir
+				if err := p9p.DecodeDir(p9p.NewCodec(), rd, &d); err != nil {
+					if err == io.EOF {
+						break
+					}
+					t.Fatal(err)
+				}
+
+				if seen[d.Name] {
+					t.Fatalf("pass %d: duplicate entry %q", pass, d.Name)
+				}
+				seen[d.Name] = true
+			}
+		}
+
+		if len(seen) != entries {
+			t.Fatalf("pass %d: expected %d entries, got %d", pass, entries, len(seen))
+		}
+	}
+}
+
+// TestQidVersion ensures that the qid version changes when a file is
+// modified, even if the size stays the same.
+func TestQidVersion(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	if err := os.WriteFile(filepath.Join(root, "file"), []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 2, "file"); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := session.Stat(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.Qid.Type != p9p.QTFILE || before.UID == "" || before.MUID != before.UID {
+		t.Fatalf("unexpected stat: %v", before)
+	}
+
+	if _, _, err := session.Open(ctx, 2, p9p.OWRITE); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Write(ctx, 2, []byte("bbbb"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := session.Stat(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before.Qid.Version == after.Qid.Version {
+		t.Fatalf("qid version should change on write: %v == %v", before.Qid, after.Qid)
+	}
+}
+
+// TestWStat changes the length and modification time of a file, leaving
+// the rest untouched.
+func TestWStat(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		root  = t.TempDir()
+		path  = filepath.Join(root, "file")
+		mtime = time.Date(2006, 01, 02, 03, 04, 05, 0, time.UTC)
+	)
+
+	if err := os.WriteFile(path, []byte("aaaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Walk(ctx, 1, 2, "file"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := p9p.NewWStatBuilder().
+		SetLength(2).
+		SetTimes(p9p.DontTouchTime, mtime).
+		Dir()
+	if err := session.WStat(ctx, 2, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Size() != 2 || !after.ModTime().Equal(mtime) || after.Mode() != before.Mode() {
+		t.Fatalf("unexpected stat after wstat: size=%v mtime=%v mode=%v", after.Size(), after.ModTime(), after.Mode())
+	}
+
+	if err := session.WStat(ctx, 2, p9p.NewWStatDir()); err != nil {
+		t.Fatalf("wstat that changes nothing failed: %v", err)
+	}
+}
+
+// TestLock exercises the 9P2000.L lock, fsync and statfs calls on open
+// files. Locks are owned by the open file of a fid, so those of different
+// fids conflict.
+func TestLock(t *testing.T) {
+	if !supportsLocks {
+		t.Skip("locks not supported on this platform")
+	}
+
+	var (
+		ctx  = context.Background()
+		root = t.TempDir()
+	)
+
+	if err := os.WriteFile(filepath.Join(root, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionl := session.(p9p.SessionL)
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fid := range []p9p.Fid{2, 3, 4} {
+		if _, err := session.Walk(ctx, 1, fid, "file"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := session.Open(ctx, fid, p9p.ORDWR); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lock := p9p.Lock{Type: p9p.LockWrite, Length: 10, ProcID: 1, ClientID: "test"}
+	if status, err := sessionl.Lock(ctx, 2, lock); err != nil {
+		t.Fatal(err)
+	} else if status != p9p.LockSuccess {
+		t.Fatalf("unexpected lock status: %v", status)
+	}
+
+	// the lock we hold never conflicts with itself.
+	got, err := sessionl.GetLock(ctx, 2, lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Type != p9p.LockUnlock {
+		t.Fatalf("unexpected conflicting lock: %v", got)
+	}
+
+	// another fid on the file conflicts, whatever the ProcID.
+	other := p9p.Lock{Type: p9p.LockRead, Start: 5, Length: 10, ProcID: 2, ClientID: "test"}
+	if status, err := sessionl.Lock(ctx, 3, other); err != nil {
+		t.Fatal(err)
+	} else if status != p9p.LockBlocked {
+		t.Fatalf("expected blocked lock, got %v", status)
+	}
+
+	got, err = sessionl.GetLock(ctx, 3, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Type != p9p.LockWrite || got.Start != 0 || got.Length != 10 {
+		t.Fatalf("unexpected conflicting lock: %v", got)
+	}
+
+	// clunking another fid on the file leaves the lock in place.
+	if err := session.Clunk(ctx, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, err := sessionl.Lock(ctx, 3, other); err != nil {
+		t.Fatal(err)
+	} else if status != p9p.LockBlocked {
+		t.Fatalf("expected blocked lock after clunk, got %v", status)
+	}
+
+	// until the fid holding it is clunked.
+	if err := session.Clunk(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	if status, err := sessionl.Lock(ctx, 3, other); err != nil {
+		t.Fatal(err)
+	} else if status != p9p.LockSuccess {
+		t.Fatalf("unexpected lock status: %v", status)
+	}
+
+	for _, datasync := range []bool{false, true} {
+		if err := sessionl.Fsync(ctx, 3, datasync); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := sessionl.Statfs(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	attr, err := sessionl.Getattr(ctx, 3, p9p.GetattrBasic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attr.Valid&p9p.GetattrBasic != p9p.GetattrBasic || attr.MTime.Time().IsZero() {
+		t.Fatalf("unexpected attributes: %+v", attr)
+	}
+
+	// only the requested fields are returned.
+	attr, err = sessionl.Getattr(ctx, 3, p9p.GetattrMode|p9p.GetattrSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attr.Valid != p9p.GetattrMode|p9p.GetattrSize || attr.Mode == 0 || attr.MTime != (p9p.Timespec{}) || attr.NLink != 0 {
+		t.Fatalf("unexpected masked attributes: %+v", attr)
+	}
+}
+
+// TestWalkSymlink ensures that a symlink pointing out of the root can't be
+// walked through or opened, from a walk or an attach.
+func TestWalkSymlink(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		root    = t.TempDir()
+		outside = t.TempDir()
+	)
+
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := NewSession(ctx, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := session.Attach(ctx, 1, p9p.NOFID, "user", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// the walk stops at the symlink, leaving newfid unused.
+	qids, err := session.Walk(ctx, 1, 2, "out", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(qids) != 1 || qids[0].Type&p9p.QTSYMLINK == 0 {
+		t.Fatalf("expected a partial walk to the symlink, got %v", qids)
+	}
+
+	if _, err := session.Stat(ctx, 2); err == nil {
+		t.Fatal("expected newfid to be unused after a partial walk")
+	}
+
+	// the symlinks themselves may be walked to, but not opened.
+	for fid, name := range map[p9p.Fid]string{3: "out", 4: "link"} {
+		if _, err := session.Walk(ctx, 1, fid, name); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := session.Open(ctx, fid, p9p.OREAD); err == nil {
+			t.Fatalf("expected error opening symlink %v", name)
+		}
+	}
+
+	if _, _, err := session.Create(ctx, 3, "new", 0644, p9p.OWRITE); err != errSymlink {
+		t.Fatalf("expected %v creating in symlink, got %v", errSymlink, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Fatalf("file created out of the root: %v", err)
+	}
+
+	if _, err := session.Walk(ctx, 3, 5, "secret"); err != p9p.ErrWalknodir {
+		t.Fatalf("expected %v walking from symlink, got %v", p9p.ErrWalknodir, err)
+	}
+
+	if _, err := session.Attach(ctx, 6, p9p.NOFID, "user", "out"); err != nil {
+		t.Fatal(err) // the attach is to the symlink itself
+	}
+
+	if _, err := session.Attach(ctx, 7, p9p.NOFID, "user", "out/secret"); err != p9p.ErrWalknodir {
+		t.Fatalf("expected %v attaching through symlink, got %v", p9p.ErrWalknodir, err)
+	}
+}
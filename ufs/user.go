@@ -0,0 +1,108 @@
+package ufs
+
+import (
+	"os/user"
+	"strconv"
+
+	p9p "github.com/docker/go-p9p"
+)
+
+// User describes the local identity used for operations on fids attached by
+// a client.
+type User struct {
+	Name   string
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // supplementary groups
+}
+
+// Nobody is the identity used when squashing users and the local user
+// database has no entry for "nobody".
+var Nobody = User{Name: "nobody", UID: 65534, GID: 65534}
+
+func (u *User) inGroup(gid uint32) bool {
+	if u.GID == gid {
+		return true
+	}
+
+	for _, g := range u.Groups {
+		if g == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UserMap resolves the uname provided in attach to a local user.
+type UserMap interface {
+	Lookup(uname string) (*User, error)
+}
+
+// SystemUsers returns a UserMap backed by the passwd and group databases of
+// the host.
+func SystemUsers() UserMap {
+	return systemUsers{}
+}
+
+type systemUsers struct{}
+
+func (systemUsers) Lookup(uname string) (*User, error) {
+	usr, err := user.Lookup(uname)
+	if err != nil {
+		return nil, errUnknownUser
+	}
+
+	uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	gid, err := strconv.ParseUint(usr.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &User{
+		Name: uname,
+		UID:  uint32(uid),
+		GID:  uint32(gid),
+	}
+
+	gids, err := usr.GroupIds()
+	if err != nil {
+		// not all platforms support group listing; carry on with the primary
+		// group.
+		return u, nil
+	}
+
+	for _, g := range gids {
+		gid, err := strconv.ParseUint(g, 10, 32)
+		if err != nil {
+			continue
+		}
+
+		u.Groups = append(u.Groups, uint32(gid))
+	}
+
+	return u, nil
+}
+
+// StaticUsers is a UserMap backed by a fixed table, keyed by uname.
+type StaticUsers map[string]User
+
+// Lookup implements UserMap.
+func (m StaticUsers) Lookup(uname string) (*User, error) {
+	u, ok := m[uname]
+	if !ok {
+		return nil, errUnknownUser
+	}
+
+	if u.Name == "" {
+		u.Name = uname
+	}
+
+	return &u, nil
+}
+
+var errUnknownUser = p9p.MessageRerror{Ename: "unknown user"}
@@ -1,7 +1,13 @@
 package ufs
 
 import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
 	"os"
+	"os/user"
+	"strconv"
+	"sync"
 	"syscall"
 
 	p9p "github.com/docker/go-p9p"
@@ -9,25 +15,208 @@ import (
 
 func dirFromInfo(info os.FileInfo) p9p.Dir {
 	dir := p9p.Dir{}
+	stat := info.Sys().(*syscall.Stat_t)
 
-	dir.Qid.Path = info.Sys().(*syscall.Stat_t).Ino
-	dir.Qid.Version = uint32(info.ModTime().UnixNano() / 1000000)
+	dir.Qid.Path = stat.Ino
+	dir.Qid.Version = qidVersion(info, stat)
+	dir.Qid.Type = qidType(info.Mode())
 
 	dir.Name = info.Name()
-	dir.Mode = uint32(info.Mode() & 0777)
+	dir.Mode = dirMode(info.Mode())
 	dir.Length = uint64(info.Size())
-	dir.AccessTime = atime(info.Sys().(*syscall.Stat_t))
+	dir.AccessTime = atime(stat)
 	dir.ModTime = info.ModTime()
-	dir.MUID = "none"
+	dir.UID = names.user(stat.Uid)
+	dir.GID = names.group(stat.Gid)
 
-	if info.IsDir() {
-		dir.Qid.Type |= p9p.QTDIR
-		dir.Mode |= p9p.DMDIR
-	}
+	// The last modifier isn't tracked by the host filesystem, so the best
+	// guess is the owner.
+	dir.MUID = dir.UID
+	dir.NUid, dir.NGid, dir.NMuid = stat.Uid, stat.Gid, stat.Uid
 
 	return dir
 }
 
+func attrFromInfo(info os.FileInfo) p9p.Attr {
+	stat := info.Sys().(*syscall.Stat_t)
+
+	return p9p.Attr{
+		Valid: p9p.GetattrBasic,
+		Qid: p9p.Qid{
+			Type:    qidType(info.Mode()),
+			Version: qidVersion(info, stat),
+			Path:    stat.Ino,
+		},
+		Mode:    uint32(stat.Mode),
+		UID:     stat.Uid,
+		GID:     stat.Gid,
+		NLink:   uint64(stat.Nlink),
+		RDev:    uint64(stat.Rdev),
+		Size:    uint64(stat.Size),
+		BlkSize: uint64(stat.Blksize),
+		Blocks:  uint64(stat.Blocks),
+		ATime:   p9p.NewTimespec(atime(stat)),
+		MTime:   p9p.NewTimespec(info.ModTime()),
+		CTime:   p9p.NewTimespec(ctime(stat)),
+	}
+}
+
+// qidVersion derives a version that changes with every modification of the
+// file, by mixing the modification time, change time and size.
+func qidVersion(info os.FileInfo, stat *syscall.Stat_t) uint32 {
+	var p [24]byte
+	binary.LittleEndian.PutUint64(p[0:], uint64(info.ModTime().UnixNano()))
+	binary.LittleEndian.PutUint64(p[8:], uint64(ctime(stat).UnixNano()))
+	binary.LittleEndian.PutUint64(p[16:], uint64(info.Size()))
+
+	h := fnv.New32a()
+	h.Write(p[:])
+	return h.Sum32()
+}
+
+// qidType returns the Qid type for the file mode.
+func qidType(mode os.FileMode) p9p.QType {
+	var qt p9p.QType
+
+	switch {
+	case mode&os.ModeDir != 0:
+		qt |= p9p.QTDIR
+	case mode&os.ModeSymlink != 0:
+		qt |= p9p.QTSYMLINK
+	}
+
+	if mode&os.ModeAppend != 0 {
+		qt |= p9p.QTAPPEND
+	}
+
+	if mode&os.ModeExclusive != 0 {
+		qt |= p9p.QTEXCL
+	}
+
+	if mode&os.ModeTemporary != 0 {
+		qt |= p9p.QTTMP
+	}
+
+	return qt
+}
+
+// dirMode returns the Dir mode for the file mode, including the 9p2000.u
+// extensions for special files.
+func dirMode(mode os.FileMode) uint32 {
+	dm := uint32(mode.Perm())
+
+	for _, bit := range []struct {
+		mode os.FileMode
+		dm   uint32
+	}{
+		{os.ModeDir, p9p.DMDIR},
+		{os.ModeAppend, p9p.DMAPPEND},
+		{os.ModeExclusive, p9p.DMEXCL},
+		{os.ModeTemporary, p9p.DMTMP},
+		{os.ModeSymlink, p9p.DMSYMLINK},
+		{os.ModeDevice, p9p.DMDEVICE},
+		{os.ModeNamedPipe, p9p.DMNAMEDPIPE},
+		{os.ModeSocket, p9p.DMSOCKET},
+		{os.ModeSetuid, p9p.DMSETUID},
+		{os.ModeSetgid, p9p.DMSETGID},
+	} {
+		if mode&bit.mode != 0 {
+			dm |= bit.dm
+		}
+	}
+
+	return dm
+}
+
+// iounit returns the iounit for the msize negotiated on the connection, or
+// zero if it isn't known.
+func iounit(ctx context.Context) uint32 {
+	msize := p9p.GetMSize(ctx)
+	if msize <= p9p.IOHDRSZ {
+		return 0
+	}
+
+	return uint32(msize - p9p.IOHDRSZ)
+}
+
+// names caches the user and group names for ids, from the passwd and group
+// databases.
+var names = &nameCache{
+	users:  map[uint32]string{},
+	groups: map[uint32]string{},
+}
+
+type nameCache struct {
+	mu     sync.Mutex
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func (c *nameCache) user(uid uint32) string {
+	return c.lookup(c.users, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+func (c *nameCache) group(gid uint32) string {
+	return c.lookup(c.groups, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// lookup returns the name for id from m, calling fn on a miss. Ids without
+// a name are reported numerically.
+func (c *nameCache) lookup(m map[uint32]string, id uint32, fn func(id string) (string, error)) string {
+	c.mu.Lock()
+	name, ok := m[id]
+	c.mu.Unlock()
+	if ok {
+		return name
+	}
+
+	sid := strconv.FormatUint(uint64(id), 10)
+	name, err := fn(sid)
+	if err != nil {
+		name = sid
+	}
+
+	c.mu.Lock()
+	m[id] = name
+	c.mu.Unlock()
+
+	return name
+}
+
+// readdirBatch is the number of entries read from a directory at a time.
+const readdirBatch = 128
+
+// readdir returns a function that returns the entries of the open directory
+// f one at a time, pulling them from the directory in batches.
+func readdir(f *os.File) func() (p9p.Dir, error) {
+	var batch []os.FileInfo
+	return func() (p9p.Dir, error) {
+		for len(batch) == 0 {
+			var err error
+			batch, err = f.Readdir(readdirBatch)
+			if err != nil {
+				return p9p.Dir{}, err // io.EOF at the end
+			}
+		}
+
+		info := batch[0]
+		batch = batch[1:]
+		return dirFromInfo(info), nil
+	}
+}
+
 func oflags(mode p9p.Flag) int {
 	flags := 0
 
@@ -53,5 +242,6 @@ func oflags(mode p9p.Flag) int {
 		flags |= os.O_TRUNC
 	}
 
-	return flags
+	// a fid may refer to a symlink, which may point out of the root.
+	return flags | syscall.O_NOFOLLOW
 }
@@ -1,11 +1,38 @@
+//go:build linux
 // +build linux
+
 package ufs
 
 import (
 	"syscall"
 	"time"
+
+	p9p "github.com/docker/go-p9p"
 )
 
 func atime(stat *syscall.Stat_t) time.Time {
 	return time.Unix(stat.Atim.Unix())
 }
+
+func ctime(stat *syscall.Stat_t) time.Time {
+	return time.Unix(stat.Ctim.Unix())
+}
+
+func statfs(path string) (p9p.StatFS, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return p9p.StatFS{}, err
+	}
+
+	return p9p.StatFS{
+		FSType:  uint32(st.Type),
+		BSize:   uint32(st.Bsize),
+		Blocks:  st.Blocks,
+		BFree:   st.Bfree,
+		BAvail:  st.Bavail,
+		Files:   st.Files,
+		FFree:   st.Ffree,
+		FSID:    uint64(uint32(st.Fsid.X__val[0])) | uint64(uint32(st.Fsid.X__val[1]))<<32,
+		NameLen: uint32(st.Namelen),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package ufs
+
+import "syscall"
+
+func getxattr(path, name string) ([]byte, error) {
+	return readxattr(func(p []byte) (int, error) {
+		return syscall.Getxattr(path, name, p)
+	})
+}
+
+func listxattr(path string) ([]byte, error) {
+	return readxattr(func(p []byte) (int, error) {
+		return syscall.Listxattr(path, p)
+	})
+}
+
+// readxattr sizes a buffer for the value returned by fn, retrying if the
+// value grows between calls.
+func readxattr(fn func(p []byte) (int, error)) ([]byte, error) {
+	for {
+		n, err := fn(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if n == 0 {
+			return nil, nil
+		}
+
+		p := make([]byte, n)
+		n, err = fn(p)
+		if err == syscall.ERANGE {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return p[:n], nil
+	}
+}
+
+func setxattr(path, name string, value []byte, flags int) error {
+	return syscall.Setxattr(path, name, value, flags)
+}
+
+func removexattr(path, name string) error {
+	return syscall.Removexattr(path, name)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package ufs
+
+import p9p "github.com/docker/go-p9p"
+
+var errNoXattr = p9p.MessageRerror{Ename: "extended attributes not supported"}
+
+func getxattr(path, name string) ([]byte, error) {
+	return nil, errNoXattr
+}
+
+func listxattr(path string) ([]byte, error) {
+	return nil, errNoXattr
+}
+
+func setxattr(path, name string, value []byte, flags int) error {
+	return errNoXattr
+}
+
+func removexattr(path, name string) error {
+	return errNoXattr
+}
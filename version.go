@@ -14,7 +14,8 @@ import (
 
 // clientnegotiate negiotiates the protocol version using channel, blocking
 // until a response is received. The received value will be the version
-// implemented by the server.
+// implemented by the server, which may fall back to DefaultVersion if the
+// server doesn't support the requested extension of it.
 func clientnegotiate(ctx context.Context, ch Channel, version string) (string, error) {
 	req := newFcall(NOTAG, MessageTversion{
 		MSize:   uint32(ch.MSize()),
@@ -33,7 +34,7 @@ func clientnegotiate(ctx context.Context, ch Channel, version string) (string, e
 	switch v := resp.Message.(type) {
 	case MessageRversion:
 
-		if v.Version != version {
+		if v.Version != version && v.Version != DefaultVersion {
 			// TODO(stevvooe): A stubborn client indeed!
 			return "", fmt.Errorf("unsupported server version: %v", version)
 		}
@@ -53,7 +54,9 @@ func clientnegotiate(ctx context.Context, ch Channel, version string) (string, e
 
 // servernegotiate blocks until a version message is received or a timeout
 // occurs. The msize for the tranport will be set from the negotiation. If
-// negotiate returns nil, a server may proceed with the connection.
+// negotiate returns nil, a server may proceed with the connection using the
+// returned version. The client's version is accepted if it is one of
+// versions, otherwise DefaultVersion is offered.
 //
 // In the future, it might be better to handle the version messages in a
 // separate object that manages the session. Each set of version requests
@@ -61,27 +64,24 @@ func clientnegotiate(ctx context.Context, ch Channel, version string) (string, e
 // outstanding IO is aborted. This is probably slightly racy, in practice with
 // a misbehaved client. The main issue is that we cannot tell which session
 // messages belong to.
-func servernegotiate(ctx context.Context, ch Channel, version string) error {
+func servernegotiate(ctx context.Context, ch Channel, versions ...string) (string, error) {
 	// wait for the version message over the transport.
 	req := new(Fcall)
 	if err := ch.ReadFcall(ctx, req); err != nil {
-		return err
+		return "", err
 	}
 
 	mv, ok := req.Message.(MessageTversion)
 	if !ok {
-		return fmt.Errorf("expected version message: %v", mv)
+		return "", fmt.Errorf("expected version message: %v", mv)
 	}
 
 	respmsg := MessageRversion{
-		Version: version,
+		Version: mv.Version,
 	}
 
-	if mv.Version != version {
-		// TODO(stevvooe): Not the best place to do version handling. We need
-		// to have a way to pass supported versions into this method then have
-		// it return the actual version. For now, respond with 9P2000 for
-		// anything that doesn't match the provided version string.
+	if !containsString(versions, mv.Version) {
+		// Respond with 9P2000 for anything that isn't supported.
 		//
 		// version(9) says "The server may respond with the client’s
 		// version string, or a version string identifying an earlier
@@ -101,12 +101,22 @@ func servernegotiate(ctx context.Context, ch Channel, version string) error {
 
 	resp := newFcall(NOTAG, respmsg)
 	if err := ch.WriteFcall(ctx, resp); err != nil {
-		return err
+		return "", err
 	}
 
 	if respmsg.Version == "unknown" {
-		return fmt.Errorf("bad version negotiation")
+		return "", fmt.Errorf("bad version negotiation")
+	}
+
+	return respmsg.Version, nil
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
 	}
 
-	return nil
+	return false
 }
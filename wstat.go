@@ -0,0 +1,133 @@
+package p9p
+
+import "time"
+
+// In wstat(5), fields of the Dir that should not be changed are set to "don't
+// touch" values: ~0 for numbers, DontTouchTime for times and empty strings.
+// The zero Dir instead changes everything to zero, so a Dir for WStat should
+// start from NewWStatDir or be built with WStatBuilder.
+
+var dontTouchQid = Qid{Type: ^QType(0), Version: ^uint32(0), Path: ^uint64(0)}
+
+// NewWStatDir returns a Dir for WStat that changes nothing.
+func NewWStatDir() Dir {
+	return Dir{
+		Type:       ^uint16(0),
+		Dev:        ^uint32(0),
+		Qid:        dontTouchQid,
+		Mode:       ^uint32(0),
+		AccessTime: DontTouchTime,
+		ModTime:    DontTouchTime,
+		Length:     ^uint64(0),
+		NUid:       ^uint32(0),
+		NGid:       ^uint32(0),
+		NMuid:      ^uint32(0),
+	}
+}
+
+// WStatBuilder builds a Dir for WStat that changes only the fields that are
+// set:
+//
+//	dir := p9p.NewWStatBuilder().SetMode(0644).SetLength(0).Dir()
+type WStatBuilder struct {
+	dir Dir
+}
+
+// NewWStatBuilder returns a builder for a Dir that changes nothing.
+func NewWStatBuilder() *WStatBuilder {
+	return &WStatBuilder{dir: NewWStatDir()}
+}
+
+// SetMode changes the mode, including the permission bits.
+func (b *WStatBuilder) SetMode(mode uint32) *WStatBuilder {
+	b.dir.Mode = mode
+	return b
+}
+
+// SetName renames the file within its directory. Since the empty string
+// means "don't touch", a file cannot be renamed to it.
+func (b *WStatBuilder) SetName(name string) *WStatBuilder {
+	b.dir.Name = name
+	return b
+}
+
+// SetLength truncates or extends the file to length.
+func (b *WStatBuilder) SetLength(length uint64) *WStatBuilder {
+	b.dir.Length = length
+	return b
+}
+
+// SetTimes changes the access and modification times. Either may be
+// DontTouchTime to leave it unchanged.
+func (b *WStatBuilder) SetTimes(atime, mtime time.Time) *WStatBuilder {
+	b.dir.AccessTime = atime
+	b.dir.ModTime = mtime
+	return b
+}
+
+// SetUID changes the owner of the file.
+func (b *WStatBuilder) SetUID(uid string) *WStatBuilder {
+	b.dir.UID = uid
+	return b
+}
+
+// SetGID changes the group of the file.
+func (b *WStatBuilder) SetGID(gid string) *WStatBuilder {
+	b.dir.GID = gid
+	return b
+}
+
+// Dir returns the Dir to pass to WStat.
+func (b *WStatBuilder) Dir() Dir {
+	return b.dir
+}
+
+// WStatMask is a set of the fields of a Dir to be changed by WStat.
+type WStatMask uint32
+
+// Fields for WStatMask. Servers typically refuse to change the type, dev,
+// qid and muid.
+const (
+	WStatType WStatMask = 1 << iota
+	WStatDev
+	WStatQid
+	WStatMode
+	WStatAccessTime
+	WStatModTime
+	WStatLength
+	WStatName
+	WStatUID
+	WStatGID
+	WStatMUID
+)
+
+// WStatMask returns the fields of d to be changed when passed to WStat,
+// those that are not set to "don't touch". Sessions should use it rather than
+// checking for the sentinels themselves. A zero mask, as sent by wstat(5) to
+// sync a file, changes nothing.
+func (d Dir) WStatMask() WStatMask {
+	var mask WStatMask
+
+	for _, field := range []struct {
+		set  bool
+		mask WStatMask
+	}{
+		{d.Type != ^uint16(0), WStatType},
+		{d.Dev != ^uint32(0), WStatDev},
+		{d.Qid != dontTouchQid, WStatQid},
+		{d.Mode != ^uint32(0), WStatMode},
+		{!d.AccessTime.Equal(DontTouchTime), WStatAccessTime},
+		{!d.ModTime.Equal(DontTouchTime), WStatModTime},
+		{d.Length != ^uint64(0), WStatLength},
+		{d.Name != "", WStatName},
+		{d.UID != "", WStatUID},
+		{d.GID != "", WStatGID},
+		{d.MUID != "", WStatMUID},
+	} {
+		if field.set {
+			mask |= field.mask
+		}
+	}
+
+	return mask
+}
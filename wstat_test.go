@@ -0,0 +1,68 @@
+package p9p
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWStatMask(t *testing.T) {
+	mtime := time.Date(2006, 01, 02, 03, 04, 05, 0, time.UTC)
+
+	for _, testcase := range []struct {
+		name     string
+		dir      Dir
+		expected WStatMask
+	}{
+		{
+			name: "DontTouch",
+			dir:  NewWStatDir(),
+		},
+		{
+			name:     "Zero",
+			dir:      Dir{},
+			expected: WStatType | WStatDev | WStatQid | WStatMode | WStatAccessTime | WStatModTime | WStatLength,
+		},
+		{
+			name:     "Mode",
+			dir:      NewWStatBuilder().SetMode(0644).Dir(),
+			expected: WStatMode,
+		},
+		{
+			name:     "NameLength",
+			dir:      NewWStatBuilder().SetName("file").SetLength(0).Dir(),
+			expected: WStatName | WStatLength,
+		},
+		{
+			name:     "ModTime",
+			dir:      NewWStatBuilder().SetTimes(DontTouchTime, mtime).Dir(),
+			expected: WStatModTime,
+		},
+		{
+			name:     "Owner",
+			dir:      NewWStatBuilder().SetUID("glenda").SetGID("sys").Dir(),
+			expected: WStatUID | WStatGID,
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			if mask := testcase.dir.WStatMask(); mask != testcase.expected {
+				t.Fatalf("unexpected mask: %b != %b", mask, testcase.expected)
+			}
+
+			// the mask survives the wire.
+			codec := NewCodec()
+			p, err := codec.Marshal(newFcall(1, MessageTwstat{Fid: 1, Stat: testcase.dir}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var fcall Fcall
+			if err := codec.Unmarshal(p, &fcall); err != nil {
+				t.Fatal(err)
+			}
+
+			if mask := fcall.Message.(MessageTwstat).Stat.WStatMask(); mask != testcase.expected {
+				t.Fatalf("unexpected mask after decoding: %b != %b", mask, testcase.expected)
+			}
+		})
+	}
+}